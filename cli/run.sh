@@ -157,7 +157,7 @@ build_app() {
         exit 1
     fi
 
-    go build -o "$BINARY_NAME" main.go
+    go build -o "$BINARY_NAME" .
     chmod +x "$BINARY_NAME"
 
     print_success "Application built successfully: $BINARY_NAME"
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skpjr001/oneclick-kra-sftp-sync/sftpsync"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookieName     = "sftpsync_session"
+	csrfHeaderName        = "X-CSRF-Token"
+	csrfFormField         = "csrf_token"
+	defaultSessionTimeout = 12 * time.Hour
+
+	// A username is refused further logins from a client address for the rest
+	// of the window once this many have failed from there within it
+	maxLoginFailures   = 5
+	loginFailureWindow = 15 * time.Minute
+)
+
+// dummyPasswordHash is compared against when the username is unknown, so that a
+// failed login takes as long whether or not the user exists
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(randomToken()), bcrypt.DefaultCost)
+	return hash
+})
+
+// webAuth holds the configured logins and the active sessions. With no users
+// configured every visitor shares one anonymous session, which still carries a
+// CSRF token so other sites cannot drive the API through the browser.
+type webAuth struct {
+	users    map[string][]byte // username -> bcrypt hash
+	timeout  time.Duration
+	secure   bool // set the Secure flag on cookies (TLS is on)
+	sessions map[string]*webSession
+	failures *loginLimiter
+	hosts    map[string]bool // Host names answered without logins
+
+	anonymousID string
+	anonymous   *webSession
+
+	mutex sync.Mutex
+}
+
+type webSession struct {
+	username  string
+	csrfToken string
+	expires   time.Time
+}
+
+type sessionContextKey struct{}
+
+func newWebAuth(config sftpsync.WebConfigJSON, secure bool) (*webAuth, error) {
+	auth := &webAuth{
+		users:    make(map[string][]byte),
+		timeout:  defaultSessionTimeout,
+		secure:   secure,
+		sessions: make(map[string]*webSession),
+		failures: newLoginLimiter(),
+		hosts:    map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true},
+	}
+	if config.Bind != "" {
+		auth.hosts[strings.ToLower(config.Bind)] = true
+	}
+	if config.SessionTimeout > 0 {
+		auth.timeout = time.Duration(config.SessionTimeout) * time.Second
+	}
+
+	for _, user := range config.Users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("web user without a username")
+		}
+		if _, exists := auth.users[user.Username]; exists {
+			return nil, fmt.Errorf("web user %q is listed twice", user.Username)
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("web user %q: password_hash is not a bcrypt hash (generate one with --gui -hash-password)", user.Username)
+		}
+		auth.users[user.Username] = []byte(user.PasswordHash)
+	}
+
+	return auth, nil
+}
+
+// enabled reports whether logins are required
+func (a *webAuth) enabled() bool {
+	return len(a.users) > 0
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// session returns the live session named by the request's cookie, if any
+func (a *webAuth) session(r *http.Request) *webSession {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.anonymous != nil && cookie.Value == a.anonymousID {
+		return a.anonymous
+	}
+	session, ok := a.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.expires) {
+		delete(a.sessions, cookie.Value)
+		return nil
+	}
+	return session
+}
+
+// startSession creates a session for username and sets its cookie
+func (a *webAuth) startSession(rw http.ResponseWriter, username string) *webSession {
+	id := randomToken()
+	session := &webSession{
+		username:  username,
+		csrfToken: randomToken(),
+		expires:   time.Now().Add(a.timeout),
+	}
+
+	a.mutex.Lock()
+	now := time.Now()
+	for key, s := range a.sessions {
+		if now.After(s.expires) {
+			delete(a.sessions, key)
+		}
+	}
+	a.sessions[id] = session
+	a.mutex.Unlock()
+
+	a.setCookie(rw, id, session.expires)
+	return session
+}
+
+// anonymousSession returns the session shared by every visitor while logins are
+// disabled, creating it on first use, and sets its cookie. Sharing one keeps
+// clients that never send the cookie back, such as scripts, from adding a
+// session per request.
+func (a *webAuth) anonymousSession(rw http.ResponseWriter) *webSession {
+	a.mutex.Lock()
+	if a.anonymous == nil {
+		a.anonymousID = randomToken()
+		a.anonymous = &webSession{csrfToken: randomToken()}
+	}
+	id, session := a.anonymousID, a.anonymous
+	a.mutex.Unlock()
+
+	a.setCookie(rw, id, time.Time{})
+	return session
+}
+
+// setCookie sets the session cookie; a zero expires makes it last until the
+// browser closes
+func (a *webAuth) setCookie(rw http.ResponseWriter, id string, expires time.Time) {
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// forgetSession drops the request's session, if it has one
+func (a *webAuth) forgetSession(r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		a.mutex.Lock()
+		delete(a.sessions, cookie.Value)
+		a.mutex.Unlock()
+	}
+}
+
+// endSession forgets the request's session and clears its cookie
+func (a *webAuth) endSession(rw http.ResponseWriter, r *http.Request) {
+	a.forgetSession(r)
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// knownHost reports whether the request may be served without logins: its
+// Host must be the bind address or a loopback name, so that a page whose DNS
+// name was rebound to 127.0.0.1 cannot reach the GUI from the browser
+func (a *webAuth) knownHost(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+	}
+	return a.hosts[strings.ToLower(host)]
+}
+
+// require wraps a handler so that it only runs for a logged-in session, and so
+// that anything other than GET/HEAD carries the session's CSRF token
+func (a *webAuth) require(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !a.enabled() && !a.knownHost(r) {
+			http.Error(rw, "Unknown host", http.StatusForbidden)
+			return
+		}
+
+		session := a.session(r)
+		if session == nil {
+			if a.enabled() {
+				if strings.HasPrefix(r.URL.Path, "/api/") {
+					rw.Header().Set("Content-Type", "application/json")
+					rw.WriteHeader(http.StatusUnauthorized)
+					json.NewEncoder(rw).Encode(map[string]interface{}{
+						"success": false,
+						"error":   "Not logged in",
+					})
+					return
+				}
+				http.Redirect(rw, r, "/login", http.StatusSeeOther)
+				return
+			}
+			session = a.anonymousSession(rw)
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			token := r.Header.Get(csrfHeaderName)
+			if token == "" {
+				token = r.PostFormValue(csrfFormField)
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(session.csrfToken)) != 1 {
+				http.Error(rw, "Invalid or missing CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		next(rw, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, session)))
+	}
+}
+
+// requireBasic wraps a handler for machine clients such as a Prometheus scrape,
+// which cannot log in through the form: with logins enabled the request must
+// carry HTTP Basic credentials of a web user
+func (a *webAuth) requireBasic(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !a.enabled() && !a.knownHost(r) {
+			http.Error(rw, "Unknown host", http.StatusForbidden)
+			return
+		}
+		if a.enabled() {
+			username, password, ok := r.BasicAuth()
+			if wait := a.failures.blocked(r, username); ok && wait > 0 {
+				setRetryAfter(rw, wait)
+				http.Error(rw, "Too many failed logins", http.StatusTooManyRequests)
+				return
+			}
+			if !ok || !a.checkPassword(username, password) {
+				if ok {
+					a.failures.fail(r, username)
+				}
+				rw.Header().Set("WWW-Authenticate", `Basic realm="sftpsync", charset="UTF-8"`)
+				http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			a.failures.succeed(r, username)
+		}
+		next(rw, r)
+	}
+}
+
+// loginLimiter counts failed logins per client address and username. Counting
+// per username alone would let anyone lock a user out by failing on purpose.
+type loginLimiter struct {
+	failures map[string]*loginFailures
+	mutex    sync.Mutex
+}
+
+type loginFailures struct {
+	count int
+	since time.Time // the first failure of the current window
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{failures: make(map[string]*loginFailures)}
+}
+
+// limiterKey names the client address and the username of a login attempt.
+// The address is the connection's, as X-Forwarded-For can be set by anyone.
+func limiterKey(r *http.Request, username string) string {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	return address + " " + username
+}
+
+// blocked returns how long the request's address must wait before trying
+// username again, or 0 if it may try now
+func (l *loginLimiter) blocked(r *http.Request, username string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	f, ok := l.failures[limiterKey(r, username)]
+	if !ok || f.count < maxLoginFailures {
+		return 0
+	}
+	if remaining := time.Until(f.since.Add(loginFailureWindow)); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// fail records a failed login for the request's address and username
+func (l *loginLimiter) fail(r *http.Request, username string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	for key, f := range l.failures {
+		if now.Sub(f.since) >= loginFailureWindow {
+			delete(l.failures, key)
+		}
+	}
+	key := limiterKey(r, username)
+	if f, ok := l.failures[key]; ok {
+		f.count++
+	} else {
+		l.failures[key] = &loginFailures{count: 1, since: now}
+	}
+}
+
+// succeed forgets the failures of the request's address and username
+func (l *loginLimiter) succeed(r *http.Request, username string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.failures, limiterKey(r, username))
+}
+
+// setRetryAfter sets the Retry-After header of a refused login
+func setRetryAfter(rw http.ResponseWriter, wait time.Duration) {
+	rw.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+}
+
+// postOnly rejects anything but POST, so state-changing endpoints cannot be
+// triggered by a plain link or image that would skip the CSRF check
+func postOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next(rw, r)
+	}
+}
+
+// sessionFrom returns the session that require attached to the request
+func sessionFrom(r *http.Request) *webSession {
+	session, _ := r.Context().Value(sessionContextKey{}).(*webSession)
+	if session == nil {
+		return &webSession{}
+	}
+	return session
+}
+
+// checkPassword reports whether password is correct for username
+func (a *webAuth) checkPassword(username, password string) bool {
+	hash, ok := a.users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Sign in - SFTP Sync Tool</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 0; background-color: #f5f5f5; }
+        .container { max-width: 360px; margin: 80px auto; background: white; padding: 24px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        h1 { font-size: 22px; margin-top: 0; }
+        label { display: block; margin-top: 12px; font-size: 14px; color: #333; }
+        input { width: 100%; box-sizing: border-box; padding: 8px; margin-top: 4px; border: 1px solid #ccc; border-radius: 4px; }
+        button { width: 100%; margin-top: 20px; padding: 10px; border: none; border-radius: 4px; background-color: #007bff; color: white; font-size: 16px; cursor: pointer; }
+        .error { margin-top: 12px; padding: 8px; border-radius: 4px; background-color: #f8d7da; color: #721c24; font-size: 14px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>SFTP Sync Tool</h1>
+        <form method="POST" action="/login">
+            <label>Username <input name="username" autocomplete="username" autofocus required></label>
+            <label>Password <input name="password" type="password" autocomplete="current-password" required></label>
+            {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+            <button type="submit">Sign in</button>
+        </form>
+    </div>
+</body>
+</html>
+`))
+
+func (a *webAuth) loginHandler(rw http.ResponseWriter, r *http.Request) {
+	if !a.enabled() {
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rw.Header().Set("Content-Type", "text/html")
+		loginTemplate.Execute(rw, map[string]string{})
+	case http.MethodPost:
+		username := r.PostFormValue("username")
+		if wait := a.failures.blocked(r, username); wait > 0 {
+			setRetryAfter(rw, wait)
+			rw.Header().Set("Content-Type", "text/html")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			loginTemplate.Execute(rw, map[string]string{"Error": fmt.Sprintf("Too many failed sign-ins; try again in %d minutes", int(wait/time.Minute)+1)})
+			return
+		}
+		if !a.checkPassword(username, r.PostFormValue("password")) {
+			a.failures.fail(r, username)
+			rw.Header().Set("Content-Type", "text/html")
+			rw.WriteHeader(http.StatusUnauthorized)
+			loginTemplate.Execute(rw, map[string]string{"Error": "Invalid username or password"})
+			return
+		}
+		a.failures.succeed(r, username)
+		// A fresh session id on login, so a planted cookie cannot be promoted
+		a.forgetSession(r)
+		a.startSession(rw, username)
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+	default:
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// logoutHandler ends the session; it is registered behind require and postOnly
+func (a *webAuth) logoutHandler(rw http.ResponseWriter, r *http.Request) {
+	a.endSession(rw, r)
+	http.Redirect(rw, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// eventBufferSize is how many recent log events are kept for new and reconnecting clients
+	eventBufferSize = 1000
+	// eventKeepAlive is how often an idle stream gets a comment so proxies keep it open
+	eventKeepAlive = 15 * time.Second
+)
+
+// Event types sent on /api/events
+const (
+	eventLog      = "log"
+	eventStatus   = "status"
+	eventProgress = "progress"
+)
+
+// webEvent is one Server-Sent Event; Data is already JSON encoded
+type webEvent struct {
+	ID   int64
+	Type string
+	Data []byte
+}
+
+// eventHub fans events out to streaming clients. Log lines are a stream: the
+// most recent ones are buffered so a slow client skips nothing and a reconnecting
+// one resumes from Last-Event-ID. Status and progress are state: only the latest
+// of each is kept, and every client gets it on connect and whenever it changes.
+// All events share one id sequence, so Last-Event-ID covers both.
+type eventHub struct {
+	mutex       sync.Mutex
+	logs        []webEvent
+	latest      map[string]webEvent
+	nextID      int64
+	subscribers map[chan struct{}]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		latest:      make(map[string]webEvent),
+		nextID:      1,
+		subscribers: make(map[chan struct{}]bool),
+	}
+}
+
+// publish encodes data as an event of the given type and wakes all subscribers
+func (h *eventHub) publish(eventType string, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	event := webEvent{ID: h.nextID, Type: eventType, Data: encoded}
+	h.nextID++
+	if eventType == eventLog {
+		h.logs = append(h.logs, event)
+		if len(h.logs) > eventBufferSize {
+			h.logs = h.logs[len(h.logs)-eventBufferSize:]
+		}
+	} else {
+		h.latest[eventType] = event
+	}
+
+	for notify := range h.subscribers {
+		select {
+		case notify <- struct{}{}:
+		default: // already has a wake-up pending
+		}
+	}
+}
+
+// pending returns, in id order, the buffered log events after lastLog and the
+// state events newer than the ones in sent
+func (h *eventHub) pending(lastLog int64, sent map[string]int64) []webEvent {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var events []webEvent
+	for _, event := range h.logs {
+		if event.ID > lastLog {
+			events = append(events, event)
+		}
+	}
+	for eventType, event := range h.latest {
+		if event.ID > sent[eventType] {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events
+}
+
+func (h *eventHub) subscribe() chan struct{} {
+	notify := make(chan struct{}, 1)
+	h.mutex.Lock()
+	h.subscribers[notify] = true
+	h.mutex.Unlock()
+	return notify
+}
+
+func (h *eventHub) unsubscribe(notify chan struct{}) {
+	h.mutex.Lock()
+	delete(h.subscribers, notify)
+	h.mutex.Unlock()
+}
+
+// eventsHandler streams log, status and progress events. A new client first
+// receives the buffered log history; a reconnecting client sends Last-Event-ID
+// (or ?lastEventId=) and receives only the log lines it missed, as far back as
+// the buffer reaches. Both get the current status and progress straight away.
+func (w *WebGUI) eventsHandler(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	var last int64
+	if lastID != "" {
+		parsed, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil {
+			http.Error(rw, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		last = parsed
+	}
+
+	notify := w.events.subscribe()
+	defer w.events.unsubscribe(notify)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(rw, "retry: 3000\n\n")
+
+	sent := make(map[string]int64)
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		for _, event := range w.events.pending(last, sent) {
+			if _, err := fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data); err != nil {
+				return
+			}
+			if event.Type == eventLog {
+				last = event.ID
+			} else {
+				sent[event.Type] = event.ID
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-notify:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(rw, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -15,6 +16,8 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/skpjr001/oneclick-kra-sftp-sync/sftpsync"
+	"golang.org/x/crypto/ssh"
 )
 
 // NativeGUI implements a native GUI for SFTP synchronization
@@ -25,10 +28,13 @@ type NativeGUI struct {
 	startBtn    *widget.Button
 	stopBtn     *widget.Button
 	configBtn   *widget.Button
+	historyBtn  *widget.Button
 	exitBtn     *widget.Button
 	statusLabel *widget.Label
 	logText     *widget.Label
 	progressBar *widget.ProgressBarInfinite
+	transferBar *widget.ProgressBar
+	detailLabel *widget.Label
 
 	// Sync state
 	syncCtx    context.Context
@@ -83,6 +89,12 @@ func (g *NativeGUI) setupUI() {
 	g.progressBar = widget.NewProgressBarInfinite()
 	g.progressBar.Hide()
 
+	// Determinate bar and counts for the transfer and delete phases
+	g.transferBar = widget.NewProgressBar()
+	g.transferBar.Hide()
+	g.detailLabel = widget.NewLabel("")
+	g.detailLabel.Alignment = fyne.TextAlignCenter
+
 	// Control buttons
 	g.startBtn = widget.NewButton("Start Sync", func() {
 		// Button action handled in event handler to avoid multiple registrations
@@ -102,6 +114,11 @@ func (g *NativeGUI) setupUI() {
 	})
 	g.configBtn.SetIcon(theme.SettingsIcon())
 
+	g.historyBtn = widget.NewButton("History", func() {
+		// Button action handled in event handler to avoid multiple registrations
+	})
+	g.historyBtn.SetIcon(theme.HistoryIcon())
+
 	g.exitBtn = widget.NewButton("Exit", func() {
 		// Button action handled in event handler to avoid multiple registrations
 	})
@@ -121,13 +138,16 @@ func (g *NativeGUI) setupUI() {
 		widget.NewCard("Status", "", container.NewVBox(
 			g.statusLabel,
 			g.progressBar,
+			g.transferBar,
+			g.detailLabel,
 		)),
 	)
 
-	buttonContainer := container.NewGridWithColumns(4,
+	buttonContainer := container.NewGridWithColumns(5,
 		g.startBtn,
 		g.stopBtn,
 		g.configBtn,
+		g.historyBtn,
 		g.exitBtn,
 	)
 
@@ -156,6 +176,7 @@ func (g *NativeGUI) setupEventHandlers() {
 	g.startBtn.OnTapped = g.onStartClick
 	g.stopBtn.OnTapped = g.onStopClick
 	g.configBtn.OnTapped = g.onConfigClick
+	g.historyBtn.OnTapped = g.onHistoryClick
 	g.exitBtn.OnTapped = g.onExitClick
 
 	g.window.SetCloseIntercept(func() {
@@ -176,17 +197,6 @@ func (g *NativeGUI) AddLog(msg string) {
 		return
 	}
 
-	// Filter out noise after cancellation
-	if g.cancelled {
-		if strings.Contains(msg, "connection lost") ||
-			strings.Contains(msg, "failed to read directory") ||
-			strings.Contains(msg, "Error scanning") ||
-			strings.Contains(msg, "context canceled") ||
-			strings.Contains(msg, "EOF") {
-			return
-		}
-	}
-
 	// Limit message length to prevent UI issues
 	if len(msg) > 200 {
 		msg = msg[:200] + "..."
@@ -279,6 +289,13 @@ func (g *NativeGUI) UpdateRunningState(running bool) {
 				g.progressBar.Show()
 				g.progressBar.Start()
 			}
+			if g.transferBar != nil {
+				g.transferBar.SetValue(0)
+				g.transferBar.Hide()
+			}
+			if g.detailLabel != nil {
+				g.detailLabel.SetText("")
+			}
 		} else {
 			if g.startBtn != nil {
 				g.startBtn.Enable()
@@ -290,6 +307,9 @@ func (g *NativeGUI) UpdateRunningState(running bool) {
 				g.progressBar.Stop()
 				g.progressBar.Hide()
 			}
+			if g.transferBar != nil {
+				g.transferBar.Hide()
+			}
 		}
 	})
 }
@@ -422,6 +442,104 @@ func (g *NativeGUI) onConfigClick() {
 	configDialog.Show()
 }
 
+// onHistoryClick shows past runs; selecting one shows its phases and files
+func (g *NativeGUI) onHistoryClick() {
+	history, err := openHistory()
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Run history unavailable: %v", err), g.window)
+		return
+	}
+	runs, err := history.Runs(historyListLimit)
+	history.Close()
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Failed to read run history: %v", err), g.window)
+		return
+	}
+
+	details := widget.NewLabel("Select a run to see its phases and files")
+	details.Wrapping = fyne.TextWrapWord
+	details.TextStyle = fyne.TextStyle{Monospace: true}
+
+	runList := widget.NewList(
+		func() int { return len(runs) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, item fyne.CanvasObject) {
+			run := runs[i]
+			item.(*widget.Label).SetText(fmt.Sprintf("%s  %s  (%s)", run.Started.Local().Format("2006-01-02 15:04"), run.Result, run.Trigger))
+		},
+	)
+	runList.OnSelected = func(i widget.ListItemID) {
+		history, err := openHistory()
+		if err != nil {
+			details.SetText(err.Error())
+			return
+		}
+		defer history.Close()
+		run, err := history.Run(runs[i].ID)
+		if err != nil {
+			details.SetText(err.Error())
+			return
+		}
+		details.SetText(formatRunDetails(run))
+	}
+
+	var content fyne.CanvasObject = runList
+	if len(runs) == 0 {
+		content = widget.NewLabel("No runs recorded yet")
+	}
+	split := container.NewHSplit(content, container.NewScroll(details))
+	split.Offset = 0.35
+
+	historyDialog := dialog.NewCustom("Run History", "Close", split, g.window)
+	historyDialog.Resize(fyne.NewSize(900, 600))
+	historyDialog.Show()
+}
+
+// formatRunDetails renders one run for the history dialog
+func formatRunDetails(run *sftpsync.RunRecord) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run %s\n", run.ID)
+	fmt.Fprintf(&b, "Result: %s\n", run.Result)
+	if run.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", run.Error)
+	}
+	fmt.Fprintf(&b, "Trigger: %s, configuration %s\n", run.Trigger, run.ConfigHash)
+	fmt.Fprintf(&b, "%s -> %s\n", run.Source, run.Dest)
+	if run.Dates != "" {
+		fmt.Fprintf(&b, "Dates: %s\n", run.Dates)
+	}
+	fmt.Fprintf(&b, "Started %s, took %v\n", run.Started.Local().Format("2006-01-02 15:04:05"), run.Finished.Sub(run.Started).Round(time.Second))
+	if s := run.Summary; s != nil {
+		fmt.Fprintf(&b, "%d transferred, %d skipped, %d failed, %d deleted, %s\n",
+			s.TransferredFiles, s.SkippedFiles, s.FailedFiles, s.DeletedFiles, formatByteCount(s.TotalBytes))
+	}
+
+	b.WriteString("\nPhases:\n")
+	for _, p := range run.Phases {
+		fmt.Fprintf(&b, "  %-12s %v", p.Phase, p.Duration.Round(time.Millisecond))
+		if p.Error != "" {
+			fmt.Fprintf(&b, "  %s", p.Error)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\nFiles:\n")
+	if len(run.Files) == 0 {
+		b.WriteString("  No files were transferred or deleted\n")
+	}
+	for _, f := range run.Files {
+		fmt.Fprintf(&b, "  %-11s %s (%s)", f.Outcome, f.Path, formatByteCount(f.Size))
+		if f.Hash != "" {
+			fmt.Fprintf(&b, " md5:%s", f.Hash)
+		}
+		if f.Error != "" {
+			fmt.Fprintf(&b, "\n      %s", f.Error)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
 // onExitClick handles the Exit button click
 func (g *NativeGUI) onExitClick() {
 	if g.isRunning {
@@ -454,8 +572,16 @@ type SafeLogWriter struct {
 	gui *NativeGUI
 }
 
-// Write implements io.Writer interface
+// Write implements io.Writer interface. Once the user has cancelled, the
+// engine's output is only the fallout of closing its connections, so it is dropped.
 func (w *SafeLogWriter) Write(p []byte) (n int, err error) {
+	w.gui.mutex.RLock()
+	cancelled := w.gui.cancelled
+	w.gui.mutex.RUnlock()
+	if cancelled {
+		return len(p), nil
+	}
+
 	msg := string(p)
 	msg = strings.TrimSpace(msg)
 
@@ -466,6 +592,67 @@ func (w *SafeLogWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// handleEvent drives the progress display from the engine's events
+func (g *NativeGUI) handleEvent(e sftpsync.Event) {
+	switch e.Type {
+	case sftpsync.EventProgress:
+		p := *e.Progress
+		g.updateUI(func() {
+			if g.transferBar == nil || g.progressBar == nil || g.detailLabel == nil {
+				return
+			}
+			switch p.Phase {
+			case sftpsync.PhaseTransferring, sftpsync.PhaseDeleting:
+				fraction := 0.0
+				if p.BytesTotal > 0 {
+					fraction = float64(p.BytesDone) / float64(p.BytesTotal)
+				} else if p.FilesTotal > 0 {
+					fraction = float64(p.FilesDone) / float64(p.FilesTotal)
+				}
+				g.progressBar.Stop()
+				g.progressBar.Hide()
+				g.transferBar.Show()
+				g.transferBar.SetValue(min(fraction, 1))
+				if p.Phase == sftpsync.PhaseDeleting {
+					g.detailLabel.SetText(fmt.Sprintf("Deleting %d/%d files", p.FilesDone, p.FilesTotal))
+				} else {
+					g.detailLabel.SetText(fmt.Sprintf("Transferring %d/%d files (%s/s)", p.FilesDone, p.FilesTotal, formatByteCount(int64(p.Rate))))
+				}
+			case sftpsync.PhaseDone:
+				g.transferBar.SetValue(1)
+			default:
+				g.transferBar.Hide()
+				g.progressBar.Show()
+				g.progressBar.Start()
+				g.detailLabel.SetText(strings.ToUpper(p.Phase[:1]) + p.Phase[1:] + "...")
+			}
+		})
+
+	case sftpsync.EventRunSummary:
+		summary := *e.Summary
+		g.updateUI(func() {
+			if g.detailLabel != nil {
+				g.detailLabel.SetText(fmt.Sprintf("%d transferred, %d skipped, %d failed",
+					summary.TransferredFiles, summary.SkippedFiles, summary.FailedFiles))
+			}
+		})
+	}
+}
+
+// formatByteCount renders a byte count for the progress label
+func formatByteCount(bytes int64) string {
+	switch {
+	case bytes > 1024*1024*1024:
+		return fmt.Sprintf("%.2f GB", float64(bytes)/(1024*1024*1024))
+	case bytes > 1024*1024:
+		return fmt.Sprintf("%.2f MB", float64(bytes)/(1024*1024))
+	case bytes > 1024:
+		return fmt.Sprintf("%.2f KB", float64(bytes)/1024)
+	default:
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+}
+
 // runSync runs the synchronization process
 func (g *NativeGUI) runSync() {
 	// Ensure cleanup happens
@@ -500,7 +687,7 @@ func (g *NativeGUI) runSync() {
 
 	// Load configuration
 	configPath := "config.json"
-	config, err := LoadConfig(configPath)
+	config, err := sftpsync.LoadConfig(configPath)
 	if err != nil {
 		g.AddLog(fmt.Sprintf("Failed to load configuration: %v", err))
 		g.SetStatus("Error - Check config")
@@ -508,19 +695,20 @@ func (g *NativeGUI) runSync() {
 	}
 
 	// Convert configs
-	sourceConfig := ConvertToSFTPConfig(config.Source)
-	destConfig := ConvertToSFTPConfig(config.Destination)
-	syncConfig := ConvertToSyncConfig(config.Sync)
+	sourceConfig := sftpsync.ConvertToSFTPConfig(config.Source)
+	destConfig := sftpsync.ConvertToSFTPConfig(config.Destination)
+	syncConfig := sftpsync.ConvertToSyncConfig(config.Sync)
 
 	// Validate configuration
-	if sourceConfig.Host == "" || sourceConfig.Username == "" {
-		g.AddLog("Source SFTP configuration is incomplete")
-		g.SetStatus("Error - Source config incomplete")
+	if err := sftpsync.ValidateConfig(sourceConfig, destConfig, syncConfig); err != nil {
+		g.AddLog(fmt.Sprintf("Configuration is invalid: %v", err))
+		g.SetStatus("Error - Check config")
 		return
 	}
-	if destConfig.Host == "" || destConfig.Username == "" {
-		g.AddLog("Destination SFTP configuration is incomplete")
-		g.SetStatus("Error - Dest config incomplete")
+	notifier, err := sftpsync.NewNotifier(config.Notifications)
+	if err != nil {
+		g.AddLog(fmt.Sprintf("Notifications configuration is invalid: %v", err))
+		g.SetStatus("Error - Notifications config invalid")
 		return
 	}
 
@@ -528,14 +716,19 @@ func (g *NativeGUI) runSync() {
 	g.AddLog(fmt.Sprintf("Destination: %s@%s:%d", destConfig.Username, destConfig.Host, destConfig.Port))
 
 	// Create syncer
-	syncer := NewSFTPSync(sourceConfig, destConfig, syncConfig)
+	syncer := sftpsync.NewSFTPSync(sourceConfig, destConfig, syncConfig)
+	syncer.HostKeyPrompt = g.promptHostKey
+	syncer.PassphrasePrompt = g.promptPassphrase
+	syncer.Trigger = "native"
+	syncer.Subscribe(g.handleEvent)
+	notifier.Observe(syncer)
 
 	// Run sync with context cancellation support
 	err = syncer.SyncWithContext(g.syncCtx)
 
 	// Update final status
 	if err != nil {
-		if err == context.Canceled {
+		if errors.Is(err, context.Canceled) {
 			g.AddLog("Sync cancelled by user")
 			g.SetStatus("Cancelled")
 		} else {
@@ -548,6 +741,67 @@ func (g *NativeGUI) runSync() {
 	}
 }
 
+// promptHostKey asks the user whether an unknown host key should be trusted
+// and blocks until they answer or the sync is cancelled
+func (g *NativeGUI) promptHostKey(host string, key ssh.PublicKey) bool {
+	reply := make(chan bool, 1)
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	g.SetStatus("Waiting for host key confirmation")
+	g.AddLog(fmt.Sprintf("New host key for %s: %s %s - waiting for confirmation", host, key.Type(), fingerprint))
+
+	g.updateUI(func() {
+		message := fmt.Sprintf("New host key for %s\n\n%s %s\n\nVerify this fingerprint with the server administrator.\nAccept and remember this key?",
+			host, key.Type(), fingerprint)
+		dialog.ShowConfirm("Unknown Host Key", message, func(accept bool) {
+			reply <- accept
+		}, g.window)
+	})
+
+	select {
+	case accepted := <-reply:
+		if accepted {
+			g.SetStatus("Running...")
+		}
+		return accepted
+	case <-g.syncCtx.Done():
+		return false
+	}
+}
+
+// promptPassphrase asks for the passphrase of an encrypted private key
+func (g *NativeGUI) promptPassphrase(keyFile string) (string, bool) {
+	type answer struct {
+		passphrase string
+		ok         bool
+	}
+	reply := make(chan answer, 1)
+
+	g.SetStatus("Waiting for key passphrase")
+	g.AddLog(fmt.Sprintf("Private key %s is encrypted - waiting for its passphrase", keyFile))
+
+	g.updateUI(func() {
+		entry := widget.NewPasswordEntry()
+		items := []*widget.FormItem{widget.NewFormItem("Passphrase", entry)}
+		form := dialog.NewForm("Key Passphrase", "Unlock", "Cancel", items, func(ok bool) {
+			reply <- answer{entry.Text, ok}
+		}, g.window)
+		form.Resize(fyne.NewSize(420, 160))
+		form.Show()
+		g.window.Canvas().Focus(entry)
+	})
+
+	select {
+	case a := <-reply:
+		if a.ok {
+			g.SetStatus("Running...")
+		}
+		return a.passphrase, a.ok
+	case <-g.syncCtx.Done():
+		return "", false
+	}
+}
+
 // Run starts the GUI application
 func (g *NativeGUI) Run() {
 	g.window.ShowAndRun()
@@ -1,17 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/skpjr001/oneclick-kra-sftp-sync/sftpsync"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 )
 
 type WebGUI struct {
@@ -22,13 +33,52 @@ type WebGUI struct {
 	logs        []string
 	logsMutex   sync.RWMutex
 	status      string
+	bind        string
 	port        string
+	tlsCert     string
+	tlsKey      string
+	auth        *webAuth
+	events      *eventHub
 	syncProcess *SyncProcess
 	cancelled   bool
+	hostKey     *pendingHostKey
+	passphrase  *pendingPassphrase
+	dateResults []sftpsync.DateResult
+	scheduler   *sftpsync.Scheduler
+	metrics     *sftpsync.Metrics
+}
+
+// pendingHostKey is an unknown host key waiting for the user to accept or reject it
+type pendingHostKey struct {
+	info  HostKeyPromptInfo
+	reply chan bool
+}
+
+// HostKeyPromptInfo describes an unknown host key shown to the user
+type HostKeyPromptInfo struct {
+	Host        string `json:"host"`
+	KeyType     string `json:"keyType"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// pendingPassphrase is an encrypted private key waiting for the user to unlock it
+type pendingPassphrase struct {
+	info  PassphrasePromptInfo
+	reply chan passphraseReply
+}
+
+// PassphrasePromptInfo names the encrypted key shown to the user
+type PassphrasePromptInfo struct {
+	KeyFile string `json:"keyFile"`
+}
+
+type passphraseReply struct {
+	passphrase string
+	ok         bool
 }
 
 type SyncProcess struct {
-	syncer     *SFTPSync
+	syncer     *sftpsync.SFTPSync
 	cancel     context.CancelFunc
 	logRestore func()
 	logPipe    *io.PipeWriter
@@ -37,29 +87,29 @@ type SyncProcess struct {
 }
 
 type StatusResponse struct {
-	IsRunning bool     `json:"isRunning"`
-	Status    string   `json:"status"`
-	Logs      []string `json:"logs"`
+	IsRunning     bool                  `json:"isRunning"`
+	Status        string                `json:"status"`
+	Logs          []string              `json:"logs,omitempty"`
+	HostKeyPrompt *HostKeyPromptInfo    `json:"hostKeyPrompt,omitempty"`
+	Passphrase    *PassphrasePromptInfo `json:"passphrasePrompt,omitempty"`
+	DateResults   []sftpsync.DateResult `json:"dateResults,omitempty"`
+	Schedule      []sftpsync.JobStatus  `json:"schedule,omitempty"`
 }
 
 type LogWriter struct {
 	webGui *WebGUI
 }
 
+// Write implements io.Writer. Once the user has cancelled, the engine's output
+// is only the fallout of closing its connections, so it is dropped.
 func (lw *LogWriter) Write(p []byte) (n int, err error) {
-	msg := string(p)
-	msg = strings.TrimSpace(msg)
-
-	// Filter out error messages that happen after cancellation
 	if lw.webGui.cancelled {
-		if strings.Contains(msg, "connection lost") ||
-			strings.Contains(msg, "failed to read directory") ||
-			strings.Contains(msg, "Error scanning") {
-			// Suppress these error messages after cancellation
-			return len(p), nil
-		}
+		return len(p), nil
 	}
 
+	msg := string(p)
+	msg = strings.TrimSpace(msg)
+
 	if msg != "" {
 		lw.webGui.AddLog(msg)
 	}
@@ -67,12 +117,55 @@ func (lw *LogWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-func NewWebGUI() *WebGUI {
-	return &WebGUI{
-		logs:   make([]string, 0),
-		status: "Ready",
-		port:   "8080",
+// NewWebGUI sets up the web GUI from the web section of the configuration. It
+// listens on 127.0.0.1:8080 by default, and refuses any other address unless
+// logins are configured.
+func NewWebGUI(config sftpsync.WebConfigJSON) (*WebGUI, error) {
+	w := &WebGUI{
+		logs:    make([]string, 0),
+		status:  "Ready",
+		bind:    config.Bind,
+		port:    "8080",
+		tlsCert: config.TLSCert,
+		tlsKey:  config.TLSKey,
+		events:  newEventHub(),
+		metrics: sftpsync.NewMetrics(),
+	}
+	if w.bind == "" {
+		w.bind = "127.0.0.1"
+	}
+	if config.Port != 0 {
+		w.port = fmt.Sprint(config.Port)
+	}
+
+	if (w.tlsCert == "") != (w.tlsKey == "") {
+		return nil, fmt.Errorf("web tls_cert and tls_key must be set together")
+	}
+
+	auth, err := newWebAuth(config, w.tlsCert != "")
+	if err != nil {
+		return nil, err
 	}
+	w.auth = auth
+
+	if !auth.enabled() && !isLoopback(w.bind) {
+		return nil, fmt.Errorf("refusing to listen on %s without logins; add web users to the configuration or bind to 127.0.0.1", w.bind)
+	}
+	if auth.enabled() && w.tlsCert == "" && !isLoopback(w.bind) {
+		log.Printf("⚠️  Web GUI on %s without TLS: passwords and session cookies are sent in clear text", w.bind)
+	}
+
+	w.publishStatus()
+	return w, nil
+}
+
+// isLoopback reports whether a bind address only accepts local connections
+func isLoopback(bind string) bool {
+	if bind == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(bind)
+	return ip != nil && ip.IsLoopback()
 }
 
 func (w *WebGUI) AddLog(msg string) {
@@ -88,6 +181,7 @@ func (w *WebGUI) AddLog(msg string) {
 	timestamp := time.Now().Format("15:04:05")
 	logEntry := fmt.Sprintf("[%s] %s", timestamp, msg)
 	w.logs = append(w.logs, logEntry)
+	w.events.publish(eventLog, logEntry)
 
 	// Keep only last 500 log entries
 	if len(w.logs) > 500 {
@@ -97,8 +191,17 @@ func (w *WebGUI) AddLog(msg string) {
 
 func (w *WebGUI) SetStatus(status string) {
 	w.mutex.Lock()
-	defer w.mutex.Unlock()
 	w.status = status
+	w.mutex.Unlock()
+	w.publishStatus()
+}
+
+// publishStatus sends the current status, without logs, to streaming clients.
+// Callers must not hold w.mutex.
+func (w *WebGUI) publishStatus() {
+	status := w.GetStatus()
+	status.Logs = nil
+	w.events.publish(eventStatus, status)
 }
 
 func (w *WebGUI) GetStatus() StatusResponse {
@@ -107,10 +210,109 @@ func (w *WebGUI) GetStatus() StatusResponse {
 	defer w.mutex.RUnlock()
 	defer w.logsMutex.RUnlock()
 
-	return StatusResponse{
-		IsRunning: w.isRunning,
-		Status:    w.status,
-		Logs:      w.logs,
+	response := StatusResponse{
+		IsRunning:   w.isRunning,
+		Status:      w.status,
+		Logs:        w.logs,
+		DateResults: w.dateResults,
+	}
+	if w.hostKey != nil {
+		info := w.hostKey.info
+		response.HostKeyPrompt = &info
+	}
+	if w.passphrase != nil {
+		info := w.passphrase.info
+		response.Passphrase = &info
+	}
+	if w.scheduler != nil {
+		response.Schedule = w.scheduler.Status()
+	}
+	return response
+}
+
+// hostKeyPrompter returns a prompt that asks the browser whether an unknown host
+// key should be trusted, blocking until the user answers or ctx is cancelled
+func (w *WebGUI) hostKeyPrompter(ctx context.Context) sftpsync.HostKeyPrompt {
+	return func(host string, key ssh.PublicKey) bool {
+		return w.promptHostKey(ctx, host, key)
+	}
+}
+
+func (w *WebGUI) promptHostKey(ctx context.Context, host string, key ssh.PublicKey) bool {
+	reply := make(chan bool, 1)
+
+	w.mutex.Lock()
+	previousStatus := w.status
+	w.hostKey = &pendingHostKey{
+		info: HostKeyPromptInfo{
+			Host:        host,
+			KeyType:     key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+		},
+		reply: reply,
+	}
+	w.status = "Waiting for host key confirmation"
+	w.mutex.Unlock()
+	w.publishStatus()
+
+	w.AddLog(fmt.Sprintf("New host key for %s: %s %s - waiting for confirmation", host, key.Type(), ssh.FingerprintSHA256(key)))
+
+	defer func() {
+		w.mutex.Lock()
+		w.hostKey = nil
+		w.mutex.Unlock()
+		w.publishStatus()
+	}()
+
+	select {
+	case accepted := <-reply:
+		if accepted {
+			w.SetStatus(previousStatus)
+		}
+		return accepted
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// passphrasePrompter returns a prompt that asks the browser for the passphrase
+// of an encrypted private key, blocking until the user answers or ctx is cancelled
+func (w *WebGUI) passphrasePrompter(ctx context.Context) sftpsync.PassphrasePrompt {
+	return func(keyFile string) (string, bool) {
+		return w.promptPassphrase(ctx, keyFile)
+	}
+}
+
+func (w *WebGUI) promptPassphrase(ctx context.Context, keyFile string) (string, bool) {
+	reply := make(chan passphraseReply, 1)
+
+	w.mutex.Lock()
+	previousStatus := w.status
+	w.passphrase = &pendingPassphrase{
+		info:  PassphrasePromptInfo{KeyFile: keyFile},
+		reply: reply,
+	}
+	w.status = "Waiting for key passphrase"
+	w.mutex.Unlock()
+	w.publishStatus()
+
+	w.AddLog(fmt.Sprintf("Private key %s is encrypted - waiting for its passphrase", keyFile))
+
+	defer func() {
+		w.mutex.Lock()
+		w.passphrase = nil
+		w.mutex.Unlock()
+		w.publishStatus()
+	}()
+
+	select {
+	case answer := <-reply:
+		if answer.ok {
+			w.SetStatus(previousStatus)
+		}
+		return answer.passphrase, answer.ok
+	case <-ctx.Done():
+		return "", false
 	}
 }
 
@@ -121,6 +323,7 @@ func (w *WebGUI) indexHandler(rw http.ResponseWriter, r *http.Request) {
 <head>
     <title>SFTP Sync Tool</title>
     <meta charset="utf-8">
+    <meta name="csrf-token" content="{{.CSRFToken}}">
     <style>
         body { font-family: Arial, sans-serif; margin: 20px; background-color: #f5f5f5; }
         .container { max-width: 1000px; margin: 0 auto; background: white; padding: 20px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
@@ -136,7 +339,31 @@ func (w *WebGUI) indexHandler(rw http.ResponseWriter, r *http.Request) {
         .btn-start { background-color: #28a745; color: white; }
         .btn-stop { background-color: #dc3545; color: white; }
         .btn-config { background-color: #17a2b8; color: white; }
+        .btn-preview { background-color: #ffc107; color: #212529; }
+        .dates { text-align: center; margin: 10px 0; font-size: 14px; }
+        .dates input { margin: 0 10px 0 5px; padding: 4px; }
+        .dates-hint { display: block; color: #6c757d; font-size: 12px; margin-top: 5px; }
+        .plan { display: none; margin-top: 20px; }
+        .plan-summary { font-size: 14px; font-weight: normal; color: #6c757d; }
+        .plan-container { max-height: 300px; overflow-y: auto; border: 1px solid #dee2e6; border-radius: 4px; }
+        .plan table { width: 100%; border-collapse: collapse; font-family: monospace; font-size: 13px; }
+        .plan th, .plan td { padding: 4px 8px; border-bottom: 1px solid #dee2e6; text-align: left; }
+        .plan th { background-color: #f8f9fa; position: sticky; top: 0; }
+        .session { text-align: right; font-size: 14px; color: #6c757d; }
+        .session button { padding: 4px 10px; font-size: 14px; background-color: #6c757d; color: white; }
         .btn-disabled { background-color: #6c757d; color: white; cursor: not-allowed; }
+        .progress { display: none; margin: 10px auto; max-width: 600px; }
+        .progress-track { background-color: #e9ecef; border-radius: 4px; height: 16px; overflow: hidden; }
+        .progress-bar { background-color: #28a745; height: 100%; width: 0; transition: width 0.3s; }
+        .progress-text { text-align: center; font-size: 13px; color: #495057; margin-top: 4px; }
+        .schedule { display: none; margin-top: 20px; }
+        .schedule table { width: 100%; border-collapse: collapse; font-size: 14px; }
+        .schedule th, .schedule td { padding: 4px 8px; border-bottom: 1px solid #dee2e6; text-align: left; }
+        .schedule th { background-color: #f8f9fa; }
+        .result-failed { color: #721c24; }
+        .passphrase { display: none; text-align: center; margin: 10px 0; font-size: 14px; }
+        .passphrase input { margin: 0 10px 0 5px; padding: 4px; }
+        .passphrase button { padding: 4px 10px; font-size: 14px; }
         .logs { margin-top: 20px; }
         .log-container { background-color: #f8f9fa; border: 1px solid #dee2e6; border-radius: 4px; padding: 10px; height: 400px; overflow-y: auto; font-family: monospace; font-size: 14px; }
         .spinner { display: none; border: 4px solid #f3f3f3; border-top: 4px solid #3498db; border-radius: 50%; width: 20px; height: 20px; animation: spin 1s linear infinite; margin: 0 auto; }
@@ -145,6 +372,13 @@ func (w *WebGUI) indexHandler(rw http.ResponseWriter, r *http.Request) {
 </head>
 <body>
     <div class="container">
+        {{if .Username}}
+        <form class="session" method="POST" action="/logout">
+            Signed in as {{.Username}}
+            <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+            <button type="submit">Log out</button>
+        </form>
+        {{end}}
         <h1>SFTP Sync Tool</h1>
 
         <div class="status">
@@ -152,10 +386,48 @@ func (w *WebGUI) indexHandler(rw http.ResponseWriter, r *http.Request) {
             <div id="spinner" class="spinner"></div>
         </div>
 
+        <form id="passphrase" class="passphrase" onsubmit="answerPassphrase(event, true)">
+            <label>Passphrase for <span id="passphrase-key"></span>
+                <input type="password" id="passphrase-input" autocomplete="off"></label>
+            <button type="submit" class="btn-start">Unlock</button>
+            <button type="button" class="btn-stop" onclick="answerPassphrase(event, false)">Cancel</button>
+        </form>
+
+        <div id="progress" class="progress">
+            <div class="progress-track"><div id="progress-bar" class="progress-bar"></div></div>
+            <div id="progress-text" class="progress-text"></div>
+        </div>
+
         <div class="buttons">
             <button id="start-btn" class="btn-start" onclick="startSync()">Start Sync</button>
             <button id="stop-btn" class="btn-stop btn-disabled" onclick="stopSync()" disabled>Stop</button>
+            <button id="preview-btn" class="btn-preview" onclick="previewSync()">Preview</button>
             <button id="config-btn" class="btn-config" onclick="showConfig()">Config</button>
+            <button id="history-btn" class="btn-config" onclick="showHistory()">History</button>
+        </div>
+
+        <div class="dates">
+            <label>From <input type="date" id="from-date"></label>
+            <label>To <input type="date" id="to-date"></label>
+            <span class="dates-hint">Leave empty to sync the last days_to_sync days; set both to the same day for a single date</span>
+        </div>
+
+        <div id="plan" class="plan">
+            <h3>Preview <span id="plan-summary" class="plan-summary"></span></h3>
+            <div class="plan-container">
+                <table>
+                    <thead><tr><th>Reason</th><th>Size</th><th>Modified</th><th>File</th></tr></thead>
+                    <tbody id="plan-body"></tbody>
+                </table>
+            </div>
+        </div>
+
+        <div id="schedule" class="schedule">
+            <h3>Schedule</h3>
+            <table>
+                <thead><tr><th>Job</th><th>Cron</th><th>Next run</th><th>Last run</th><th>Result</th></tr></thead>
+                <tbody id="schedule-body"></tbody>
+            </table>
         </div>
 
         <div class="logs">
@@ -166,59 +438,174 @@ func (w *WebGUI) indexHandler(rw http.ResponseWriter, r *http.Request) {
 
     <script>
         let isRunning = false;
+        const csrfToken = document.querySelector('meta[name="csrf-token"]').content;
+
+        // applyStatus updates the status line, buttons and host key prompt
+        function applyStatus(data) {
+            isRunning = data.isRunning;
+
+            const statusText = document.getElementById('status-text');
+            const spinner = document.getElementById('spinner');
+            const startBtn = document.getElementById('start-btn');
+            const stopBtn = document.getElementById('stop-btn');
+
+            statusText.textContent = data.status;
+
+            if (data.hostKeyPrompt) {
+                confirmHostKey(data.hostKeyPrompt);
+            }
+            showPassphrase(data.passphrasePrompt);
+            renderSchedule(data.schedule);
+
+            if (data.isRunning) {
+                statusText.className = 'status-text status-running';
+                spinner.style.display = 'block';
+                startBtn.disabled = true;
+                startBtn.className = 'btn-disabled';
+                stopBtn.disabled = false;
+                stopBtn.className = 'btn-stop';
+            } else {
+                spinner.style.display = 'none';
+                startBtn.disabled = false;
+                startBtn.className = 'btn-start';
+                stopBtn.disabled = true;
+                stopBtn.className = 'btn-stop btn-disabled';
+
+                if (data.status === 'Completed') {
+                    statusText.className = 'status-text status-completed';
+                } else if (data.status.includes('Error') || data.status === 'Failed') {
+                    statusText.className = 'status-text status-error';
+                } else {
+                    statusText.className = 'status-text status-ready';
+                }
+            }
+        }
 
-        function updateStatus() {
-            fetch('/api/status')
-                .then(response => response.json())
-                .then(data => {
-                    isRunning = data.isRunning;
-
-                    const statusText = document.getElementById('status-text');
-                    const spinner = document.getElementById('spinner');
-                    const startBtn = document.getElementById('start-btn');
-                    const stopBtn = document.getElementById('stop-btn');
-
-                    statusText.textContent = data.status;
-
-                    if (data.isRunning) {
-                        statusText.className = 'status-text status-running';
-                        spinner.style.display = 'block';
-                        startBtn.disabled = true;
-                        startBtn.className = 'btn-disabled';
-                        stopBtn.disabled = false;
-                        stopBtn.className = 'btn-stop';
+        // renderSchedule shows the daemon's jobs with their next and last runs
+        function renderSchedule(jobs) {
+            const schedule = document.getElementById('schedule');
+            const body = document.getElementById('schedule-body');
+            if (!jobs || !jobs.length) {
+                schedule.style.display = 'none';
+                return;
+            }
+            schedule.style.display = 'block';
+            body.innerHTML = '';
+
+            const formatTime = t => t ? new Date(t).toLocaleString() : '-';
+            jobs.forEach(job => {
+                let result = job.last_result || '-';
+                if (job.running) result = 'running';
+                else if (job.queued) result = 'queued';
+
+                const row = document.createElement('tr');
+                [job.name, job.cron, formatTime(job.next_run), formatTime(job.last_started), result].forEach(value => {
+                    const cell = document.createElement('td');
+                    cell.textContent = value;
+                    row.appendChild(cell);
+                });
+                if (job.last_error) {
+                    row.lastChild.title = job.last_error;
+                    row.lastChild.className = 'result-failed';
+                }
+                body.appendChild(row);
+            });
+        }
+
+        function appendLog(line) {
+            const logContainer = document.getElementById('log-container');
+            const atBottom = logContainer.scrollTop + logContainer.clientHeight >= logContainer.scrollHeight - 5;
+
+            const entry = document.createElement('div');
+            entry.textContent = line;
+            logContainer.appendChild(entry);
+            while (logContainer.childNodes.length > 500) {
+                logContainer.removeChild(logContainer.firstChild);
+            }
+
+            if (atBottom) {
+                logContainer.scrollTop = logContainer.scrollHeight;
+            }
+        }
+
+        function applyProgress(p) {
+            const progress = document.getElementById('progress');
+            const bar = document.getElementById('progress-bar');
+            const text = document.getElementById('progress-text');
+            progress.style.display = 'block';
+
+            if (p.phase === 'transferring' || p.phase === 'deleting') {
+                let percent = p.files_total ? p.files_done / p.files_total * 100 : 0;
+                if (p.phase === 'transferring' && p.bytes_total) {
+                    percent = p.bytes_done / p.bytes_total * 100;
+                }
+                bar.style.width = percent.toFixed(1) + '%';
+
+                let summary = (p.phase === 'deleting' ? 'Deleting ' : 'Transferring ') + p.files_done + '/' + p.files_total + ' files';
+                if (p.phase === 'transferring') {
+                    summary += ' · ' + formatBytes(p.bytes_done) + ' of ' + formatBytes(p.bytes_total) +
+                        ' · ' + formatBytes(Math.round(p.rate)) + '/s';
+                    if (p.eta) {
+                        summary += ' · ETA ' + Math.round(p.eta / 1e9) + 's';
+                    }
+                }
+                text.textContent = summary;
+            } else if (p.phase === 'done') {
+                bar.style.width = '100%';
+                text.textContent = 'Done';
+            } else {
+                bar.style.width = '0';
+                text.textContent = p.phase.charAt(0).toUpperCase() + p.phase.slice(1) + '...';
+            }
+        }
+
+        // connectEvents streams logs, status and progress from the server. The
+        // browser reconnects on its own with Last-Event-ID; if it gives up (for
+        // example after a server restart) a new stream resumes from the last id seen.
+        let lastEventId = '';
+
+        function connectEvents() {
+            const url = '/api/events' + (lastEventId ? '?lastEventId=' + encodeURIComponent(lastEventId) : '');
+            const events = new EventSource(url);
+
+            const track = handler => e => {
+                if (e.lastEventId) lastEventId = e.lastEventId;
+                handler(JSON.parse(e.data));
+            };
+            events.addEventListener('log', track(appendLog));
+            events.addEventListener('status', track(applyStatus));
+            events.addEventListener('progress', track(applyProgress));
+
+            events.onerror = () => {
+                if (events.readyState !== EventSource.CLOSED) return;
+                fetch('/api/status').then(response => {
+                    if (response.status === 401) {
+                        window.location = '/login';
                     } else {
-                        spinner.style.display = 'none';
-                        startBtn.disabled = false;
-                        startBtn.className = 'btn-start';
-                        stopBtn.disabled = true;
-                        stopBtn.className = 'btn-stop btn-disabled';
-
-                        if (data.status === 'Completed') {
-                            statusText.className = 'status-text status-completed';
-                        } else if (data.status.includes('Error') || data.status === 'Failed') {
-                            statusText.className = 'status-text status-error';
-                        } else {
-                            statusText.className = 'status-text status-ready';
-                        }
+                        setTimeout(connectEvents, 3000);
                     }
+                }).catch(() => setTimeout(connectEvents, 3000));
+            };
+        }
 
-                    // Update logs
-                    const logContainer = document.getElementById('log-container');
-                    logContainer.innerHTML = data.logs.join('<br>');
-                    logContainer.scrollTop = logContainer.scrollHeight;
-                });
+        // dateQuery returns the optional backfill range as a query string
+        function dateQuery() {
+            const params = new URLSearchParams();
+            const from = document.getElementById('from-date').value;
+            const to = document.getElementById('to-date').value;
+            if (from) params.set('from', from);
+            if (to) params.set('to', to);
+            const query = params.toString();
+            return query ? '?' + query : '';
         }
 
         function startSync() {
             if (isRunning) return;
 
-            fetch('/api/start', { method: 'POST' })
+            fetch('/api/start' + dateQuery(), { method: 'POST', headers: { 'X-CSRF-Token': csrfToken } })
                 .then(response => response.json())
                 .then(data => {
-                    if (data.success) {
-                        updateStatus();
-                    } else {
+                    if (!data.success) {
                         alert('Failed to start sync: ' + data.error);
                     }
                 });
@@ -227,32 +614,157 @@ func (w *WebGUI) indexHandler(rw http.ResponseWriter, r *http.Request) {
         function stopSync() {
             if (!isRunning) return;
 
-            fetch('/api/stop', { method: 'POST' })
+            fetch('/api/stop', { method: 'POST', headers: { 'X-CSRF-Token': csrfToken } })
                 .then(response => response.json())
                 .then(data => {
-                    if (data.success) {
-                        updateStatus();
-                    } else {
+                    if (!data.success) {
                         alert('Failed to stop sync: ' + data.error);
                     }
                 });
         }
 
+        let promptedFingerprint = null;
+
+        function confirmHostKey(prompt) {
+            if (promptedFingerprint === prompt.fingerprint) return;
+            promptedFingerprint = prompt.fingerprint;
+
+            const accept = confirm('New host key for ' + prompt.host + '\n\n' +
+                prompt.keyType + ' ' + prompt.fingerprint + '\n\n' +
+                'Verify this fingerprint with the server administrator. Accept and remember this key?');
+
+            fetch('/api/hostkey', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken },
+                body: JSON.stringify({ fingerprint: prompt.fingerprint, accept: accept })
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (!data.success) {
+                    alert('Failed to answer host key prompt: ' + data.error);
+                }
+            });
+        }
+
+        // showPassphrase shows the passphrase form while an encrypted key waits to be unlocked
+        function showPassphrase(prompt) {
+            const form = document.getElementById('passphrase');
+            if (!prompt) {
+                form.style.display = 'none';
+                return;
+            }
+            if (form.style.display === 'block') return;
+            document.getElementById('passphrase-key').textContent = prompt.keyFile;
+            form.style.display = 'block';
+            document.getElementById('passphrase-input').focus();
+        }
+
+        function answerPassphrase(event, unlock) {
+            event.preventDefault();
+            const input = document.getElementById('passphrase-input');
+            const answer = {
+                keyFile: document.getElementById('passphrase-key').textContent,
+                passphrase: unlock ? input.value : '',
+                cancel: !unlock
+            };
+            input.value = '';
+            document.getElementById('passphrase').style.display = 'none';
+
+            fetch('/api/passphrase', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken },
+                body: JSON.stringify(answer)
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (!data.success) {
+                    alert('Failed to answer passphrase prompt: ' + data.error);
+                }
+            });
+        }
+
+        function formatBytes(bytes) {
+            if (bytes > 1024 * 1024 * 1024) return (bytes / (1024 * 1024 * 1024)).toFixed(2) + ' GB';
+            if (bytes > 1024 * 1024) return (bytes / (1024 * 1024)).toFixed(2) + ' MB';
+            if (bytes > 1024) return (bytes / 1024).toFixed(2) + ' KB';
+            return bytes + ' bytes';
+        }
+
+        function previewSync() {
+            const previewBtn = document.getElementById('preview-btn');
+            previewBtn.disabled = true;
+            previewBtn.textContent = 'Previewing...';
+
+            fetch('/api/plan' + dateQuery(), { method: 'POST', headers: { 'X-CSRF-Token': csrfToken } })
+                .then(response => response.json())
+                .then(data => {
+                    if (!data.success) {
+                        alert('Failed to build preview: ' + data.error);
+                        return;
+                    }
+                    renderPlan(data.plan);
+                })
+                .finally(() => {
+                    previewBtn.disabled = false;
+                    previewBtn.textContent = 'Preview';
+                });
+        }
+
+        function renderPlan(plan) {
+            const body = document.getElementById('plan-body');
+            body.innerHTML = '';
+
+            (plan.files || []).forEach(file => {
+                const row = document.createElement('tr');
+                [file.reason, formatBytes(file.size), new Date(file.mod_time).toLocaleString(), file.relative_path].forEach(value => {
+                    const cell = document.createElement('td');
+                    cell.textContent = value;
+                    row.appendChild(cell);
+                });
+                body.appendChild(row);
+            });
+
+            (plan.deletions || []).forEach(file => {
+                const row = document.createElement('tr');
+                ['delete', formatBytes(file.size), '', file.relative_path].forEach(value => {
+                    const cell = document.createElement('td');
+                    cell.textContent = value;
+                    row.appendChild(cell);
+                });
+                body.appendChild(row);
+            });
+
+            let summary = '(' + plan.compare_mode + ': ' + (plan.files || []).length + ' files, ' + formatBytes(plan.total_bytes) + ' to transfer; ' +
+                plan.up_to_date + ' up to date';
+            if (plan.deletions) {
+                summary += '; ' + plan.deletions.length + ' to delete';
+            }
+            if (plan.deletion_limit) {
+                summary += '; ' + plan.deletion_limit;
+            }
+            document.getElementById('plan-summary').textContent = summary + ')';
+            document.getElementById('plan').style.display = 'block';
+        }
+
         function showConfig() {
             window.open('/config', '_blank');
         }
 
-        // Update status every 2 seconds
-        setInterval(updateStatus, 2000);
+        function showHistory() {
+            window.open('/history', '_blank');
+        }
 
-        // Initial status update
-        updateStatus();
+        connectEvents();
     </script>
 </body>
 </html>
 `
+	session := sessionFrom(r)
 	tmpl, _ := template.New("index").Parse(htmlTemplate)
-	tmpl.Execute(rw, nil)
+	tmpl.Execute(rw, map[string]string{
+		"CSRFToken": session.csrfToken,
+		"Username":  session.username,
+	})
 }
 
 func (w *WebGUI) statusHandler(rw http.ResponseWriter, r *http.Request) {
@@ -264,6 +776,7 @@ func (w *WebGUI) statusHandler(rw http.ResponseWriter, r *http.Request) {
 func (w *WebGUI) startHandler(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 
+	defer w.publishStatus()
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
@@ -275,12 +788,23 @@ func (w *WebGUI) startHandler(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Optional backfill range: from/to or a single date, as YYYY-MM-DD
+	dates, err := sftpsync.ParseDateRange(r.FormValue("from"), r.FormValue("to"), r.FormValue("date"))
+	if err != nil {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Invalid date range: %v", err),
+		})
+		return
+	}
+
 	w.isRunning = true
 	w.cancelled = false
+	w.dateResults = nil
 	w.ctx, w.cancel = context.WithCancel(context.Background())
 	w.status = "Starting..."
 
-	go w.runSync()
+	go w.runSync(dates, nil)
 
 	json.NewEncoder(rw).Encode(map[string]interface{}{
 		"success": true,
@@ -290,6 +814,7 @@ func (w *WebGUI) startHandler(rw http.ResponseWriter, r *http.Request) {
 func (w *WebGUI) stopHandler(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 
+	defer w.publishStatus()
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
@@ -320,6 +845,157 @@ func (w *WebGUI) stopHandler(rw http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (w *WebGUI) hostKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Fingerprint string `json:"fingerprint"`
+		Accept      bool   `json:"accept"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.hostKey == nil || w.hostKey.info.Fingerprint != req.Fingerprint {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "No matching host key is waiting for confirmation",
+		})
+		return
+	}
+
+	w.hostKey.reply <- req.Accept
+	w.hostKey = nil
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+func (w *WebGUI) passphraseHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		KeyFile    string `json:"keyFile"`
+		Passphrase string `json:"passphrase"`
+		Cancel     bool   `json:"cancel"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.passphrase == nil || w.passphrase.info.KeyFile != req.KeyFile {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "No matching key is waiting for a passphrase",
+		})
+		return
+	}
+
+	w.passphrase.reply <- passphraseReply{passphrase: req.Passphrase, ok: !req.Cancel}
+	w.passphrase = nil
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+func (w *WebGUI) planHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	dates, err := sftpsync.ParseDateRange(r.FormValue("from"), r.FormValue("to"), r.FormValue("date"))
+	if err != nil {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Invalid date range: %v", err),
+		})
+		return
+	}
+
+	config, err := sftpsync.LoadConfig("config.json")
+	if err != nil {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Failed to load configuration: %v", err),
+		})
+		return
+	}
+
+	sourceConfig := sftpsync.ConvertToSFTPConfig(config.Source)
+	destConfig := sftpsync.ConvertToSFTPConfig(config.Destination)
+	syncConfig := sftpsync.ConvertToSyncConfig(config.Sync)
+
+	syncConfig.Dates = dates
+
+	if err := sftpsync.ValidateConfig(sourceConfig, destConfig, syncConfig); err != nil {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Configuration is invalid: %v", err),
+		})
+		return
+	}
+
+	// The preview holds the run slot like a sync, so that a sync cannot start
+	// while it connects and share its host key and passphrase prompts. Stop
+	// cancels it.
+	w.mutex.Lock()
+	if w.isRunning {
+		w.mutex.Unlock()
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Sync is already running",
+		})
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	previous := w.status
+	w.isRunning = true
+	w.cancelled = false
+	w.ctx, w.cancel = ctx, cancel
+	w.status = "Building preview..."
+	w.mutex.Unlock()
+	w.publishStatus()
+	defer func() {
+		cancel()
+		w.mutex.Lock()
+		w.isRunning = false
+		w.status = previous
+		w.mutex.Unlock()
+		w.publishStatus()
+	}()
+
+	w.AddLog("Building preview...")
+
+	syncer := sftpsync.NewSFTPSync(sourceConfig, destConfig, syncConfig)
+	syncer.HostKeyPrompt = w.hostKeyPrompter(ctx)
+	syncer.PassphrasePrompt = w.passphrasePrompter(ctx)
+
+	plan, err := syncer.PlanWithContext(ctx)
+	if err != nil {
+		w.AddLog(fmt.Sprintf("Preview failed: %v", err))
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	w.AddLog(fmt.Sprintf("Preview: %d files would be transferred, %d up to date", len(plan.Files), plan.UpToDate))
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"success": true,
+		"plan":    plan,
+	})
+}
+
 func (w *WebGUI) configHandler(rw http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		// Show config editor
@@ -328,6 +1004,7 @@ func (w *WebGUI) configHandler(rw http.ResponseWriter, r *http.Request) {
 <html>
 <head>
     <title>Configuration Editor</title>
+    <meta name="csrf-token" content="{{.CSRFToken}}">
     <style>
         body { font-family: Arial, sans-serif; margin: 20px; background-color: #f5f5f5; }
         .container { max-width: 800px; margin: 0 auto; background: white; padding: 20px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
@@ -363,6 +1040,7 @@ func (w *WebGUI) configHandler(rw http.ResponseWriter, r *http.Request) {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
+                    'X-CSRF-Token': document.querySelector('meta[name="csrf-token"]').content,
                 },
                 body: JSON.stringify({ config: configText })
             })
@@ -380,16 +1058,36 @@ func (w *WebGUI) configHandler(rw http.ResponseWriter, r *http.Request) {
 </html>
 `
 		rw.Header().Set("Content-Type", "text/html")
-		rw.Write([]byte(configHTML))
+		tmpl, _ := template.New("config").Parse(configHTML)
+		tmpl.Execute(rw, map[string]string{"CSRFToken": sessionFrom(r).csrfToken})
 	}
 }
 
+// redactedValue stands in for a secret in the configuration sent to the
+// editor; saving it back unchanged keeps the secret that is on disk
+const redactedValue = "********"
+
+// secretConfigKeys are the configuration fields whose values never leave the
+// server: SFTP, jump host and SMTP passwords, keyboard-interactive answers, web
+// login hashes and webhook headers, which often carry a token
+var secretConfigKeys = map[string]bool{
+	"password":      true,
+	"answer":        true,
+	"password_hash": true,
+	"headers":       true,
+}
+
 func (w *WebGUI) configAPIHandler(rw http.ResponseWriter, r *http.Request) {
 	configPath := "config.json"
 
 	if r.Method == "GET" {
-		// Read config file
-		content, err := os.ReadFile(configPath)
+		// Read config file, without its secrets
+		current, err := readConfigTree(configPath)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("Failed to read config file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		content, err := formatConfigTree(redactSecrets("", current))
 		if err != nil {
 			http.Error(rw, "Failed to read config file", http.StatusInternalServerError)
 			return
@@ -406,7 +1104,7 @@ func (w *WebGUI) configAPIHandler(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := os.WriteFile(configPath, []byte(req.Config), 0644); err != nil {
+		if err := saveConfig(configPath, req.Config); err != nil {
 			json.NewEncoder(rw).Encode(map[string]interface{}{
 				"success": false,
 				"error":   err.Error(),
@@ -420,7 +1118,235 @@ func (w *WebGUI) configAPIHandler(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (w *WebGUI) runSync() {
+// saveConfig writes the configuration edited in the browser to configPath,
+// putting back the secrets the editor was sent redacted. The file is only
+// replaced if the new configuration passes the checks a sync makes, and is
+// only readable by its owner.
+func saveConfig(configPath, content string) error {
+	submitted, err := decodeConfigTree([]byte(content))
+	if err != nil {
+		return fmt.Errorf("configuration is not valid JSON: %v", err)
+	}
+	if current, err := readConfigTree(configPath); err == nil {
+		submitted = restoreSecrets(submitted, current, redactSecrets("", current))
+	}
+	if hasRedacted(submitted) {
+		return fmt.Errorf("%q no longer matches a saved secret; enter the secret itself", redactedValue)
+	}
+	data, err := formatConfigTree(submitted)
+	if err != nil {
+		return err
+	}
+
+	// CreateTemp makes the file with mode 0600
+	temp, err := os.CreateTemp(filepath.Dir(configPath), filepath.Base(configPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	config, err := sftpsync.LoadConfig(temp.Name())
+	if err != nil {
+		return err
+	}
+	if err := checkConfig(config); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), configPath)
+}
+
+// checkConfig runs the checks made before a sync, and those of the web and
+// schedule sections, on a configuration about to be saved
+func checkConfig(config *sftpsync.Config) error {
+	sourceConfig := sftpsync.ConvertToSFTPConfig(config.Source)
+	destConfig := sftpsync.ConvertToSFTPConfig(config.Destination)
+	syncConfig := sftpsync.ConvertToSyncConfig(config.Sync)
+	if err := sftpsync.ValidateConfig(sourceConfig, destConfig, syncConfig); err != nil {
+		return fmt.Errorf("Configuration is invalid: %v", err)
+	}
+	if _, err := sftpsync.NewNotifier(config.Notifications); err != nil {
+		return fmt.Errorf("Notifications configuration is invalid: %v", err)
+	}
+	if _, err := newWebAuth(config.Web, false); err != nil {
+		return fmt.Errorf("Web configuration is invalid: %v", err)
+	}
+	if _, err := sftpsync.ConvertToScheduledJobs(config.Schedule); err != nil {
+		return fmt.Errorf("Schedule configuration is invalid: %v", err)
+	}
+	return nil
+}
+
+func readConfigTree(configPath string) (interface{}, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return decodeConfigTree(data)
+}
+
+// decodeConfigTree decodes JSON keeping every field, known or not, and
+// numbers as written
+func decodeConfigTree(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	if _, ok := tree.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("the configuration must be a JSON object")
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the configuration")
+	}
+	return tree, nil
+}
+
+func formatConfigTree(tree interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(tree); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// redactSecrets returns a copy of the decoded configuration value found under
+// key with its secrets replaced by redactedValue. Proxy URLs keep everything
+// but their password.
+func redactSecrets(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			itemKey := k
+			if secretConfigKeys[key] {
+				itemKey = key // every header value is secret
+			}
+			redacted[k] = redactSecrets(itemKey, item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactSecrets(key, item)
+		}
+		return redacted
+	case string:
+		if v == "" {
+			return v
+		}
+		if secretConfigKeys[key] {
+			return redactedValue
+		}
+		if key == "proxy" {
+			if u, err := url.Parse(v); err == nil && u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					return strings.Replace(u.Redacted(), ":xxxxx@", ":"+redactedValue+"@", 1)
+				}
+			}
+		}
+	}
+	return value
+}
+
+// restoreSecrets puts the saved secrets back into the submitted configuration
+// wherever it still holds their redacted form, walking the three trees together.
+// List entries are paired by listEntryKey rather than position, so reordering
+// or removing a jump host cannot hand one host's password to another; an entry
+// with no saved counterpart keeps its placeholder and the save is refused.
+func restoreSecrets(submitted, current, redacted interface{}) interface{} {
+	switch v := submitted.(type) {
+	case map[string]interface{}:
+		currentMap, _ := current.(map[string]interface{})
+		redactedMap, _ := redacted.(map[string]interface{})
+		for k, item := range v {
+			v[k] = restoreSecrets(item, currentMap[k], redactedMap[k])
+		}
+	case []interface{}:
+		currentList, _ := current.([]interface{})
+		redactedList, _ := redacted.([]interface{})
+		saved := make(map[string]int)
+		for i, item := range currentList {
+			if key, ok := listEntryKey(item); ok {
+				if _, exists := saved[key]; !exists {
+					saved[key] = i
+				}
+			}
+		}
+		for i, item := range v {
+			key, ok := listEntryKey(item)
+			if !ok {
+				continue
+			}
+			if j, exists := saved[key]; exists {
+				v[i] = restoreSecrets(item, currentList[j], redactedList[j])
+			}
+		}
+	case string:
+		if redactedString, ok := redacted.(string); ok && v == redactedString && current != redacted {
+			return current
+		}
+	}
+	return submitted
+}
+
+// listEntryKey names a list entry of the configuration by the fields that say
+// what it is for: host, port and username for jump hosts, the prompt for
+// keyboard-interactive answers, the URL for webhooks and the username for web
+// users. Entries without any of them have no secrets to restore.
+func listEntryKey(item interface{}) (string, bool) {
+	entry, ok := item.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	switch {
+	case entry["prompt"] != nil:
+		return fmt.Sprintf("prompt %v", entry["prompt"]), true
+	case entry["host"] != nil:
+		return fmt.Sprintf("host %v %v %v", entry["host"], entry["port"], entry["username"]), true
+	case entry["url"] != nil:
+		return fmt.Sprintf("url %v", entry["url"]), true
+	case entry["username"] != nil:
+		return fmt.Sprintf("user %v", entry["username"]), true
+	}
+	return "", false
+}
+
+// hasRedacted reports whether any value still holds redactedValue
+func hasRedacted(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			if hasRedacted(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasRedacted(item) {
+				return true
+			}
+		}
+	case string:
+		return strings.Contains(v, redactedValue)
+	}
+	return false
+}
+
+// runSync runs one sync with the configuration on disk, started by the Start
+// button or, when job is set, by the scheduler, and returns the run's record
+// once it has one
+func (w *WebGUI) runSync(dates sftpsync.DateRange, job *sftpsync.ScheduledJob) (*sftpsync.RunRecord, error) {
 	// Ensure cleanup happens no matter what
 	defer func() {
 		w.mutex.Lock()
@@ -430,15 +1356,20 @@ func (w *WebGUI) runSync() {
 			w.cleanupSyncProcess()
 		}
 		w.mutex.Unlock()
+		w.publishStatus()
 	}()
 
 	w.SetStatus("Running...")
-	w.AddLog("Starting SFTP Sync...")
+	if job != nil {
+		w.AddLog(fmt.Sprintf("Starting scheduled SFTP Sync (job %s)...", job.Name))
+	} else {
+		w.AddLog("Starting SFTP Sync...")
+	}
 
 	// Setup log redirection
 	originalOut := log.Writer()
 
-	// Create custom log writer that filters cancelled connection errors
+	// Create custom log writer that captures engine output for the log view
 	logWriter := &LogWriter{webGui: w}
 
 	// Create sync process structure
@@ -460,38 +1391,64 @@ func (w *WebGUI) runSync() {
 
 	// Load configuration
 	configPath := "config.json"
-	config, err := LoadConfig(configPath)
+	config, err := sftpsync.LoadConfig(configPath)
 	if err != nil {
 		w.AddLog(fmt.Sprintf("Failed to load configuration: %v", err))
 		w.SetStatus("Error - Check config")
-		return
+		return nil, err
 	}
 
 	// Convert configs
-	sourceConfig := ConvertToSFTPConfig(config.Source)
-	destConfig := ConvertToSFTPConfig(config.Destination)
-	syncConfig := ConvertToSyncConfig(config.Sync)
+	sourceConfig := sftpsync.ConvertToSFTPConfig(config.Source)
+	destConfig := sftpsync.ConvertToSFTPConfig(config.Destination)
+	syncConfig := sftpsync.ConvertToSyncConfig(config.Sync)
+	syncConfig.Dates = dates
+	if job != nil {
+		job.Apply(&syncConfig)
+	}
 
 	// Validate configuration
-	if sourceConfig.Host == "" || sourceConfig.Username == "" {
-		w.AddLog("Source SFTP configuration is incomplete")
-		w.SetStatus("Error - Source config incomplete")
-		return
+	if err := sftpsync.ValidateConfig(sourceConfig, destConfig, syncConfig); err != nil {
+		w.AddLog(fmt.Sprintf("Configuration is invalid: %v", err))
+		w.SetStatus("Error - Check config")
+		return nil, err
 	}
-	if destConfig.Host == "" || destConfig.Username == "" {
-		w.AddLog("Destination SFTP configuration is incomplete")
-		w.SetStatus("Error - Dest config incomplete")
-		return
+	notifier, err := sftpsync.NewNotifier(config.Notifications)
+	if err != nil {
+		w.AddLog(fmt.Sprintf("Notifications configuration is invalid: %v", err))
+		w.SetStatus("Error - Notifications config invalid")
+		return nil, err
 	}
 
 	w.AddLog(fmt.Sprintf("Source: %s@%s:%d", sourceConfig.Username, sourceConfig.Host, sourceConfig.Port))
 	w.AddLog(fmt.Sprintf("Destination: %s@%s:%d", destConfig.Username, destConfig.Host, destConfig.Port))
+	if !dates.IsZero() {
+		w.AddLog(fmt.Sprintf("Date range: %s", dates))
+	}
 
 	// Create syncer
-	syncer := NewSFTPSync(sourceConfig, destConfig, syncConfig)
+	syncer := sftpsync.NewSFTPSync(sourceConfig, destConfig, syncConfig)
+	syncer.Trigger = "web"
+	if job != nil {
+		// Nobody may be watching a scheduled run, so an unknown host key or an
+		// encrypted key without a configured passphrase fails it instead of
+		// waiting for an answer
+		syncer.Trigger = "schedule:" + job.Name
+	} else {
+		syncer.HostKeyPrompt = w.hostKeyPrompter(w.ctx)
+		syncer.PassphrasePrompt = w.passphrasePrompter(w.ctx)
+	}
+	w.metrics.Observe(syncer)
+	notifier.Observe(syncer)
+	syncer.Subscribe(func(e sftpsync.Event) {
+		if e.Type == sftpsync.EventProgress {
+			w.events.publish(eventProgress, e.Progress)
+		}
+	})
 	w.syncProcess.syncer = syncer
 
 	// Run sync with proper cancellation support
+	var record *sftpsync.RunRecord
 	done := make(chan error, 1)
 	go func() {
 		// This goroutine will handle the sync operation
@@ -511,14 +1468,22 @@ func (w *WebGUI) runSync() {
 
 		// Run the sync with context cancellation support
 		err := syncer.SyncWithContext(syncCtx)
+
+		w.mutex.Lock()
+		w.dateResults = syncer.Stats.Snapshot().Dates
+		record = syncer.LastRun()
+		w.mutex.Unlock()
+
 		done <- err
 	}()
 
 	// Wait for completion or cancellation
+	var result error
 	select {
 	case err := <-done:
+		result = err
 		if err != nil {
-			if err == context.Canceled {
+			if errors.Is(err, context.Canceled) {
 				w.AddLog("Sync cancelled by user")
 				w.SetStatus("Cancelled")
 			} else {
@@ -530,6 +1495,7 @@ func (w *WebGUI) runSync() {
 			w.SetStatus("Completed")
 		}
 	case <-w.ctx.Done():
+		result = context.Canceled
 		w.AddLog("Sync cancelled by user")
 		w.SetStatus("Cancelled")
 		// Cancel the sync context
@@ -543,6 +1509,7 @@ func (w *WebGUI) runSync() {
 			w.AddLog("Sync force-stopped after timeout")
 		}
 	case <-syncCtx.Done():
+		result = context.Canceled
 		w.AddLog("Sync cancelled")
 		w.SetStatus("Cancelled")
 	}
@@ -555,6 +1522,29 @@ func (w *WebGUI) runSync() {
 	case <-time.After(1 * time.Second):
 		// Log cleanup timed out
 	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return record, result
+}
+
+// runScheduled is the scheduler's JobRunner. It shares the single sync slot
+// with the Start button, so the run is refused while a manual sync is going.
+func (w *WebGUI) runScheduled(ctx context.Context, job sftpsync.ScheduledJob) (*sftpsync.RunRecord, error) {
+	w.mutex.Lock()
+	if w.isRunning {
+		w.mutex.Unlock()
+		return nil, sftpsync.ErrSyncRunning
+	}
+	w.isRunning = true
+	w.cancelled = false
+	w.dateResults = nil
+	w.ctx, w.cancel = context.WithCancel(ctx)
+	w.status = "Starting..."
+	w.mutex.Unlock()
+	w.publishStatus()
+
+	return w.runSync(sftpsync.DateRange{}, &job)
 }
 
 func (w *WebGUI) cleanupSyncProcess() {
@@ -576,22 +1566,110 @@ func (w *WebGUI) cleanupSyncProcess() {
 }
 
 func (w *WebGUI) Start() {
-	http.HandleFunc("/", w.indexHandler)
-	http.HandleFunc("/api/status", w.statusHandler)
-	http.HandleFunc("/api/start", w.startHandler)
-	http.HandleFunc("/api/stop", w.stopHandler)
-	http.HandleFunc("/config", w.configHandler)
-	http.HandleFunc("/api/config", w.configAPIHandler)
-
-	fmt.Printf("Starting Web GUI on http://localhost:%s\n", w.port)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", w.auth.loginHandler)
+	mux.HandleFunc("/logout", w.auth.require(postOnly(w.auth.logoutHandler)))
+	mux.HandleFunc("/", w.auth.require(w.indexHandler))
+	mux.HandleFunc("/api/status", w.auth.require(w.statusHandler))
+	mux.HandleFunc("/api/events", w.auth.require(w.eventsHandler))
+	mux.HandleFunc("/api/start", w.auth.require(postOnly(w.startHandler)))
+	mux.HandleFunc("/api/stop", w.auth.require(postOnly(w.stopHandler)))
+	mux.HandleFunc("/api/plan", w.auth.require(postOnly(w.planHandler)))
+	mux.HandleFunc("/api/hostkey", w.auth.require(postOnly(w.hostKeyHandler)))
+	mux.HandleFunc("/api/passphrase", w.auth.require(postOnly(w.passphraseHandler)))
+	mux.HandleFunc("/config", w.auth.require(w.configHandler))
+	mux.HandleFunc("/api/config", w.auth.require(w.configAPIHandler))
+	mux.HandleFunc("/history", w.auth.require(w.historyHandler))
+	mux.HandleFunc("/api/history", w.auth.require(w.historyAPIHandler))
+	mux.HandleFunc("/metrics", w.auth.requireBasic(w.metrics.ServeHTTP))
+
+	server := &http.Server{
+		Addr:              net.JoinHostPort(w.bind, w.port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	scheme := "http"
+	if w.tlsCert != "" {
+		scheme = "https"
+	}
+	fmt.Printf("Starting Web GUI on %s://%s\n", scheme, server.Addr)
+	if !w.auth.enabled() {
+		fmt.Println("No web users configured: logins are disabled (local access only)")
+	}
 	fmt.Println("Press Ctrl+C to stop the server")
 
-	if err := http.ListenAndServe(":"+w.port, nil); err != nil {
+	var err error
+	if w.tlsCert != "" {
+		err = server.ListenAndServeTLS(w.tlsCert, w.tlsKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatal("Failed to start web server:", err)
 	}
 }
 
 func mainWebGUI() {
-	gui := NewWebGUI()
+	flags := flag.NewFlagSet("gui", flag.ExitOnError)
+	bind := flags.String("bind", "", "address to listen on (default web.bind, or 127.0.0.1)")
+	port := flags.Int("port", 0, "port to listen on (default web.port, or 8080)")
+	hashPassword := flags.Bool("hash-password", false, "read a password from stdin, print its bcrypt hash for web.users and exit")
+	daemon := flags.Bool("daemon", false, "also run the jobs in the schedule section while serving")
+	flags.Parse(os.Args[2:])
+
+	if *hashPassword {
+		printPasswordHash()
+		return
+	}
+
+	config, err := sftpsync.LoadConfig("config.json")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *bind != "" {
+		config.Web.Bind = *bind
+	}
+	if *port != 0 {
+		config.Web.Port = *port
+	}
+
+	gui, err := NewWebGUI(config.Web)
+	if err != nil {
+		log.Fatalf("Web GUI configuration is invalid: %v", err)
+	}
+
+	if *daemon {
+		jobs, err := sftpsync.ConvertToScheduledJobs(config.Schedule)
+		if err != nil {
+			log.Fatalf("Schedule configuration is invalid: %v", err)
+		}
+		if len(jobs) == 0 {
+			log.Fatal("Daemon mode requires at least one job in the schedule section")
+		}
+		gui.scheduler = sftpsync.NewScheduler(jobs, config.Schedule.StateFile, gui.runScheduled)
+		gui.scheduler.OnChange = gui.publishStatus
+		go gui.scheduler.Run(context.Background())
+	}
+
 	gui.Start()
 }
+
+// printPasswordHash reads one line from stdin and prints its bcrypt hash
+func printPasswordHash() {
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatalf("Failed to read password: %v", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		log.Fatal("Password cannot be empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	fmt.Println(string(hash))
+}
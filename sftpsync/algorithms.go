@@ -0,0 +1,148 @@
+package sftpsync
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ValidateAlgorithms checks that every key exchange, cipher, MAC and host key
+// algorithm an endpoint or its jump hosts list is one the SSH library implements
+func ValidateAlgorithms(config SFTPConfig) error {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	lists := []struct {
+		setting string
+		names   []string
+		known   []string
+	}{
+		{"key_exchanges", config.KeyExchanges, append(supported.KeyExchanges, insecure.KeyExchanges...)},
+		{"ciphers", config.Ciphers, append(supported.Ciphers, insecure.Ciphers...)},
+		{"macs", config.MACs, append(supported.MACs, insecure.MACs...)},
+		{"host_key_algorithms", config.HostKeyAlgorithms, append(supported.HostKeys, insecure.HostKeys...)},
+	}
+	for _, list := range lists {
+		for _, name := range list.names {
+			if !slices.Contains(list.known, name) {
+				return fmt.Errorf("unknown algorithm %q in %s (expected one of %s)", name, list.setting, strings.Join(list.known, ", "))
+			}
+		}
+	}
+
+	for i, hop := range config.JumpHosts {
+		if err := ValidateAlgorithms(hop); err != nil {
+			return fmt.Errorf("jump host %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// offeredHostKeyAlgorithms restricts the host key algorithms offered to the
+// configured ones, still putting those that match a known key first
+func offeredHostKeyAlgorithms(configured, known []string) []string {
+	if len(configured) == 0 {
+		return known
+	}
+	var matching, others []string
+	for _, algorithm := range configured {
+		if slices.Contains(known, algorithm) {
+			matching = append(matching, algorithm)
+		} else {
+			others = append(others, algorithm)
+		}
+	}
+	return append(matching, others...)
+}
+
+// AlgorithmReport lists what each SSH server of a sync agreed on
+type AlgorithmReport struct {
+	Connections []NegotiatedConnection
+}
+
+// NegotiatedConnection holds the algorithms negotiated with one SSH server.
+// MACs are empty for AEAD ciphers, which authenticate the data themselves.
+type NegotiatedConnection struct {
+	Server        string // "source", "destination" or e.g. "source jump host 1"
+	Addr          string
+	ServerVersion string
+	KeyExchange   string
+	HostKey       string
+	CipherOut     string // client to server
+	CipherIn      string // server to client
+	MACOut        string
+	MACIn         string
+}
+
+// ProbeAlgorithms connects to the source and destination, and any jump hosts
+// on the way, and reports the algorithms negotiated with each. No SFTP
+// session is opened.
+func (s *SFTPSync) ProbeAlgorithms() (*AlgorithmReport, error) {
+	report := &AlgorithmReport{}
+	endpoints := []struct {
+		side   string
+		config SFTPConfig
+	}{
+		{"source", s.SourceConfig},
+		{"destination", s.DestinationConfig},
+	}
+
+	for _, endpoint := range endpoints {
+		log.Printf("Connecting to %s SFTP server...", endpoint.side)
+		client, jumps, err := s.dialSSH(endpoint.config)
+		if err != nil {
+			return nil, &ConnectError{Server: endpoint.side, Err: err}
+		}
+		for i, jump := range jumps {
+			hop := endpoint.config.JumpHosts[i]
+			report.add(fmt.Sprintf("%s jump host %d", endpoint.side, i+1), hop, jump)
+		}
+		report.add(endpoint.side, endpoint.config, client)
+		client.Close()
+		closeJumpHosts(jumps)
+	}
+	return report, nil
+}
+
+func (r *AlgorithmReport) add(server string, config SFTPConfig, client *ssh.Client) {
+	connection := NegotiatedConnection{
+		Server:        server,
+		Addr:          net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		ServerVersion: string(client.ServerVersion()),
+	}
+	if conn, ok := client.Conn.(ssh.AlgorithmsConnMetadata); ok {
+		algorithms := conn.Algorithms()
+		connection.KeyExchange = algorithms.KeyExchange
+		connection.HostKey = algorithms.HostKey
+		connection.CipherOut, connection.MACOut = algorithms.Write.Cipher, algorithms.Write.MAC
+		connection.CipherIn, connection.MACIn = algorithms.Read.Cipher, algorithms.Read.MAC
+	}
+	r.Connections = append(r.Connections, connection)
+}
+
+// Print logs the negotiated algorithms of every connection
+func (r *AlgorithmReport) Print() {
+	log.Println(strings.Repeat("=", 60))
+	log.Println("🔐 SSH ALGORITHMS")
+	log.Println(strings.Repeat("=", 60))
+
+	directions := func(out, in string) string {
+		if out == "" && in == "" {
+			return "(none, the cipher authenticates)"
+		}
+		if out == in {
+			return out
+		}
+		return fmt.Sprintf("%s (to server), %s (from server)", out, in)
+	}
+	for _, c := range r.Connections {
+		log.Printf("🖥️  %s %s (%s)", c.Server, c.Addr, c.ServerVersion)
+		log.Printf("   %-13s %s", "key exchange", c.KeyExchange)
+		log.Printf("   %-13s %s", "host key", c.HostKey)
+		log.Printf("   %-13s %s", "cipher", directions(c.CipherOut, c.CipherIn))
+		log.Printf("   %-13s %s", "mac", directions(c.MACOut, c.MACIn))
+	}
+}
@@ -0,0 +1,230 @@
+package sftpsync
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// PassphrasePrompt is asked for the passphrase of an encrypted private key
+// when none is configured. It returns false when the user cancels.
+type PassphrasePrompt func(keyFile string) (string, bool)
+
+// KeyboardInteractiveAnswer answers the keyboard-interactive challenges whose
+// prompt contains Prompt, compared case-insensitively
+type KeyboardInteractiveAnswer struct {
+	Prompt string
+	Answer string
+}
+
+// keyCache keeps the signers parsed from key files, so an encrypted key is
+// unlocked once per sync however often the session pool dials
+type keyCache struct {
+	mutex   sync.Mutex
+	signers map[string]ssh.Signer
+}
+
+// ValidateAuth checks that an endpoint and each of its jump hosts have at
+// least one way to log in and that their keyboard-interactive answers are usable
+func ValidateAuth(config SFTPConfig) error {
+	if config.Password == "" && config.KeyFile == "" && !config.UseAgent && len(config.KeyboardInteractive) == 0 {
+		return fmt.Errorf("a password, key file, ssh-agent or keyboard-interactive answers are required")
+	}
+	if config.CertificateFile != "" && config.KeyFile == "" {
+		return fmt.Errorf("certificate_file needs the keyfile it was issued for")
+	}
+	for i, answer := range config.KeyboardInteractive {
+		if answer.Prompt == "" {
+			return fmt.Errorf("keyboard_interactive entry %d has no prompt", i+1)
+		}
+	}
+	for i, hop := range config.JumpHosts {
+		if hop.Host == "" || hop.Username == "" {
+			return fmt.Errorf("jump host %d: host and username are required", i+1)
+		}
+		if err := ValidateAuth(hop); err != nil {
+			return fmt.Errorf("jump host %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// authMethods builds the SSH authentication methods for an endpoint, in the
+// order they are tried: public keys (key file, its certificate and the
+// ssh-agent), password, then keyboard-interactive. The returned function
+// releases the ssh-agent connection once the handshake is over.
+func (s *SFTPSync) authMethods(config SFTPConfig) ([]ssh.AuthMethod, func(), error) {
+	var signers []ssh.Signer
+	release := func() {}
+
+	if config.KeyFile != "" {
+		signer, err := s.keySigner(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	var agentSigners func() ([]ssh.Signer, error)
+	if config.UseAgent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, nil, fmt.Errorf("use_agent is set but SSH_AUTH_SOCK is not")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to reach ssh-agent: %v", err)
+		}
+		release = func() { conn.Close() }
+		agentSigners = agent.NewClient(conn).Signers
+	}
+
+	var auth []ssh.AuthMethod
+	if len(signers) > 0 || agentSigners != nil {
+		auth = append(auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if agentSigners == nil {
+				return signers, nil
+			}
+			held, err := agentSigners()
+			if err != nil {
+				return nil, fmt.Errorf("unable to list ssh-agent keys: %v", err)
+			}
+			return append(signers, held...), nil
+		}))
+	}
+
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+	if config.Password != "" || len(config.KeyboardInteractive) > 0 {
+		auth = append(auth, ssh.KeyboardInteractive(keyboardInteractive(config)))
+	}
+
+	return auth, release, nil
+}
+
+// keySigner parses the endpoint's private key, unlocking it with the
+// configured passphrase or by asking PassphrasePrompt, and pairs it with its
+// OpenSSH certificate when there is one
+func (s *SFTPSync) keySigner(config SFTPConfig) (ssh.Signer, error) {
+	s.keys.mutex.Lock()
+	defer s.keys.mutex.Unlock()
+
+	cacheKey := config.KeyFile + "\x00" + config.CertificateFile
+	if signer, ok := s.keys.signers[cacheKey]; ok {
+		return signer, nil
+	}
+
+	key, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key: %v", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase, err := s.keyPassphrase(config)
+		if err != nil {
+			return nil, err
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt private key %s: %v", config.KeyFile, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %v", err)
+	}
+
+	// Like OpenSSH, use <key>-cert.pub when no certificate is named
+	certFile := config.CertificateFile
+	if certFile == "" {
+		if _, err := os.Stat(config.KeyFile + "-cert.pub"); err == nil {
+			certFile = config.KeyFile + "-cert.pub"
+		}
+	}
+	if certFile != "" {
+		signer, err = certSigner(certFile, signer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.keys.signers == nil {
+		s.keys.signers = make(map[string]ssh.Signer)
+	}
+	s.keys.signers[cacheKey] = signer
+	return signer, nil
+}
+
+// keyPassphrase finds the passphrase for an encrypted key: the configured
+// one, the contents of key_passphrase_file, or the user's answer to PassphrasePrompt
+func (s *SFTPSync) keyPassphrase(config SFTPConfig) (string, error) {
+	if config.KeyPassphrase != "" {
+		return config.KeyPassphrase, nil
+	}
+	if config.KeyPassphraseFile != "" {
+		data, err := os.ReadFile(config.KeyPassphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read key passphrase file: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if s.PassphrasePrompt != nil {
+		if passphrase, ok := s.PassphrasePrompt(config.KeyFile); ok {
+			return passphrase, nil
+		}
+		return "", fmt.Errorf("passphrase for %s was not entered", config.KeyFile)
+	}
+	return "", fmt.Errorf("private key %s is encrypted; set key_passphrase_env or key_passphrase_file", config.KeyFile)
+}
+
+// certSigner pairs signer with the OpenSSH certificate in certFile
+func certSigner(certFile string, signer ssh.Signer) (ssh.Signer, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate: %v", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse certificate %s: %v", certFile, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a public key, not a certificate", certFile)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s does not match the private key: %v", certFile, err)
+	}
+	return certSigner, nil
+}
+
+// keyboardInteractive answers each challenge from the configured answers,
+// falling back to the password for prompts that ask for one
+func keyboardInteractive(config SFTPConfig) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+	questions:
+		for i, question := range questions {
+			prompt := strings.ToLower(question)
+			for _, answer := range config.KeyboardInteractive {
+				if strings.Contains(prompt, strings.ToLower(answer.Prompt)) {
+					answers[i] = answer.Answer
+					continue questions
+				}
+			}
+			if config.Password != "" && strings.Contains(prompt, "password") {
+				answers[i] = config.Password
+				continue
+			}
+			return nil, fmt.Errorf("no keyboard-interactive answer configured for prompt %q", strings.TrimSpace(question))
+		}
+		return answers, nil
+	}
+}
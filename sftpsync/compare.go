@@ -0,0 +1,164 @@
+package sftpsync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"time"
+)
+
+// Comparison strategies for deciding whether a destination file is up to date
+const (
+	// CompareSizeMtime transfers when sizes differ or the source is newer than the
+	// destination by more than the mtime tolerance (the default)
+	CompareSizeMtime = "size_mtime"
+	// CompareSize transfers only when sizes differ, for servers that do not keep mtimes
+	CompareSize = "size"
+	// CompareChecksum transfers when sizes or MD5 checksums differ
+	CompareChecksum = "checksum"
+	// CompareChecksumOnMismatch is CompareSizeMtime, except that files whose sizes
+	// match but mtimes disagree are checksummed instead of transferred
+	CompareChecksumOnMismatch = "checksum_on_mismatch"
+)
+
+// Reasons a destination file is considered up to date, counted in SyncPlan.UnchangedBy
+const (
+	UnchangedSameSize      = "same size"
+	UnchangedSameSizeMtime = "same size and mtime"
+	UnchangedSameChecksum  = "same checksum"
+)
+
+// compareMode returns the configured comparison strategy, defaulting to size+mtime
+func (s *SFTPSync) compareMode() string {
+	if s.SyncConfig.CompareMode == "" {
+		return CompareSizeMtime
+	}
+	return s.SyncConfig.CompareMode
+}
+
+// ValidateCompareMode checks that the comparison settings are usable
+func ValidateCompareMode(config SyncConfig) error {
+	switch config.CompareMode {
+	case "", CompareSizeMtime, CompareSize, CompareChecksum, CompareChecksumOnMismatch:
+	default:
+		return fmt.Errorf("unknown compare_mode %q (use %s, %s, %s or %s)",
+			config.CompareMode, CompareSizeMtime, CompareSize, CompareChecksum, CompareChecksumOnMismatch)
+	}
+	if config.MtimeTolerance < 0 {
+		return fmt.Errorf("mtime_tolerance cannot be negative")
+	}
+	return nil
+}
+
+// mtimeWithin reports whether two modification times are within tolerance of each other
+func mtimeWithin(a, b time.Time, tolerance time.Duration) bool {
+	diff := a.Sub(b)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance
+}
+
+// compareGraphsWithContext compares source and destination graphs and returns a plan
+// of files to sync. Metadata decides first; in the checksum modes, files it cannot
+// settle are hashed on both sides before deciding.
+func (s *SFTPSync) compareGraphsWithContext(ctx context.Context, sourceGraph, destGraph *DirectoryGraph, dateDirs []DateDir) (*SyncPlan, error) {
+	mode := s.compareMode()
+	tolerance := s.SyncConfig.MtimeTolerance
+
+	plan := &SyncPlan{
+		GeneratedAt:     time.Now(),
+		SourcePath:      s.SyncConfig.SourcePath,
+		DestinationPath: s.SyncConfig.DestinationPath,
+		CompareMode:     mode,
+		UnchangedBy:     make(map[string]int),
+	}
+	if tolerance > 0 {
+		plan.MtimeTolerance = tolerance.String()
+	}
+
+	// Files whose sizes match but whose content can only be settled by checksum
+	var disputedSource, disputedDest []*FileInfo
+
+	sourceGraph.mutex.RLock()
+	destGraph.mutex.RLock()
+	for _, sourceFile := range sourceGraph.Files {
+		destPath := path.Join(s.SyncConfig.DestinationPath, destRelativePath(dateDirs, sourceFile.RelativePath))
+		destFile, exists := destGraph.Files[destPath]
+
+		switch {
+		case !exists:
+			plan.addFile(sourceFile, destPath, ReasonMissing)
+		case sourceFile.Size != destFile.Size:
+			plan.addFile(sourceFile, destPath, ReasonSizeDiffers)
+		case mode == CompareSize:
+			plan.addUnchanged(UnchangedSameSize)
+		case mode == CompareChecksum,
+			mode == CompareChecksumOnMismatch && !mtimeWithin(sourceFile.ModTime, destFile.ModTime, tolerance):
+			disputedSource = append(disputedSource, sourceFile)
+			disputedDest = append(disputedDest, destFile)
+		case sourceFile.ModTime.Sub(destFile.ModTime) > tolerance:
+			plan.addFile(sourceFile, destPath, ReasonSourceNewer)
+		default:
+			plan.addUnchanged(UnchangedSameSizeMtime)
+		}
+	}
+	sourceGraph.mutex.RUnlock()
+	destGraph.mutex.RUnlock()
+
+	if len(disputedSource) > 0 {
+		log.Printf("🧮 %s: checksumming %d files of equal size", mode, len(disputedSource))
+
+		if err := s.hashDestinationWithContext(ctx, destGraph, dateDirs, disputedDest); err != nil {
+			return nil, fmt.Errorf("failed to hash destination files: %w", err)
+		}
+		sourceClient, err := s.source.client()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.hashFilesWithContext(ctx, sourceClient, "source", disputedSource); err != nil {
+			return nil, fmt.Errorf("failed to hash source files: %w", err)
+		}
+
+		for i, sourceFile := range disputedSource {
+			destFile := disputedDest[i]
+			switch {
+			case sourceFile.Hash == "" || destFile.Hash == "":
+				plan.addFile(sourceFile, destFile.Path, ReasonChecksumUnavailable)
+			case sourceFile.Hash != destFile.Hash:
+				plan.addFile(sourceFile, destFile.Path, ReasonChecksumDiffers)
+			default:
+				plan.addUnchanged(UnchangedSameChecksum)
+			}
+		}
+	}
+
+	// Sort files by size (smaller files first for better parallelism)
+	sort.Slice(plan.Files, func(i, j int) bool {
+		return plan.Files[i].Size < plan.Files[j].Size
+	})
+
+	return plan, nil
+}
+
+// addFile queues a source file for transfer with the reason it was chosen
+func (p *SyncPlan) addFile(sourceFile *FileInfo, destPath, reason string) {
+	p.Files = append(p.Files, PlannedFile{
+		Path:            sourceFile.Path,
+		RelativePath:    sourceFile.RelativePath,
+		DestinationPath: destPath,
+		Size:            sourceFile.Size,
+		ModTime:         sourceFile.ModTime,
+		Reason:          reason,
+		source:          sourceFile,
+	})
+	p.TotalBytes += sourceFile.Size
+}
+
+// addUnchanged counts a file that is already up to date and why
+func (p *SyncPlan) addUnchanged(reason string) {
+	p.UpToDate++
+	p.UnchangedBy[reason]++
+}
@@ -0,0 +1,164 @@
+package sftpsync
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// testPool wraps a client in a session pool with one live session
+func testPool(client *sftp.Client, side string) *sessionPool {
+	conn := &sftpConn{dead: make(chan struct{}), sessions: 1}
+	session := &sftpSession{conn: conn, client: client}
+	return &sessionPool{side: side, idle: []*sftpSession{session}, conns: []*sftpConn{conn}, last: session}
+}
+
+// testCompareSync returns an SFTPSync comparing /src with /dst, keeping its
+// manifest in a temporary directory
+func testCompareSync(t *testing.T, mode string, tolerance time.Duration) *SFTPSync {
+	t.Helper()
+	s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{
+		SourcePath:      "/src",
+		DestinationPath: "/dst",
+		CompareMode:     mode,
+		MtimeTolerance:  tolerance,
+		ManifestFile:    filepath.Join(t.TempDir(), "manifest.db"),
+	})
+	t.Cleanup(func() {
+		if s.manifest != nil {
+			s.manifest.Close()
+		}
+	})
+	return s
+}
+
+func TestCompareGraphsMetadata(t *testing.T) {
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		mode       string
+		tolerance  time.Duration
+		sourceSize int64
+		destSize   int64 // -1 for a file missing at the destination
+		sourceAge  time.Duration
+		wantReason string // "" when the file is up to date
+		wantBy     string
+	}{
+		{"missing", CompareSizeMtime, 0, 10, -1, 0, ReasonMissing, ""},
+		{"size differs", CompareSizeMtime, 0, 10, 11, 0, ReasonSizeDiffers, ""},
+		{"source newer", CompareSizeMtime, 0, 10, 10, time.Minute, ReasonSourceNewer, ""},
+		{"same size and mtime", CompareSizeMtime, 0, 10, 10, 0, "", UnchangedSameSizeMtime},
+		{"destination newer", CompareSizeMtime, 0, 10, 10, -time.Hour, "", UnchangedSameSizeMtime},
+		{"newer within tolerance", CompareSizeMtime, 2 * time.Second, 10, 10, 2 * time.Second, "", UnchangedSameSizeMtime},
+		{"newer beyond tolerance", CompareSizeMtime, 2 * time.Second, 10, 10, 3 * time.Second, ReasonSourceNewer, ""},
+		{"size only ignores mtime", CompareSize, 0, 10, 10, time.Hour, "", UnchangedSameSize},
+		{"size only still sees size", CompareSize, 0, 10, 12, 0, ReasonSizeDiffers, ""},
+		{"mismatch mode trusts agreeing mtimes", CompareChecksumOnMismatch, 0, 10, 10, 0, "", UnchangedSameSizeMtime},
+		{"default mode", "", 0, 10, 10, time.Minute, ReasonSourceNewer, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testCompareSync(t, tt.mode, tt.tolerance)
+			source, dest := NewDirectoryGraph("/src"), NewDirectoryGraph("/dst")
+			source.AddFile(&FileInfo{Path: "/src/a.csv", RelativePath: "a.csv", Size: tt.sourceSize, ModTime: base.Add(tt.sourceAge)})
+			if tt.destSize >= 0 {
+				dest.AddFile(&FileInfo{Path: "/dst/a.csv", RelativePath: "a.csv", Size: tt.destSize, ModTime: base})
+			}
+
+			plan, err := s.compareGraphsWithContext(context.Background(), source, dest, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantReason == "" {
+				if len(plan.Files) != 0 || plan.UnchangedBy[tt.wantBy] != 1 {
+					t.Fatalf("got files %v, unchanged %v; want unchanged by %q", plan.Files, plan.UnchangedBy, tt.wantBy)
+				}
+				return
+			}
+			if len(plan.Files) != 1 || plan.Files[0].Reason != tt.wantReason {
+				t.Fatalf("got files %v, want one with reason %q", plan.Files, tt.wantReason)
+			}
+			if plan.Files[0].DestinationPath != "/dst/a.csv" {
+				t.Errorf("destination path = %q, want /dst/a.csv", plan.Files[0].DestinationPath)
+			}
+		})
+	}
+}
+
+func TestCompareGraphsChecksum(t *testing.T) {
+	sourceClient, destClient := testSFTPClient(t), testSFTPClient(t)
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		source     string
+		dest       string
+		destMtime  time.Duration
+		mode       string // compare mode the case runs under
+		wantReason string // "" when the file is up to date
+	}{
+		{name: "same.csv", source: "1,100\n", dest: "1,100\n", mode: CompareChecksum},
+		{name: "changed.csv", source: "1,100\n", dest: "1,999\n", mode: CompareChecksum, wantReason: ReasonChecksumDiffers},
+		{name: "same.csv", source: "1,100\n", dest: "1,100\n", destMtime: -time.Hour, mode: CompareChecksumOnMismatch},
+		{name: "changed.csv", source: "1,100\n", dest: "1,999\n", destMtime: -time.Hour, mode: CompareChecksumOnMismatch, wantReason: ReasonChecksumDiffers},
+	}
+
+	for _, f := range tests {
+		t.Run(f.mode+"/"+f.name, func(t *testing.T) {
+			dir := path.Join("/", f.mode)
+			sourceClient.MkdirAll(path.Join(dir, "src"))
+			destClient.MkdirAll(path.Join(dir, "dst"))
+			sourcePath, destPath := path.Join(dir, "src", f.name), path.Join(dir, "dst", f.name)
+			writeRemote(t, sourceClient, sourcePath, f.source)
+			writeRemote(t, destClient, destPath, f.dest)
+
+			s := testCompareSync(t, f.mode, 0)
+			s.SyncConfig.SourcePath, s.SyncConfig.DestinationPath = path.Join(dir, "src"), path.Join(dir, "dst")
+			s.source, s.dest = testPool(sourceClient, "source"), testPool(destClient, "destination")
+
+			source, dest := NewDirectoryGraph(s.SyncConfig.SourcePath), NewDirectoryGraph(s.SyncConfig.DestinationPath)
+			source.AddFile(&FileInfo{Path: sourcePath, RelativePath: f.name, Size: int64(len(f.source)), ModTime: base})
+			dest.AddFile(&FileInfo{Path: destPath, RelativePath: f.name, Size: int64(len(f.dest)), ModTime: base.Add(f.destMtime)})
+
+			plan, err := s.compareGraphsWithContext(context.Background(), source, dest, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.wantReason == "" {
+				if len(plan.Files) != 0 || plan.UnchangedBy[UnchangedSameChecksum] != 1 {
+					t.Fatalf("got files %v, unchanged %v; want unchanged by checksum", plan.Files, plan.UnchangedBy)
+				}
+				return
+			}
+			if len(plan.Files) != 1 || plan.Files[0].Reason != f.wantReason {
+				t.Fatalf("got files %v, want one with reason %q", plan.Files, f.wantReason)
+			}
+		})
+	}
+}
+
+func TestValidateCompareMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SyncConfig
+		wantErr bool
+	}{
+		{"default", SyncConfig{}, false},
+		{"checksum", SyncConfig{CompareMode: CompareChecksum}, false},
+		{"unknown mode", SyncConfig{CompareMode: "mtime"}, true},
+		{"negative tolerance", SyncConfig{MtimeTolerance: -time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateCompareMode(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCompareMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,648 @@
+package sftpsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config represents the complete configuration structure
+type Config struct {
+	Source      SFTPConfigJSON     `json:"source"`
+	Destination SFTPConfigJSON     `json:"destination"`
+	Sync        SyncConfigJSON     `json:"sync"`
+	Web         WebConfigJSON      `json:"web"`
+	Schedule    ScheduleConfigJSON `json:"schedule"`
+
+	Notifications NotificationsConfigJSON `json:"notifications"`
+}
+
+// SFTPConfigJSON represents SFTP configuration in JSON format
+type SFTPConfigJSON struct {
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	KeyFile   string `json:"keyfile"`
+	Timeout   int    `json:"timeout"`
+	KeepAlive int    `json:"keepalive"`
+
+	// The key passphrase is never stored inline: it comes from the variable
+	// named by key_passphrase_env, key_passphrase_file or SOURCE_/DEST_KEY_PASSPHRASE
+	KeyPassphrase       string                    `json:"-"`
+	KeyPassphraseEnv    string                    `json:"key_passphrase_env"`
+	KeyPassphraseFile   string                    `json:"key_passphrase_file"`
+	CertificateFile     string                    `json:"certificate_file"`
+	UseAgent            bool                      `json:"use_agent"`
+	KeyboardInteractive []KeyboardInteractiveJSON `json:"keyboard_interactive"`
+
+	MaxSessions           int `json:"max_sessions"`
+	SessionsPerConnection int `json:"sessions_per_connection"`
+
+	HostKeyPolicy       string   `json:"host_key_policy"`
+	KnownHostsFile      string   `json:"known_hosts_file"`
+	HostKeyFingerprints []string `json:"host_key_fingerprints"`
+
+	KeyExchanges      []string `json:"key_exchanges"`
+	Ciphers           []string `json:"ciphers"`
+	MACs              []string `json:"macs"`
+	HostKeyAlgorithms []string `json:"host_key_algorithms"`
+
+	JumpHosts []SFTPConfigJSON `json:"jump_hosts"`
+
+	// The proxy password can come from the variable named by
+	// proxy_password_env instead of the URL
+	Proxy            string `json:"proxy"`
+	ProxyPassword    string `json:"-"`
+	ProxyPasswordEnv string `json:"proxy_password_env"`
+
+	// SSHConfigHost names a Host alias in SSHConfigFile (default
+	// ~/.ssh/config) to take the fields left empty from when loading
+	SSHConfigHost string `json:"ssh_config_host"`
+	SSHConfigFile string `json:"ssh_config_file"`
+}
+
+// KeyboardInteractiveJSON answers keyboard-interactive prompts containing
+// Prompt, with Answer or the value of the AnswerEnv variable
+type KeyboardInteractiveJSON struct {
+	Prompt    string `json:"prompt"`
+	Answer    string `json:"answer"`
+	AnswerEnv string `json:"answer_env"`
+}
+
+// SyncConfigJSON represents sync configuration in JSON format
+type SyncConfigJSON struct {
+	SourcePath             string   `json:"source_path"`
+	DestinationPath        string   `json:"destination_path"`
+	ExcludePatterns        []string `json:"exclude_patterns"`
+	MaxConcurrentTransfers int      `json:"max_concurrent_transfers"`
+	ChunkSize              int      `json:"chunk_size"`
+	RetryAttempts          int      `json:"retry_attempts"`
+	RetryDelay             int      `json:"retry_delay"`
+	ReconnectAttempts      int      `json:"reconnect_attempts"`
+	VerifyTransfers        bool     `json:"verify_transfers"`
+	DaysToSync             int      `json:"days_to_sync"`
+	DateLayout             string   `json:"date_layout"`
+	DestDateLayout         string   `json:"destination_date_layout"`
+	CompareMode            string   `json:"compare_mode"`
+	MtimeTolerance         int      `json:"mtime_tolerance"`
+	ManifestFile           string   `json:"manifest_file"`
+	Mirror                 bool     `json:"mirror"`
+	MaxDeletions           int      `json:"max_deletions"`
+	MaxDeletionPercent     float64  `json:"max_deletion_percent"`
+	HistoryFile            string   `json:"history_file"`
+	HistoryRetentionDays   int      `json:"history_retention_days"`
+	HistoryMaxRuns         int      `json:"history_max_runs"`
+}
+
+// WebConfigJSON configures the web GUI listener and its logins
+type WebConfigJSON struct {
+	Bind           string        `json:"bind"`
+	Port           int           `json:"port"`
+	TLSCert        string        `json:"tls_cert"`
+	TLSKey         string        `json:"tls_key"`
+	SessionTimeout int           `json:"session_timeout"`
+	Users          []WebUserJSON `json:"users"`
+}
+
+// WebUserJSON is a web GUI login; PasswordHash is a bcrypt hash
+type WebUserJSON struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// ScheduleConfigJSON configures the jobs run in daemon mode
+type ScheduleConfigJSON struct {
+	Timezone  string            `json:"timezone"`
+	StateFile string            `json:"state_file"`
+	Jobs      []ScheduleJobJSON `json:"jobs"`
+}
+
+// ScheduleJobJSON is one scheduled job; CatchUpWindow is in seconds
+type ScheduleJobJSON struct {
+	Name          string `json:"name"`
+	Cron          string `json:"cron"`
+	Overlap       string `json:"overlap"`
+	CatchUp       string `json:"catch_up"`
+	CatchUpWindow int    `json:"catch_up_window"`
+	DaysToSync    int    `json:"days_to_sync"`
+}
+
+// NotificationsConfigJSON configures the messages sent when a run finishes
+type NotificationsConfigJSON struct {
+	MaxFailedFiles int                 `json:"max_failed_files"`
+	Email          *EmailConfigJSON    `json:"email"`
+	Webhooks       []WebhookConfigJSON `json:"webhooks"`
+}
+
+// EmailConfigJSON sends notifications by SMTP. On lists the triggers that send
+// a message: failure, partial, cancelled, success and no_files.
+type EmailConfigJSON struct {
+	SMTPHost    string   `json:"smtp_host"`
+	SMTPPort    int      `json:"smtp_port"`
+	Username    string   `json:"username"`
+	Password    string   `json:"password"`
+	ImplicitTLS bool     `json:"implicit_tls"`
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+	On          []string `json:"on"`
+}
+
+// WebhookConfigJSON posts notifications as JSON to URL, e.g. a Slack or Teams
+// incoming webhook
+type WebhookConfigJSON struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	On      []string          `json:"on"`
+}
+
+// LoadConfig loads configuration from JSON file with environment variable fallback
+func LoadConfig(configPath string) (*Config, error) {
+	config := &Config{}
+
+	// First try to load from JSON file
+	if _, err := os.Stat(configPath); err == nil {
+		log.Printf("Loading configuration from %s", configPath)
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+
+		log.Println("Configuration loaded from JSON file")
+	} else {
+		log.Printf("Config file %s not found, using environment variables", configPath)
+	}
+
+	// Override with environment variables if they exist
+	loadFromEnv(config)
+
+	// Fill in what is left from ~/.ssh/config host aliases
+	if err := resolveSSHConfig(&config.Source); err != nil {
+		return nil, fmt.Errorf("source ssh_config_host: %w", err)
+	}
+	if err := resolveSSHConfig(&config.Destination); err != nil {
+		return nil, fmt.Errorf("destination ssh_config_host: %w", err)
+	}
+
+	return config, nil
+}
+
+// loadFromEnv loads configuration from environment variables
+func loadFromEnv(config *Config) {
+	// Source SFTP configuration
+	if host := os.Getenv("SOURCE_HOST"); host != "" {
+		config.Source.Host = host
+	}
+	if port := os.Getenv("SOURCE_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			config.Source.Port = p
+		}
+	}
+	if username := os.Getenv("SOURCE_USERNAME"); username != "" {
+		config.Source.Username = username
+	}
+	if password := os.Getenv("SOURCE_PASSWORD"); password != "" {
+		config.Source.Password = password
+	}
+	if keyfile := os.Getenv("SOURCE_KEYFILE"); keyfile != "" {
+		config.Source.KeyFile = keyfile
+	}
+	if timeout := os.Getenv("SOURCE_TIMEOUT"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil {
+			config.Source.Timeout = t
+		}
+	}
+	if keepalive := os.Getenv("SOURCE_KEEPALIVE"); keepalive != "" {
+		if k, err := strconv.Atoi(keepalive); err == nil {
+			config.Source.KeepAlive = k
+		}
+	}
+	if passphrase := os.Getenv("SOURCE_KEY_PASSPHRASE"); passphrase != "" {
+		config.Source.KeyPassphrase = passphrase
+	}
+	if passphraseFile := os.Getenv("SOURCE_KEY_PASSPHRASE_FILE"); passphraseFile != "" {
+		config.Source.KeyPassphraseFile = passphraseFile
+	}
+	if certificate := os.Getenv("SOURCE_CERTIFICATE_FILE"); certificate != "" {
+		config.Source.CertificateFile = certificate
+	}
+	if useAgent := os.Getenv("SOURCE_USE_AGENT"); useAgent != "" {
+		if u, err := strconv.ParseBool(useAgent); err == nil {
+			config.Source.UseAgent = u
+		}
+	}
+	if alias := os.Getenv("SOURCE_SSH_CONFIG_HOST"); alias != "" {
+		config.Source.SSHConfigHost = alias
+	}
+	if file := os.Getenv("SOURCE_SSH_CONFIG_FILE"); file != "" {
+		config.Source.SSHConfigFile = file
+	}
+	if proxy := os.Getenv("SOURCE_PROXY"); proxy != "" {
+		config.Source.Proxy = proxy
+	}
+	if password := os.Getenv("SOURCE_PROXY_PASSWORD"); password != "" {
+		config.Source.ProxyPassword = password
+	}
+	if maxSessions := os.Getenv("SOURCE_MAX_SESSIONS"); maxSessions != "" {
+		if m, err := strconv.Atoi(maxSessions); err == nil {
+			config.Source.MaxSessions = m
+		}
+	}
+	if perConnection := os.Getenv("SOURCE_SESSIONS_PER_CONNECTION"); perConnection != "" {
+		if p, err := strconv.Atoi(perConnection); err == nil {
+			config.Source.SessionsPerConnection = p
+		}
+	}
+	if policy := os.Getenv("SOURCE_HOST_KEY_POLICY"); policy != "" {
+		config.Source.HostKeyPolicy = policy
+	}
+	if knownHosts := os.Getenv("SOURCE_KNOWN_HOSTS_FILE"); knownHosts != "" {
+		config.Source.KnownHostsFile = knownHosts
+	}
+	if fingerprints := os.Getenv("SOURCE_HOST_KEY_FINGERPRINTS"); fingerprints != "" {
+		config.Source.HostKeyFingerprints = strings.Split(fingerprints, ",")
+	}
+	if kex := os.Getenv("SOURCE_KEY_EXCHANGES"); kex != "" {
+		config.Source.KeyExchanges = strings.Split(kex, ",")
+	}
+	if ciphers := os.Getenv("SOURCE_CIPHERS"); ciphers != "" {
+		config.Source.Ciphers = strings.Split(ciphers, ",")
+	}
+	if macs := os.Getenv("SOURCE_MACS"); macs != "" {
+		config.Source.MACs = strings.Split(macs, ",")
+	}
+	if algorithms := os.Getenv("SOURCE_HOST_KEY_ALGORITHMS"); algorithms != "" {
+		config.Source.HostKeyAlgorithms = strings.Split(algorithms, ",")
+	}
+
+	// Destination SFTP configuration
+	if host := os.Getenv("DEST_HOST"); host != "" {
+		config.Destination.Host = host
+	}
+	if port := os.Getenv("DEST_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			config.Destination.Port = p
+		}
+	}
+	if username := os.Getenv("DEST_USERNAME"); username != "" {
+		config.Destination.Username = username
+	}
+	if password := os.Getenv("DEST_PASSWORD"); password != "" {
+		config.Destination.Password = password
+	}
+	if keyfile := os.Getenv("DEST_KEYFILE"); keyfile != "" {
+		config.Destination.KeyFile = keyfile
+	}
+	if timeout := os.Getenv("DEST_TIMEOUT"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil {
+			config.Destination.Timeout = t
+		}
+	}
+	if keepalive := os.Getenv("DEST_KEEPALIVE"); keepalive != "" {
+		if k, err := strconv.Atoi(keepalive); err == nil {
+			config.Destination.KeepAlive = k
+		}
+	}
+	if passphrase := os.Getenv("DEST_KEY_PASSPHRASE"); passphrase != "" {
+		config.Destination.KeyPassphrase = passphrase
+	}
+	if passphraseFile := os.Getenv("DEST_KEY_PASSPHRASE_FILE"); passphraseFile != "" {
+		config.Destination.KeyPassphraseFile = passphraseFile
+	}
+	if certificate := os.Getenv("DEST_CERTIFICATE_FILE"); certificate != "" {
+		config.Destination.CertificateFile = certificate
+	}
+	if useAgent := os.Getenv("DEST_USE_AGENT"); useAgent != "" {
+		if u, err := strconv.ParseBool(useAgent); err == nil {
+			config.Destination.UseAgent = u
+		}
+	}
+	if alias := os.Getenv("DEST_SSH_CONFIG_HOST"); alias != "" {
+		config.Destination.SSHConfigHost = alias
+	}
+	if file := os.Getenv("DEST_SSH_CONFIG_FILE"); file != "" {
+		config.Destination.SSHConfigFile = file
+	}
+	if proxy := os.Getenv("DEST_PROXY"); proxy != "" {
+		config.Destination.Proxy = proxy
+	}
+	if password := os.Getenv("DEST_PROXY_PASSWORD"); password != "" {
+		config.Destination.ProxyPassword = password
+	}
+	if maxSessions := os.Getenv("DEST_MAX_SESSIONS"); maxSessions != "" {
+		if m, err := strconv.Atoi(maxSessions); err == nil {
+			config.Destination.MaxSessions = m
+		}
+	}
+	if perConnection := os.Getenv("DEST_SESSIONS_PER_CONNECTION"); perConnection != "" {
+		if p, err := strconv.Atoi(perConnection); err == nil {
+			config.Destination.SessionsPerConnection = p
+		}
+	}
+	if policy := os.Getenv("DEST_HOST_KEY_POLICY"); policy != "" {
+		config.Destination.HostKeyPolicy = policy
+	}
+	if knownHosts := os.Getenv("DEST_KNOWN_HOSTS_FILE"); knownHosts != "" {
+		config.Destination.KnownHostsFile = knownHosts
+	}
+	if fingerprints := os.Getenv("DEST_HOST_KEY_FINGERPRINTS"); fingerprints != "" {
+		config.Destination.HostKeyFingerprints = strings.Split(fingerprints, ",")
+	}
+	if kex := os.Getenv("DEST_KEY_EXCHANGES"); kex != "" {
+		config.Destination.KeyExchanges = strings.Split(kex, ",")
+	}
+	if ciphers := os.Getenv("DEST_CIPHERS"); ciphers != "" {
+		config.Destination.Ciphers = strings.Split(ciphers, ",")
+	}
+	if macs := os.Getenv("DEST_MACS"); macs != "" {
+		config.Destination.MACs = strings.Split(macs, ",")
+	}
+	if algorithms := os.Getenv("DEST_HOST_KEY_ALGORITHMS"); algorithms != "" {
+		config.Destination.HostKeyAlgorithms = strings.Split(algorithms, ",")
+	}
+
+	// Sync configuration
+	if sourcePath := os.Getenv("SOURCE_PATH"); sourcePath != "" {
+		config.Sync.SourcePath = sourcePath
+	}
+	if destPath := os.Getenv("DEST_PATH"); destPath != "" {
+		config.Sync.DestinationPath = destPath
+	}
+	if excludePatterns := os.Getenv("EXCLUDE_PATTERNS"); excludePatterns != "" {
+		config.Sync.ExcludePatterns = strings.Split(excludePatterns, ",")
+	}
+	if maxConcurrent := os.Getenv("MAX_CONCURRENT_TRANSFERS"); maxConcurrent != "" {
+		if m, err := strconv.Atoi(maxConcurrent); err == nil {
+			config.Sync.MaxConcurrentTransfers = m
+		}
+	}
+	if chunkSize := os.Getenv("CHUNK_SIZE"); chunkSize != "" {
+		if c, err := strconv.Atoi(chunkSize); err == nil {
+			config.Sync.ChunkSize = c
+		}
+	}
+	if retryAttempts := os.Getenv("RETRY_ATTEMPTS"); retryAttempts != "" {
+		if r, err := strconv.Atoi(retryAttempts); err == nil {
+			config.Sync.RetryAttempts = r
+		}
+	}
+	if retryDelay := os.Getenv("RETRY_DELAY"); retryDelay != "" {
+		if r, err := strconv.Atoi(retryDelay); err == nil {
+			config.Sync.RetryDelay = r
+		}
+	}
+	if reconnectAttempts := os.Getenv("RECONNECT_ATTEMPTS"); reconnectAttempts != "" {
+		if r, err := strconv.Atoi(reconnectAttempts); err == nil {
+			config.Sync.ReconnectAttempts = r
+		}
+	}
+	if verifyTransfers := os.Getenv("VERIFY_TRANSFERS"); verifyTransfers != "" {
+		if v, err := strconv.ParseBool(verifyTransfers); err == nil {
+			config.Sync.VerifyTransfers = v
+		}
+	}
+	if daysToSync := os.Getenv("DAYS_TO_SYNC"); daysToSync != "" {
+		if d, err := strconv.Atoi(daysToSync); err == nil {
+			config.Sync.DaysToSync = d
+		}
+	}
+	if dateLayout := os.Getenv("DATE_LAYOUT"); dateLayout != "" {
+		config.Sync.DateLayout = dateLayout
+	}
+	if destDateLayout := os.Getenv("DEST_DATE_LAYOUT"); destDateLayout != "" {
+		config.Sync.DestDateLayout = destDateLayout
+	}
+	if compareMode := os.Getenv("COMPARE_MODE"); compareMode != "" {
+		config.Sync.CompareMode = compareMode
+	}
+	if mtimeTolerance := os.Getenv("MTIME_TOLERANCE"); mtimeTolerance != "" {
+		if m, err := strconv.Atoi(mtimeTolerance); err == nil {
+			config.Sync.MtimeTolerance = m
+		}
+	}
+	if manifestFile := os.Getenv("MANIFEST_FILE"); manifestFile != "" {
+		config.Sync.ManifestFile = manifestFile
+	}
+	if mirror := os.Getenv("MIRROR"); mirror != "" {
+		if m, err := strconv.ParseBool(mirror); err == nil {
+			config.Sync.Mirror = m
+		}
+	}
+	if maxDeletions := os.Getenv("MAX_DELETIONS"); maxDeletions != "" {
+		if m, err := strconv.Atoi(maxDeletions); err == nil {
+			config.Sync.MaxDeletions = m
+		}
+	}
+	if maxDeletionPercent := os.Getenv("MAX_DELETION_PERCENT"); maxDeletionPercent != "" {
+		if m, err := strconv.ParseFloat(maxDeletionPercent, 64); err == nil {
+			config.Sync.MaxDeletionPercent = m
+		}
+	}
+
+	if historyFile := os.Getenv("HISTORY_FILE"); historyFile != "" {
+		config.Sync.HistoryFile = historyFile
+	}
+	if retention := os.Getenv("HISTORY_RETENTION_DAYS"); retention != "" {
+		if r, err := strconv.Atoi(retention); err == nil {
+			config.Sync.HistoryRetentionDays = r
+		}
+	}
+	if maxRuns := os.Getenv("HISTORY_MAX_RUNS"); maxRuns != "" {
+		if m, err := strconv.Atoi(maxRuns); err == nil {
+			config.Sync.HistoryMaxRuns = m
+		}
+	}
+
+	// Web GUI configuration
+	if bind := os.Getenv("WEB_BIND"); bind != "" {
+		config.Web.Bind = bind
+	}
+	if port := os.Getenv("WEB_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			config.Web.Port = p
+		}
+	}
+	if tlsCert := os.Getenv("WEB_TLS_CERT"); tlsCert != "" {
+		config.Web.TLSCert = tlsCert
+	}
+	if tlsKey := os.Getenv("WEB_TLS_KEY"); tlsKey != "" {
+		config.Web.TLSKey = tlsKey
+	}
+	if sessionTimeout := os.Getenv("WEB_SESSION_TIMEOUT"); sessionTimeout != "" {
+		if t, err := strconv.Atoi(sessionTimeout); err == nil {
+			config.Web.SessionTimeout = t
+		}
+	}
+	if users := os.Getenv("WEB_USERS"); users != "" {
+		// username:bcrypt-hash pairs separated by commas; bcrypt hashes contain neither
+		config.Web.Users = nil
+		for _, entry := range strings.Split(users, ",") {
+			username, hash, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if ok {
+				config.Web.Users = append(config.Web.Users, WebUserJSON{Username: username, PasswordHash: hash})
+			}
+		}
+	}
+
+	// Schedule configuration; SCHEDULE_CRON replaces the configured jobs with one
+	if cron := os.Getenv("SCHEDULE_CRON"); cron != "" {
+		config.Schedule.Jobs = []ScheduleJobJSON{{Name: "sync", Cron: cron}}
+	}
+	if timezone := os.Getenv("SCHEDULE_TIMEZONE"); timezone != "" {
+		config.Schedule.Timezone = timezone
+	}
+	if stateFile := os.Getenv("SCHEDULE_STATE_FILE"); stateFile != "" {
+		config.Schedule.StateFile = stateFile
+	}
+
+	// Notifications; only the SMTP password, to keep it out of the file
+	if password := os.Getenv("NOTIFY_SMTP_PASSWORD"); password != "" && config.Notifications.Email != nil {
+		config.Notifications.Email.Password = password
+	}
+
+	log.Println("Configuration loaded from environment variables")
+}
+
+// ConvertToSFTPConfig converts JSON config to internal SFTP config
+func ConvertToSFTPConfig(jsonConfig SFTPConfigJSON) SFTPConfig {
+	passphrase := jsonConfig.KeyPassphrase
+	if passphrase == "" && jsonConfig.KeyPassphraseEnv != "" {
+		passphrase = os.Getenv(jsonConfig.KeyPassphraseEnv)
+	}
+	var answers []KeyboardInteractiveAnswer
+	for _, answer := range jsonConfig.KeyboardInteractive {
+		value := answer.Answer
+		if answer.AnswerEnv != "" {
+			value = os.Getenv(answer.AnswerEnv)
+		}
+		answers = append(answers, KeyboardInteractiveAnswer{Prompt: answer.Prompt, Answer: value})
+	}
+	proxyPassword := jsonConfig.ProxyPassword
+	if proxyPassword == "" && jsonConfig.ProxyPasswordEnv != "" {
+		proxyPassword = os.Getenv(jsonConfig.ProxyPasswordEnv)
+	}
+	port := jsonConfig.Port
+	if port == 0 {
+		port = 22
+	}
+	var jumpHosts []SFTPConfig
+	for _, hop := range jsonConfig.JumpHosts {
+		jumpHosts = append(jumpHosts, ConvertToSFTPConfig(hop))
+	}
+
+	return SFTPConfig{
+		Host:      jsonConfig.Host,
+		Port:      port,
+		Username:  jsonConfig.Username,
+		Password:  jsonConfig.Password,
+		KeyFile:   jsonConfig.KeyFile,
+		Timeout:   time.Duration(jsonConfig.Timeout) * time.Second,
+		KeepAlive: time.Duration(jsonConfig.KeepAlive) * time.Second,
+
+		KeyPassphrase:       passphrase,
+		KeyPassphraseFile:   jsonConfig.KeyPassphraseFile,
+		CertificateFile:     jsonConfig.CertificateFile,
+		UseAgent:            jsonConfig.UseAgent,
+		KeyboardInteractive: answers,
+
+		MaxSessions:           jsonConfig.MaxSessions,
+		SessionsPerConnection: jsonConfig.SessionsPerConnection,
+
+		HostKeyPolicy:       jsonConfig.HostKeyPolicy,
+		KnownHostsFile:      jsonConfig.KnownHostsFile,
+		HostKeyFingerprints: jsonConfig.HostKeyFingerprints,
+
+		KeyExchanges:      jsonConfig.KeyExchanges,
+		Ciphers:           jsonConfig.Ciphers,
+		MACs:              jsonConfig.MACs,
+		HostKeyAlgorithms: jsonConfig.HostKeyAlgorithms,
+
+		JumpHosts: jumpHosts,
+
+		Proxy:         jsonConfig.Proxy,
+		ProxyPassword: proxyPassword,
+	}
+}
+
+// ConvertToSyncConfig converts JSON config to internal sync config
+func ConvertToSyncConfig(jsonConfig SyncConfigJSON) SyncConfig {
+	compareMode := jsonConfig.CompareMode
+	if compareMode == "" {
+		compareMode = CompareSizeMtime
+	}
+	dateLayout := jsonConfig.DateLayout
+	if dateLayout == "" {
+		dateLayout = DefaultDateLayout
+	}
+
+	return SyncConfig{
+		SourcePath:             jsonConfig.SourcePath,
+		DestinationPath:        jsonConfig.DestinationPath,
+		ExcludePatterns:        jsonConfig.ExcludePatterns,
+		MaxConcurrentTransfers: jsonConfig.MaxConcurrentTransfers,
+		ChunkSize:              jsonConfig.ChunkSize,
+		RetryAttempts:          jsonConfig.RetryAttempts,
+		RetryDelay:             time.Duration(jsonConfig.RetryDelay) * time.Second,
+		ReconnectAttempts:      jsonConfig.ReconnectAttempts,
+		VerifyTransfers:        jsonConfig.VerifyTransfers,
+		DaysToSync:             jsonConfig.DaysToSync,
+		DateLayout:             dateLayout,
+		DestDateLayout:         jsonConfig.DestDateLayout,
+		CompareMode:            compareMode,
+		MtimeTolerance:         time.Duration(jsonConfig.MtimeTolerance) * time.Second,
+		ManifestFile:           jsonConfig.ManifestFile,
+		Mirror:                 jsonConfig.Mirror,
+		MaxDeletions:           jsonConfig.MaxDeletions,
+		MaxDeletionPercent:     jsonConfig.MaxDeletionPercent,
+		HistoryFile:            jsonConfig.HistoryFile,
+		HistoryRetention:       time.Duration(jsonConfig.HistoryRetentionDays) * 24 * time.Hour,
+		HistoryMaxRuns:         jsonConfig.HistoryMaxRuns,
+	}
+}
+
+// ValidateConfig runs every check on the source, destination and sync settings
+// that can be made before connecting, and returns the first problem found.
+// Host aliases from ~/.ssh/config must already be resolved, as LoadConfig does.
+func ValidateConfig(source, dest SFTPConfig, sync SyncConfig) error {
+	endpoints := []struct {
+		name   string
+		config SFTPConfig
+	}{
+		{"source", source},
+		{"destination", dest},
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.config.Host == "" || endpoint.config.Username == "" {
+			return fmt.Errorf("%s SFTP configuration is incomplete (host and username are required, directly or through ssh_config_host)", endpoint.name)
+		}
+	}
+	for _, endpoint := range endpoints {
+		if err := ValidateAuth(endpoint.config); err != nil {
+			return fmt.Errorf("%s authentication: %w", endpoint.name, err)
+		}
+		if err := ValidateHostKeyPolicy(endpoint.config); err != nil {
+			return fmt.Errorf("%s host keys: %w", endpoint.name, err)
+		}
+		if err := ValidateProxy(endpoint.config); err != nil {
+			return fmt.Errorf("%s proxy: %w", endpoint.name, err)
+		}
+		if err := ValidateAlgorithms(endpoint.config); err != nil {
+			return fmt.Errorf("%s algorithms: %w", endpoint.name, err)
+		}
+	}
+	if err := ValidateCompareMode(sync); err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	if err := ValidateDateLayouts(sync); err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	return nil
+}
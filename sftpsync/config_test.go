@@ -0,0 +1,48 @@
+package sftpsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	endpoint := SFTPConfig{Host: "sftp.example.com", Port: 22, Username: "sync", Password: "secret"}
+	sync := SyncConfig{CompareMode: CompareSizeMtime, DateLayout: DefaultDateLayout}
+
+	tests := []struct {
+		name    string
+		change  func(source, dest *SFTPConfig, sync *SyncConfig)
+		wantErr string
+	}{
+		{"valid", func(source, dest *SFTPConfig, sync *SyncConfig) {}, ""},
+		{"source without host", func(source, dest *SFTPConfig, sync *SyncConfig) { source.Host = "" }, "source SFTP configuration is incomplete"},
+		{"destination without username", func(source, dest *SFTPConfig, sync *SyncConfig) { dest.Username = "" }, "destination SFTP configuration is incomplete"},
+		{"no credentials", func(source, dest *SFTPConfig, sync *SyncConfig) { dest.Password = "" }, "destination authentication:"},
+		{"bad host key policy", func(source, dest *SFTPConfig, sync *SyncConfig) { source.HostKeyPolicy = "any" }, "source host keys:"},
+		{"jump host policy", func(source, dest *SFTPConfig, sync *SyncConfig) {
+			dest.JumpHosts = []SFTPConfig{{Host: "bastion", Username: "hop", Password: "pw", HostKeyPolicy: HostKeyPolicyFingerprint}}
+		}, "destination host keys: jump host 1"},
+		{"bad proxy", func(source, dest *SFTPConfig, sync *SyncConfig) { source.Proxy = "ftp://proxy.example.com" }, "source proxy:"},
+		{"bad cipher", func(source, dest *SFTPConfig, sync *SyncConfig) { dest.Ciphers = []string{"rot13"} }, "destination algorithms:"},
+		{"bad compare mode", func(source, dest *SFTPConfig, sync *SyncConfig) { sync.CompareMode = "guess" }, "sync:"},
+		{"bad date layout", func(source, dest *SFTPConfig, sync *SyncConfig) { sync.DateLayout = "YYYYMMDDX" }, "sync:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			source, dest, sync := endpoint, endpoint, sync
+			tt.change(&source, &dest, &sync)
+
+			err := ValidateConfig(source, dest, sync)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateConfig() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
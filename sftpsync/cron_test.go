@@ -0,0 +1,93 @@
+package sftpsync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@fortnightly",
+		"0 0 30 feb *",
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseCron(expr, time.UTC); err == nil {
+				t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Friday 16 October 2026, 09:30
+	from := time.Date(2026, time.October, 16, 9, 30, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(time.October, 16, 9, 31)},
+		{"*/15 * * * *", at(time.October, 16, 9, 45)},
+		{"5/20 * * * *", at(time.October, 16, 9, 45)},
+		{"0 2 * * *", at(time.October, 17, 2, 0)},
+		{"30 9 * * *", at(time.October, 17, 9, 30)},
+		{"0 9-17 * * *", at(time.October, 16, 10, 0)},
+		{"0,45 9 * * *", at(time.October, 16, 9, 45)},
+		{"0 8 * * mon-fri", at(time.October, 19, 8, 0)},
+		{"0 8 * * 0", at(time.October, 18, 8, 0)},
+		{"0 8 * * 7", at(time.October, 18, 8, 0)},
+		{"0 0 1 * *", at(time.November, 1, 0, 0)},
+		{"0 0 1 jan *", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", at(time.October, 23, 0, 0)}, // day of month or weekday
+		{"0 0 29 feb *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", at(time.October, 16, 10, 0)},
+		{"@daily", at(time.October, 17, 0, 0)},
+		{"@weekly", at(time.October, 18, 0, 0)},
+		{"@monthly", at(time.November, 1, 0, 0)},
+		{"@YEARLY", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextInLocation(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	schedule, err := ParseCron("0 2 * * *", kolkata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 21:00 UTC is 02:30 IST, so 02:00 IST has just passed
+	got := schedule.Next(time.Date(2026, time.October, 16, 21, 0, 0, 0, time.UTC))
+	want := time.Date(2026, time.October, 17, 20, 30, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("Next() = %s, want %s", got.UTC(), want)
+	}
+}
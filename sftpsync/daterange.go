@@ -0,0 +1,156 @@
+package sftpsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DateFormat is how dates are given on the command line and to the web API
+const DateFormat = "2006-01-02"
+
+// DateRange restricts a run to explicit dates instead of the last DaysToSync days
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// IsZero reports whether no range was given
+func (r DateRange) IsZero() bool {
+	return r.From.IsZero()
+}
+
+// String renders the range for logs
+func (r DateRange) String() string {
+	if r.From.Equal(r.To) {
+		return r.From.Format(DateFormat)
+	}
+	return r.From.Format(DateFormat) + " to " + r.To.Format(DateFormat)
+}
+
+// ParseDateRange builds a range from YYYY-MM-DD strings. date selects a single
+// day and cannot be combined with from/to; from without to runs up to today.
+// All empty returns the zero range.
+func ParseDateRange(from, to, date string) (DateRange, error) {
+	parse := func(name, value string) (time.Time, error) {
+		t, err := time.ParseInLocation(DateFormat, value, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s %q (expected YYYY-MM-DD)", name, value)
+		}
+		return t, nil
+	}
+
+	if date != "" {
+		if from != "" || to != "" {
+			return DateRange{}, fmt.Errorf("date cannot be combined with from/to")
+		}
+		d, err := parse("date", date)
+		if err != nil {
+			return DateRange{}, err
+		}
+		return DateRange{From: d, To: d}, nil
+	}
+
+	if from == "" {
+		if to != "" {
+			return DateRange{}, fmt.Errorf("to requires from")
+		}
+		return DateRange{}, nil
+	}
+
+	var r DateRange
+	var err error
+	if r.From, err = parse("from date", from); err != nil {
+		return DateRange{}, err
+	}
+	if to == "" {
+		now := time.Now()
+		r.To = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	} else if r.To, err = parse("to date", to); err != nil {
+		return DateRange{}, err
+	}
+	if r.To.Before(r.From) {
+		return DateRange{}, fmt.Errorf("from %s is after to %s", r.From.Format(DateFormat), r.To.Format(DateFormat))
+	}
+
+	return r, nil
+}
+
+// DateResult is the outcome of one date in a backfill run
+type DateResult struct {
+	Date        string        `json:"date"`
+	Directory   string        `json:"directory"`
+	Transferred int           `json:"transferred"`
+	Skipped     int           `json:"skipped"`
+	Failed      int           `json:"failed"`
+	Deleted     int           `json:"deleted"`
+	Bytes       int64         `json:"bytes"`
+	Duration    time.Duration `json:"duration"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// dateWindow returns the dates a run covers: the configured range oldest-first,
+// or the last DaysToSync days newest-first
+func (s *SFTPSync) dateWindow() []DateDir {
+	if s.SyncConfig.Dates.IsZero() {
+		return s.generateDateDirectories(s.SyncConfig.DaysToSync)
+	}
+
+	var dirs []DateDir
+	for date := s.SyncConfig.Dates.From; !date.After(s.SyncConfig.Dates.To); date = date.AddDate(0, 0, 1) {
+		dirs = append(dirs, s.newDateDir(date))
+	}
+	return dirs
+}
+
+// backfillWithContext syncs each date on its own, oldest first, recording a
+// DateResult per date. A failed date does not stop the dates after it.
+func (s *SFTPSync) backfillWithContext(ctx context.Context, dateDirs []DateDir) error {
+	log.Printf("⏪ Backfill %s: %d dates, oldest first", s.SyncConfig.Dates, len(dateDirs))
+
+	failed := 0
+	for i, dateDir := range dateDirs {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		log.Printf("📅 [%d/%d] %s (%s)", i+1, len(dateDirs), dateDir.Date.Format(DateFormat), dateDir.Source)
+
+		before := s.Stats.Snapshot()
+		start := time.Now()
+		err := s.syncDatesWithContext(ctx, []DateDir{dateDir})
+		after := s.Stats.Snapshot()
+
+		result := DateResult{
+			Date:        dateDir.Date.Format(DateFormat),
+			Directory:   dateDir.Source,
+			Transferred: after.TransferredFiles - before.TransferredFiles,
+			Skipped:     after.SkippedFiles - before.SkippedFiles,
+			Failed:      after.FailedFiles - before.FailedFiles,
+			Deleted:     after.DeletedFiles - before.DeletedFiles,
+			Bytes:       after.TotalBytes - before.TotalBytes,
+			Duration:    time.Since(start),
+		}
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			result.Error = err.Error()
+			failed++
+			log.Printf("❌ %s failed: %v", result.Date, err)
+		}
+
+		s.Stats.mutex.Lock()
+		s.Stats.Dates = append(s.Stats.Dates, result)
+		s.Stats.mutex.Unlock()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dates failed", failed, len(dateDirs))
+	}
+	return nil
+}
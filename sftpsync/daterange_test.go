@@ -0,0 +1,100 @@
+package sftpsync
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDateRange(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.Local)
+	}
+	now := time.Now()
+	today := day(now.Year(), now.Month(), now.Day())
+
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		date     string
+		want     DateRange
+		wantErr  bool
+		wantText string
+	}{
+		{name: "nothing", want: DateRange{}},
+		{name: "range", from: "2026-09-28", to: "2026-10-02", want: DateRange{From: day(2026, 9, 28), To: day(2026, 10, 2)}, wantText: "2026-09-28 to 2026-10-02"},
+		{name: "one day range", from: "2026-10-16", to: "2026-10-16", want: DateRange{From: day(2026, 10, 16), To: day(2026, 10, 16)}, wantText: "2026-10-16"},
+		{name: "from until today", from: "2026-01-01", want: DateRange{From: day(2026, 1, 1), To: today}},
+		{name: "single date", date: "2026-02-28", want: DateRange{From: day(2026, 2, 28), To: day(2026, 2, 28)}, wantText: "2026-02-28"},
+		{name: "to without from", to: "2026-10-16", wantErr: true},
+		{name: "date with from", from: "2026-10-01", date: "2026-10-16", wantErr: true},
+		{name: "date with to", to: "2026-10-01", date: "2026-10-16", wantErr: true},
+		{name: "reversed", from: "2026-10-16", to: "2026-10-01", wantErr: true},
+		{name: "day month year order", from: "16-10-2026", wantErr: true},
+		{name: "no such day", date: "2026-02-30", wantErr: true},
+		{name: "bad to", from: "2026-10-01", to: "tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDateRange(tt.from, tt.to, tt.date)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDateRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) {
+				t.Errorf("ParseDateRange() = %v, want %v", got, tt.want)
+			}
+			if got.IsZero() != tt.want.From.IsZero() {
+				t.Errorf("IsZero() = %v", got.IsZero())
+			}
+			if tt.wantText != "" && got.String() != tt.wantText {
+				t.Errorf("String() = %q, want %q", got.String(), tt.wantText)
+			}
+		})
+	}
+}
+
+func TestDateWindow(t *testing.T) {
+	dates, err := ParseDateRange("2026-02-27", "2026-03-02", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{
+		Dates:          dates,
+		DateLayout:     "{YYYY}/{MM}/{DD}",
+		DestDateLayout: "KYC_{YYYY}{MM}{DD}",
+	})
+	var sources, dests []string
+	for _, dir := range s.dateWindow() {
+		sources = append(sources, dir.Source)
+		dests = append(dests, dir.Destination)
+	}
+
+	wantSources := []string{"2026/02/27", "2026/02/28", "2026/03/01", "2026/03/02"}
+	wantDests := []string{"KYC_20260227", "KYC_20260228", "KYC_20260301", "KYC_20260302"}
+	if !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("source directories = %v, want %v (oldest first)", sources, wantSources)
+	}
+	if !reflect.DeepEqual(dests, wantDests) {
+		t.Errorf("destination directories = %v, want %v", dests, wantDests)
+	}
+}
+
+func TestDateWindowLastDays(t *testing.T) {
+	s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{DaysToSync: 3})
+	dirs := s.dateWindow()
+	if len(dirs) != 3 {
+		t.Fatalf("got %d directories, want 3", len(dirs))
+	}
+
+	now := time.Now()
+	for i, dir := range dirs {
+		if want := FormatDateLayout(DefaultDateLayout, now.AddDate(0, 0, -i)); dir.Source != want || dir.Destination != want {
+			t.Errorf("directory %d = %+v, want %s (newest first)", i, dir, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package sftpsync
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// EventType identifies what an Event reports
+type EventType string
+
+// Events emitted by a sync run, in roughly the order they occur
+const (
+	// EventPhaseStarted opens a phase; Files and Size give its scope where known
+	EventPhaseStarted EventType = "phase_started"
+	// EventPhaseFinished closes a phase with its Duration and Error, if any
+	EventPhaseFinished EventType = "phase_finished"
+	// EventDirectoryScanned reports one date directory read on Side, with the Files and Dirs found
+	EventDirectoryScanned EventType = "directory_scanned"
+	// EventFileQueued reports a file planned for transfer and the Reason
+	EventFileQueued EventType = "file_queued"
+	// EventFileStarted reports a worker beginning to transfer a file
+	EventFileStarted EventType = "file_started"
+	// EventFileProgress reports Bytes written so far for a file being transferred
+	EventFileProgress EventType = "file_progress"
+	// EventFileDone reports a file transferred in full, with its MD5 Hash when verified
+	EventFileDone EventType = "file_done"
+	// EventFileFailed reports a file that could not be transferred after all retries
+	EventFileFailed EventType = "file_failed"
+	// EventConnectionLost reports that the connection to Side dropped during transfers and is being re-dialled
+	EventConnectionLost EventType = "connection_lost"
+	// EventFileDeleted reports a destination file removed in mirror mode
+	EventFileDeleted EventType = "file_deleted"
+	// EventProgress carries the overall Progress of the run
+	EventProgress EventType = "progress"
+	// EventRunSummary closes a run with its statistics, Result and final Error, if any
+	EventRunSummary EventType = "run_summary"
+)
+
+// Event is one thing the engine did. Which fields are set depends on Type.
+type Event struct {
+	Type     EventType      `json:"type"`
+	Time     time.Time      `json:"time"`
+	Phase    string         `json:"phase,omitempty"`
+	Side     string         `json:"side,omitempty"` // "source" or "destination"
+	Path     string         `json:"path,omitempty"` // relative to the sync root
+	Files    int            `json:"files,omitempty"`
+	Dirs     int            `json:"dirs,omitempty"`
+	Size     int64          `json:"size,omitempty"`
+	Bytes    int64          `json:"bytes,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	Duration time.Duration  `json:"duration,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Result   string         `json:"result,omitempty"`
+	Hash     string         `json:"hash,omitempty"`
+	Progress *Progress      `json:"progress,omitempty"`
+	Summary  *StatsSnapshot `json:"summary,omitempty"`
+}
+
+// EventHandler receives engine events. It is called from the sync's worker
+// goroutines, possibly concurrently, and must return quickly.
+type EventHandler func(Event)
+
+// eventSubscribers holds the handlers registered with Subscribe
+type eventSubscribers struct {
+	mutex    sync.RWMutex
+	nextID   int
+	handlers map[int]EventHandler
+}
+
+// Subscribe registers handler for every event from now on and returns a
+// function that removes it again
+func (s *SFTPSync) Subscribe(handler EventHandler) (unsubscribe func()) {
+	s.subscribers.mutex.Lock()
+	defer s.subscribers.mutex.Unlock()
+
+	if s.subscribers.handlers == nil {
+		s.subscribers.handlers = make(map[int]EventHandler)
+	}
+	id := s.subscribers.nextID
+	s.subscribers.nextID++
+	s.subscribers.handlers[id] = handler
+
+	return func() {
+		s.subscribers.mutex.Lock()
+		delete(s.subscribers.handlers, id)
+		s.subscribers.mutex.Unlock()
+	}
+}
+
+// emit stamps e and passes it to every subscriber
+func (s *SFTPSync) emit(e Event) {
+	s.subscribers.mutex.RLock()
+	if len(s.subscribers.handlers) == 0 {
+		s.subscribers.mutex.RUnlock()
+		return
+	}
+	handlers := make([]EventHandler, 0, len(s.subscribers.handlers))
+	for _, handler := range s.subscribers.handlers {
+		handlers = append(handlers, handler)
+	}
+	s.subscribers.mutex.RUnlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, handler := range handlers {
+		handler(e)
+	}
+}
+
+// startPhase emits EventPhaseStarted and returns the start time for finishPhase
+func (s *SFTPSync) startPhase(phase string, files int, size int64) time.Time {
+	s.emit(Event{Type: EventPhaseStarted, Phase: phase, Files: files, Size: size})
+	s.reportProgress(Progress{Phase: phase, FilesTotal: files, BytesTotal: size})
+	return time.Now()
+}
+
+// finishPhase emits EventPhaseFinished for a phase begun at started. A
+// connection failure names the server it concerns in Side.
+func (s *SFTPSync) finishPhase(phase string, started time.Time, err error) {
+	e := Event{Type: EventPhaseFinished, Phase: phase, Duration: time.Since(started)}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	var connectErr *ConnectError
+	if errors.As(err, &connectErr) {
+		e.Side = connectErr.Server
+	}
+	s.emit(e)
+}
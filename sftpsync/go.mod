@@ -0,0 +1,14 @@
+module github.com/skpjr001/oneclick-kra-sftp-sync/sftpsync
+
+go 1.24.5
+
+require (
+	github.com/pkg/sftp v1.13.9
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.39.0
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+)
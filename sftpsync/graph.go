@@ -0,0 +1,263 @@
+package sftpsync
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// FileInfo represents file metadata with hash
+type FileInfo struct {
+	Path         string
+	Size         int64
+	ModTime      time.Time
+	Hash         string
+	IsDirectory  bool
+	RelativePath string
+}
+
+// DirectoryGraph represents a directory structure with file hashes
+type DirectoryGraph struct {
+	RootPath   string
+	Files      map[string]*FileInfo
+	Dirs       map[string]bool
+	ScanErrors map[string]error
+	mutex      sync.RWMutex
+}
+
+// NewDirectoryGraph creates a new directory graph
+func NewDirectoryGraph(rootPath string) *DirectoryGraph {
+	return &DirectoryGraph{
+		RootPath:   rootPath,
+		Files:      make(map[string]*FileInfo),
+		Dirs:       make(map[string]bool),
+		ScanErrors: make(map[string]error),
+	}
+}
+
+// AddFile adds a file to the directory graph
+func (dg *DirectoryGraph) AddFile(fileInfo *FileInfo) {
+	dg.mutex.Lock()
+	defer dg.mutex.Unlock()
+	dg.Files[fileInfo.Path] = fileInfo
+}
+
+// AddDir adds a directory to the directory graph
+func (dg *DirectoryGraph) AddDir(dirPath string) {
+	dg.mutex.Lock()
+	defer dg.mutex.Unlock()
+	dg.Dirs[dirPath] = true
+}
+
+// AddScanError records a directory that could not be read
+func (dg *DirectoryGraph) AddScanError(dirPath string, err error) {
+	dg.mutex.Lock()
+	defer dg.mutex.Unlock()
+	dg.ScanErrors[dirPath] = err
+}
+
+// HasScanErrorsUnder reports whether dirPath or anything below it failed to scan
+func (dg *DirectoryGraph) HasScanErrorsUnder(dirPath string) bool {
+	dg.mutex.RLock()
+	defer dg.mutex.RUnlock()
+	for errPath := range dg.ScanErrors {
+		if errPath == dirPath || strings.HasPrefix(errPath, dirPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// scanFailedAbove reports whether a directory containing filePath failed to scan,
+// meaning the file's absence from the graph proves nothing
+func (dg *DirectoryGraph) scanFailedAbove(filePath string) bool {
+	dg.mutex.RLock()
+	defer dg.mutex.RUnlock()
+	for errPath := range dg.ScanErrors {
+		if strings.HasPrefix(filePath, errPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFile retrieves file info from the directory graph
+func (dg *DirectoryGraph) GetFile(filePath string) (*FileInfo, bool) {
+	dg.mutex.RLock()
+	defer dg.mutex.RUnlock()
+	file, exists := dg.Files[filePath]
+	return file, exists
+}
+
+// GetFileCount returns the number of files in the graph
+func (dg *DirectoryGraph) GetFileCount() int {
+	dg.mutex.RLock()
+	defer dg.mutex.RUnlock()
+	return len(dg.Files)
+}
+
+// buildDirectoryGraphWithContext builds a directory graph for specified date directories
+// on one side, "source" or "destination"
+func (s *SFTPSync) buildDirectoryGraphWithContext(ctx context.Context, side string, client *sftp.Client, rootPath string, dateDirs []string) (*DirectoryGraph, error) {
+	// Check for cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	graph := NewDirectoryGraph(rootPath)
+
+	log.Printf("Building directory graph for %d date directories...", len(dateDirs))
+
+	// Totals for the completion log; per-directory counts go out as events
+	var totalFiles int32
+	var totalDirs int32
+	startTime := time.Now()
+
+	var wg sync.WaitGroup
+	workers := s.SyncConfig.MaxConcurrentTransfers
+	if workers <= 0 {
+		workers = 1
+	}
+	semaphore := make(chan struct{}, workers)
+
+	for _, dateDir := range dateDirs {
+		wg.Add(1)
+		go func(dir string) {
+			defer wg.Done()
+
+			select {
+			case <-ctx.Done():
+				return
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+
+				var dirFiles, dirDirs int32
+				fullPath := path.Join(rootPath, dir)
+				event := Event{Type: EventDirectoryScanned, Side: side, Path: dir}
+				if err := s.scanDirectory(side, client, fullPath, rootPath, graph, &dirFiles, &dirDirs); err != nil {
+					log.Printf("Error scanning directory %s: %v", fullPath, err)
+					event.Error = err.Error()
+				}
+				atomic.AddInt32(&totalFiles, dirFiles)
+				atomic.AddInt32(&totalDirs, dirDirs)
+
+				event.Files = int(dirFiles)
+				event.Dirs = int(dirDirs)
+				s.emit(event)
+			}
+		}(dateDir)
+	}
+
+	// Wait for completion or cancellation
+	waitDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(waitDone)
+	}()
+
+	select {
+	case <-waitDone:
+		// Normal completion
+	case <-ctx.Done():
+		// Context cancelled, return early
+		return nil, ctx.Err()
+	}
+
+	finalFiles := atomic.LoadInt32(&totalFiles)
+	finalDirs := atomic.LoadInt32(&totalDirs)
+	elapsed := time.Since(startTime)
+	log.Printf("✅ Directory graph completed in %s: %d files, %d directories", elapsed.Round(time.Second), finalFiles, finalDirs)
+	return graph, nil
+}
+
+// scanDirectory recursively scans a directory and builds the graph
+func (s *SFTPSync) scanDirectory(side string, client *sftp.Client, dirPath, rootPath string, graph *DirectoryGraph, totalFiles, totalDirs *int32) error {
+	entries, err := client.ReadDir(dirPath)
+	if err != nil {
+		graph.AddScanError(dirPath, err)
+		return fmt.Errorf("failed to read directory %s: %v", dirPath, err)
+	}
+
+	graph.AddDir(dirPath)
+	atomic.AddInt32(totalDirs, 1)
+
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	for _, entry := range entries {
+		fullPath := path.Join(dirPath, entry.Name())
+
+		if s.shouldExcludeFile(fullPath) {
+			continue
+		}
+
+		// Partial transfers are resumed by the next sync, not treated as synced files
+		if side == "destination" && !entry.IsDir() && isPartialTransferFile(entry.Name(), names) {
+			continue
+		}
+
+		if entry.IsDir() {
+			if err := s.scanDirectory(side, client, fullPath, rootPath, graph, totalFiles, totalDirs); err != nil {
+				log.Printf("Error scanning subdirectory %s: %v", fullPath, err)
+			}
+		} else {
+			relativePath, _ := filepath.Rel(rootPath, fullPath)
+
+			fileInfo := &FileInfo{
+				Path:         fullPath,
+				Size:         entry.Size(),
+				ModTime:      entry.ModTime(),
+				IsDirectory:  false,
+				RelativePath: relativePath,
+			}
+
+			graph.AddFile(fileInfo)
+			atomic.AddInt32(totalFiles, 1)
+		}
+	}
+
+	return nil
+}
+
+// shouldExcludeFile checks if a file should be excluded based on patterns
+func (s *SFTPSync) shouldExcludeFile(filePath string) bool {
+	baseName := filepath.Base(filePath)
+
+	for _, pattern := range s.SyncConfig.ExcludePatterns {
+		if strings.Contains(filePath, pattern) || strings.HasPrefix(baseName, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// calculateRemoteFileHash calculates MD5 hash of a remote file
+func (s *SFTPSync) calculateRemoteFileHash(client *sftp.Client, filePath string) (string, error) {
+	file, err := client.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
@@ -0,0 +1,430 @@
+package sftpsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultHistoryRetention is how long runs are kept when history_retention_days is not set
+const DefaultHistoryRetention = 90 * 24 * time.Hour
+
+// File outcomes recorded in FileRecord.Outcome
+const (
+	FileTransferred = "transferred"
+	FileFailed      = "failed"
+	FileDeleted     = "deleted"
+)
+
+var (
+	historyRunsBucket  = []byte("runs")
+	historyFilesBucket = []byte("files")
+)
+
+// ErrRunNotFound is returned by History.Run for an unknown run id
+var ErrRunNotFound = errors.New("run not found")
+
+// RunRecord is one sync run as kept in the history
+type RunRecord struct {
+	ID         string         `json:"id"`
+	Trigger    string         `json:"trigger"`
+	ConfigHash string         `json:"config_hash"`
+	Source     string         `json:"source"`
+	Dest       string         `json:"destination"`
+	Dates      string         `json:"dates,omitempty"` // the explicit date range, if any
+	Started    time.Time      `json:"started"`
+	Finished   time.Time      `json:"finished"`
+	Result     string         `json:"result"`
+	Error      string         `json:"error,omitempty"`
+	Phases     []PhaseRecord  `json:"phases,omitempty"`
+	Summary    *StatsSnapshot `json:"summary,omitempty"`
+	FileCount  int            `json:"file_count"`      // number of Files, which are only loaded by History.Run
+	Files      []FileRecord   `json:"files,omitempty"` // transferred, failed and deleted files; up-to-date files are only counted
+}
+
+// PhaseRecord is one phase of a run; backfills repeat the phases for every date
+type PhaseRecord struct {
+	Phase    string        `json:"phase"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// FileRecord is the outcome of one file in a run
+type FileRecord struct {
+	Path     string        `json:"path"`
+	Outcome  string        `json:"outcome"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Hash     string        `json:"hash,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// History is a local bbolt database of past runs, newest last. Front ends open
+// it only for as long as they need it, so several processes can share one file.
+type History struct {
+	db *bolt.DB
+}
+
+// DefaultHistoryFile returns the history used when sync.history_file is not set
+func DefaultHistoryFile() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine user config directory: %v", err)
+	}
+	return filepath.Join(configDir, "oneclick-kra-sftp-sync", "history.db"), nil
+}
+
+// HistoryFileFor returns the history file a sync configuration writes to
+func HistoryFileFor(config SyncConfig) (string, error) {
+	if config.HistoryFile != "" {
+		return config.HistoryFile, nil
+	}
+	return DefaultHistoryFile()
+}
+
+// OpenHistory opens (creating if needed) the history file
+func OpenHistory(file string) (*History, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history %s: %v", file, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(historyRunsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(historyFilesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise history %s: %v", file, err)
+	}
+
+	return &History{db: db}, nil
+}
+
+// Close releases the history file
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// Add stores a run. The file list is kept apart so listing runs stays cheap.
+func (h *History) Add(run *RunRecord) error {
+	record := *run
+	record.FileCount = len(run.Files)
+	record.Files = nil
+	summary, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	files, err := json.Marshal(run.Files)
+	if err != nil {
+		return err
+	}
+
+	return h.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(historyRunsBucket).Put([]byte(run.ID), summary); err != nil {
+			return err
+		}
+		return tx.Bucket(historyFilesBucket).Put([]byte(run.ID), files)
+	})
+}
+
+// Runs returns up to limit runs, newest first, without their files. A limit of
+// 0 returns every run.
+func (h *History) Runs(limit int) ([]RunRecord, error) {
+	var runs []RunRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(historyRunsBucket).Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var run RunRecord
+			if err := json.Unmarshal(value, &run); err != nil {
+				return fmt.Errorf("corrupt history entry %s: %v", key, err)
+			}
+			runs = append(runs, run)
+			if limit > 0 && len(runs) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// Run returns one run with its files
+func (h *History) Run(id string) (*RunRecord, error) {
+	var run RunRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(historyRunsBucket).Get([]byte(id))
+		if value == nil {
+			return ErrRunNotFound
+		}
+		if err := json.Unmarshal(value, &run); err != nil {
+			return fmt.Errorf("corrupt history entry %s: %v", id, err)
+		}
+		if files := tx.Bucket(historyFilesBucket).Get([]byte(id)); files != nil {
+			if err := json.Unmarshal(files, &run.Files); err != nil {
+				return fmt.Errorf("corrupt history entry %s: %v", id, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Prune deletes runs started before now minus maxAge, and the oldest runs beyond
+// maxRuns. Zero disables either limit. It returns the number of runs deleted.
+func (h *History) Prune(maxAge time.Duration, maxRuns int) (int, error) {
+	cutoff := ""
+	if maxAge > 0 {
+		cutoff = historyID(time.Now().Add(-maxAge))
+	}
+
+	deleted := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(historyRunsBucket)
+		files := tx.Bucket(historyFilesBucket)
+
+		var doomed [][]byte
+		kept := 0
+		cursor := runs.Cursor()
+		for key, _ := cursor.Last(); key != nil; key, _ = cursor.Prev() {
+			if (cutoff != "" && string(key) < cutoff) || (maxRuns > 0 && kept >= maxRuns) {
+				doomed = append(doomed, append([]byte(nil), key...))
+				continue
+			}
+			kept++
+		}
+
+		for _, key := range doomed {
+			if err := runs.Delete(key); err != nil {
+				return err
+			}
+			if err := files.Delete(key); err != nil {
+				return err
+			}
+		}
+		deleted = len(doomed)
+		return nil
+	})
+	return deleted, err
+}
+
+// historyID names a run by its start time so that ids sort chronologically
+func historyID(started time.Time) string {
+	return started.UTC().Format("20060102-150405.000000")
+}
+
+// runRecorder builds a RunRecord from the events of one run
+type runRecorder struct {
+	mutex  sync.Mutex
+	record RunRecord
+	phases map[string]int // index in record.Phases of each open phase
+}
+
+func newRunRecorder(s *SFTPSync) *runRecorder {
+	started := time.Now()
+	trigger := s.Trigger
+	if trigger == "" {
+		trigger = "manual"
+	}
+	record := RunRecord{
+		ID:         historyID(started),
+		Trigger:    trigger,
+		ConfigHash: s.configHash(),
+		Source:     fmt.Sprintf("%s@%s:%d%s", s.SourceConfig.Username, s.SourceConfig.Host, s.SourceConfig.Port, s.SyncConfig.SourcePath),
+		Dest:       fmt.Sprintf("%s@%s:%d%s", s.DestinationConfig.Username, s.DestinationConfig.Host, s.DestinationConfig.Port, s.SyncConfig.DestinationPath),
+		Started:    started,
+	}
+	if !s.SyncConfig.Dates.IsZero() {
+		record.Dates = s.SyncConfig.Dates.String()
+	}
+	return &runRecorder{record: record, phases: make(map[string]int)}
+}
+
+// handle is an EventHandler
+func (r *runRecorder) handle(e Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch e.Type {
+	case EventPhaseStarted:
+		r.phases[e.Phase] = len(r.record.Phases)
+		r.record.Phases = append(r.record.Phases, PhaseRecord{Phase: e.Phase, Started: e.Time})
+	case EventPhaseFinished:
+		if i, ok := r.phases[e.Phase]; ok {
+			r.record.Phases[i].Duration = e.Duration
+			r.record.Phases[i].Error = e.Error
+			delete(r.phases, e.Phase)
+		}
+	case EventFileDone:
+		r.record.Files = append(r.record.Files, FileRecord{Path: e.Path, Outcome: FileTransferred, Size: e.Size, Duration: e.Duration, Hash: e.Hash})
+	case EventFileFailed:
+		r.record.Files = append(r.record.Files, FileRecord{Path: e.Path, Outcome: FileFailed, Size: e.Size, Duration: e.Duration, Error: e.Error})
+	case EventFileDeleted:
+		r.record.Files = append(r.record.Files, FileRecord{Path: e.Path, Outcome: FileDeleted, Size: e.Size})
+	case EventRunSummary:
+		r.record.Summary = e.Summary
+		r.record.Error = e.Error
+	}
+}
+
+// finish completes the record with the run's outcome
+func (r *runRecorder) finish(err error) *RunRecord {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.record.Finished = time.Now()
+	r.record.Result = runResult(err, r.record.Summary)
+	record := r.record
+	return &record
+}
+
+// runResult classifies a finished run as one of the Result* values
+func runResult(err error, summary *StatsSnapshot) string {
+	switch {
+	case err == nil && summary != nil && summary.FailedFiles > 0:
+		return ResultPartial
+	case err == nil:
+		return ResultSuccess
+	case errors.Is(err, context.Canceled):
+		return ResultCancelled
+	default:
+		return ResultFailed
+	}
+}
+
+// configHash fingerprints the settings that decide what a run does, leaving
+// out credentials, so runs with different configurations can be told apart
+func (s *SFTPSync) configHash() string {
+	endpoint := func(c SFTPConfig) string {
+		return fmt.Sprintf("%s@%s:%d key=%s policy=%s", c.Username, c.Host, c.Port, c.KeyFile, c.HostKeyPolicy)
+	}
+	syncConfig := s.SyncConfig
+	syncConfig.Dates = DateRange{}
+
+	hasher := sha256.New()
+	fmt.Fprintln(hasher, endpoint(s.SourceConfig))
+	fmt.Fprintln(hasher, endpoint(s.DestinationConfig))
+	json.NewEncoder(hasher).Encode(syncConfig)
+	return fmt.Sprintf("%x", hasher.Sum(nil))[:12]
+}
+
+// saveHistory stores a finished run and prunes old ones. The history is a
+// record, not part of the sync, so failures are only logged.
+func (s *SFTPSync) saveHistory(run *RunRecord) {
+	file, err := HistoryFileFor(s.SyncConfig)
+	if err != nil {
+		log.Printf("Warning: Run history disabled: %v", err)
+		return
+	}
+	history, err := OpenHistory(file)
+	if err != nil {
+		log.Printf("Warning: Run not recorded in history: %v", err)
+		return
+	}
+	defer history.Close()
+
+	if err := history.Add(run); err != nil {
+		log.Printf("Warning: Run not recorded in history: %v", err)
+		return
+	}
+
+	retention := s.SyncConfig.HistoryRetention
+	if retention == 0 {
+		retention = DefaultHistoryRetention
+	}
+	if retention < 0 {
+		retention = 0 // keep forever
+	}
+	if pruned, err := history.Prune(retention, s.SyncConfig.HistoryMaxRuns); err != nil {
+		log.Printf("Warning: Failed to prune run history: %v", err)
+	} else if pruned > 0 {
+		log.Printf("Pruned %d old run(s) from history", pruned)
+	}
+}
+
+// Print logs a run and its files
+func (r *RunRecord) Print() {
+	log.Println(strings.Repeat("=", 60))
+	log.Printf("🗂️  RUN %s", r.ID)
+	log.Println(strings.Repeat("=", 60))
+	log.Printf("   Trigger: %s, config %s", r.Trigger, r.ConfigHash)
+	log.Printf("   %s -> %s", r.Source, r.Dest)
+	if r.Dates != "" {
+		log.Printf("   Dates: %s", r.Dates)
+	}
+	log.Printf("   Started %s, finished %s (%v)", r.Started.Format(time.RFC1123), r.Finished.Format(time.RFC1123), r.Finished.Sub(r.Started).Round(time.Second))
+	log.Printf("   Result: %s", r.Result)
+	if r.Error != "" {
+		log.Printf("   Error: %s", r.Error)
+	}
+
+	if len(r.Phases) > 0 {
+		log.Printf("⏱️  PHASES:")
+		for _, p := range r.Phases {
+			line := fmt.Sprintf("   %-12s %v", p.Phase, p.Duration.Round(time.Millisecond))
+			if p.Error != "" {
+				line += "  " + p.Error
+			}
+			log.Println(line)
+		}
+	}
+
+	if s := r.Summary; s != nil {
+		log.Printf("📊 %d transferred, %d skipped, %d failed, %d deleted, %s", s.TransferredFiles, s.SkippedFiles, s.FailedFiles, s.DeletedFiles, formatBytes(s.TotalBytes))
+	}
+
+	if len(r.Files) > 0 {
+		log.Printf("📁 FILES:")
+		for _, f := range r.Files {
+			line := fmt.Sprintf("   %-11s %10s  %s", f.Outcome, formatBytes(f.Size), f.Path)
+			if f.Duration > 0 {
+				line += fmt.Sprintf(" (%v)", f.Duration.Round(time.Millisecond))
+			}
+			if f.Hash != "" {
+				line += " md5:" + f.Hash
+			}
+			if f.Error != "" {
+				line += "  " + f.Error
+			}
+			log.Println(line)
+		}
+	}
+	log.Println(strings.Repeat("=", 60))
+}
+
+// PrintRuns logs one line per run, as returned by History.Runs
+func PrintRuns(runs []RunRecord) {
+	if len(runs) == 0 {
+		log.Println("No runs recorded yet")
+		return
+	}
+	log.Printf("%-22s %-10s %-17s %-9s %s", "RUN", "RESULT", "TRIGGER", "DURATION", "FILES")
+	for _, r := range runs {
+		files := "-"
+		if s := r.Summary; s != nil {
+			files = fmt.Sprintf("%d transferred, %d failed, %d deleted, %s", s.TransferredFiles, s.FailedFiles, s.DeletedFiles, formatBytes(s.TotalBytes))
+		}
+		log.Printf("%-22s %-10s %-17s %-9v %s", r.ID, r.Result, r.Trigger, r.Finished.Sub(r.Started).Round(time.Second), files)
+	}
+}
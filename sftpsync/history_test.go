@@ -0,0 +1,188 @@
+package sftpsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testHistory opens a history database in a temporary directory
+func testHistory(t *testing.T) *History {
+	t.Helper()
+	history, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { history.Close() })
+	return history
+}
+
+// addTestRuns stores count runs started an hour apart, the last one an hour
+// before now, and returns their ids oldest first
+func addTestRuns(t *testing.T, history *History, count int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < count; i++ {
+		started := time.Now().Add(-time.Duration(count-i) * time.Hour)
+		run := &RunRecord{
+			ID:      historyID(started),
+			Trigger: "cli",
+			Started: started,
+			Result:  ResultSuccess,
+			Files:   []FileRecord{{Path: fmt.Sprintf("20261016/%d.csv", i), Outcome: FileTransferred, Size: int64(i)}},
+		}
+		if err := history.Add(run); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, run.ID)
+	}
+	return ids
+}
+
+func TestHistoryRuns(t *testing.T) {
+	history := testHistory(t)
+	ids := addTestRuns(t, history, 5)
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{0, []string{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+		{2, []string{ids[4], ids[3]}},
+		{10, []string{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+	}
+	for _, tt := range tests {
+		runs, err := history.Runs(tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, run := range runs {
+			got = append(got, run.ID)
+			if run.Files != nil || run.FileCount != 1 {
+				t.Errorf("run %s listed with files %v, count %d; want no files and a count of 1", run.ID, run.Files, run.FileCount)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Runs(%d) = %v, want newest first %v", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryRun(t *testing.T) {
+	history := testHistory(t)
+	ids := addTestRuns(t, history, 3)
+
+	run, err := history.Run(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileRecord{{Path: "20261016/1.csv", Outcome: FileTransferred, Size: 1}}
+	if run.ID != ids[1] || run.Trigger != "cli" || !reflect.DeepEqual(run.Files, want) {
+		t.Errorf("Run(%s) = %+v, want its own files %v", ids[1], run, want)
+	}
+
+	if _, err := history.Run("20000101-000000.000000"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("Run() of an unknown id error = %v, want ErrRunNotFound", err)
+	}
+}
+
+func TestOpenHistoryMissingFile(t *testing.T) {
+	// A missing file, and its directory, are created empty
+	file := filepath.Join(t.TempDir(), "state", "history.db")
+	history, err := OpenHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := history.Runs(0)
+	if err != nil || len(runs) != 0 {
+		t.Errorf("Runs() of a new history = %v, %v; want none", runs, err)
+	}
+	if _, err := history.Run("anything"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("Run() of a new history error = %v, want ErrRunNotFound", err)
+	}
+	history.Close()
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("history file mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	// A directory that cannot be created is reported
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenHistory(filepath.Join(blocker, "history.db")); err == nil {
+		t.Error("OpenHistory() below a regular file succeeded")
+	}
+}
+
+func TestHistoryPrune(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAge      time.Duration
+		maxRuns     int
+		wantDeleted int
+		wantKept    []int // indexes of the runs left, newest first
+	}{
+		{"no limits", 0, 0, 0, []int{4, 3, 2, 1, 0}},
+		{"by count", 0, 2, 3, []int{4, 3}},
+		{"by age", 150 * time.Minute, 0, 3, []int{4, 3}},
+		{"both", 210 * time.Minute, 1, 4, []int{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := testHistory(t)
+			ids := addTestRuns(t, history, 5)
+
+			deleted, err := history.Prune(tt.maxAge, tt.maxRuns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("Prune() deleted %d runs, want %d", deleted, tt.wantDeleted)
+			}
+			runs, err := history.Runs(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want []string
+			for _, run := range runs {
+				got = append(got, run.ID)
+			}
+			for _, i := range tt.wantKept {
+				want = append(want, ids[i])
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("runs left = %v, want %v", got, want)
+			}
+			if _, err := history.Run(ids[0]); tt.wantDeleted > 0 && !errors.Is(err, ErrRunNotFound) {
+				t.Errorf("pruned run still has files: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		summary *StatsSnapshot
+		want    string
+	}{
+		{"clean", nil, &StatsSnapshot{}, ResultSuccess},
+		{"no summary", nil, nil, ResultSuccess},
+		{"failed files", nil, &StatsSnapshot{FailedFiles: 2}, ResultPartial},
+		{"cancelled", fmt.Errorf("scan: %w", context.Canceled), nil, ResultCancelled},
+		{"error", errors.New("connection refused"), &StatsSnapshot{FailedFiles: 2}, ResultFailed},
+	}
+	for _, tt := range tests {
+		if got := runResult(tt.err, tt.summary); got != tt.want {
+			t.Errorf("%s: runResult() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package sftpsync
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key policies supported by SFTPConfig.HostKeyPolicy
+const (
+	HostKeyPolicyKnownHosts  = "known_hosts"
+	HostKeyPolicyFingerprint = "fingerprint"
+	HostKeyPolicyTOFU        = "tofu"
+)
+
+// HostKeyPrompt is asked whether an unknown host key should be trusted on
+// first use. It returns true to accept and record the key. Without a prompt,
+// unknown keys are rejected even under the tofu policy.
+type HostKeyPrompt func(host string, key ssh.PublicKey) bool
+
+// knownHostsMutex serialises appends to tool-managed known_hosts files
+var knownHostsMutex sync.Mutex
+
+// DefaultKnownHostsFile returns the tool-managed known_hosts path used for trust-on-first-use
+func DefaultKnownHostsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "oneclick-kra-sftp-sync", "known_hosts")
+}
+
+// hostKeyPolicy resolves the effective host key policy for an endpoint.
+// Trust on first use is never implied; it has to be chosen explicitly.
+func hostKeyPolicy(config SFTPConfig) string {
+	if config.HostKeyPolicy != "" {
+		return config.HostKeyPolicy
+	}
+	if len(config.HostKeyFingerprints) > 0 {
+		return HostKeyPolicyFingerprint
+	}
+	return HostKeyPolicyKnownHosts
+}
+
+// ValidateHostKeyPolicy checks that the host key settings of an endpoint and
+// of its jump hosts are usable
+func ValidateHostKeyPolicy(config SFTPConfig) error {
+	for i, hop := range config.JumpHosts {
+		if err := ValidateHostKeyPolicy(hop); err != nil {
+			return fmt.Errorf("jump host %d: %v", i+1, err)
+		}
+	}
+
+	switch hostKeyPolicy(config) {
+	case HostKeyPolicyKnownHosts, HostKeyPolicyTOFU:
+		return nil
+	case HostKeyPolicyFingerprint:
+		if len(config.HostKeyFingerprints) == 0 {
+			return fmt.Errorf("host key policy %q requires at least one entry in host_key_fingerprints", HostKeyPolicyFingerprint)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown host key policy %q (expected %s, %s or %s)",
+			config.HostKeyPolicy, HostKeyPolicyKnownHosts, HostKeyPolicyFingerprint, HostKeyPolicyTOFU)
+	}
+}
+
+// newHostKeyCallback builds the ssh.HostKeyCallback for an endpoint and the
+// host key algorithms to offer so the server presents a key we already know
+func newHostKeyCallback(config SFTPConfig, prompt HostKeyPrompt) (ssh.HostKeyCallback, []string, error) {
+	if err := ValidateHostKeyPolicy(config); err != nil {
+		return nil, nil, err
+	}
+
+	addr := net.JoinHostPort(config.Host, fmt.Sprintf("%d", config.Port))
+
+	switch hostKeyPolicy(config) {
+	case HostKeyPolicyFingerprint:
+		return fingerprintCallback(config.HostKeyFingerprints), nil, nil
+
+	case HostKeyPolicyKnownHosts:
+		file := config.KnownHostsFile
+		if file == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to locate home directory for known_hosts: %v", err)
+			}
+			file = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		callback, err := knownhosts.New(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load known_hosts file %s: %v", file, err)
+		}
+		return strictCallback(callback, file), knownKeyAlgorithms(callback, addr), nil
+
+	default:
+		file := config.KnownHostsFile
+		if file == "" {
+			file = DefaultKnownHostsFile()
+		}
+		if err := ensureKnownHostsFile(file); err != nil {
+			return nil, nil, err
+		}
+		callback, err := knownhosts.New(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load known_hosts file %s: %v", file, err)
+		}
+		return tofuCallback(callback, file, prompt), knownKeyAlgorithms(callback, addr), nil
+	}
+}
+
+// fingerprintCallback accepts only host keys matching one of the pinned fingerprints
+func fingerprintCallback(fingerprints []string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		sha256Fingerprint := ssh.FingerprintSHA256(key)
+		md5Fingerprint := ssh.FingerprintLegacyMD5(key)
+
+		for _, fp := range fingerprints {
+			fp = strings.TrimSpace(fp)
+			if fp == sha256Fingerprint || strings.TrimPrefix(fp, "MD5:") == md5Fingerprint {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("host key mismatch for %s: server presented %s %s which is not in host_key_fingerprints",
+			hostname, key.Type(), sha256Fingerprint)
+	}
+}
+
+// strictCallback rejects unknown and mismatched host keys with a descriptive error
+func strictCallback(callback ssh.HostKeyCallback, file string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("host %s is not in %s: server presented %s %s",
+				hostname, file, key.Type(), ssh.FingerprintSHA256(key))
+		}
+		return describeHostKeyError(hostname, key, err)
+	}
+}
+
+// tofuCallback records unknown host keys after confirmation and rejects changed ones
+func tofuCallback(callback ssh.HostKeyCallback, file string, prompt HostKeyPrompt) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return describeHostKeyError(hostname, key, err)
+		}
+
+		if prompt == nil {
+			return fmt.Errorf("host %s is not in %s and there is no one to confirm its key %s %s; "+
+				"connect once from the web or native GUI, or add the key to the file",
+				hostname, file, key.Type(), ssh.FingerprintSHA256(key))
+		}
+		if !prompt(hostname, key) {
+			return fmt.Errorf("host key for %s (%s %s) was not accepted",
+				hostname, key.Type(), ssh.FingerprintSHA256(key))
+		}
+
+		if err := appendKnownHost(file, hostname, key); err != nil {
+			return err
+		}
+		log.Printf("🔑 Trusted new host key for %s: %s %s (saved to %s)", hostname, key.Type(), ssh.FingerprintSHA256(key), file)
+		return nil
+	}
+}
+
+// describeHostKeyError turns a knownhosts error into a message an operator can act on
+func describeHostKeyError(hostname string, key ssh.PublicKey, err error) error {
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
+		var known []string
+		for _, want := range keyErr.Want {
+			known = append(known, fmt.Sprintf("%s %s (%s:%d)",
+				want.Key.Type(), ssh.FingerprintSHA256(want.Key), want.Filename, want.Line))
+		}
+		return fmt.Errorf("HOST KEY MISMATCH for %s: server presented %s %s but expected %s - possible man-in-the-middle attack, refusing to connect",
+			hostname, key.Type(), ssh.FingerprintSHA256(key), strings.Join(known, ", "))
+	}
+
+	var revokedErr *knownhosts.RevokedError
+	if errors.As(err, &revokedErr) {
+		return fmt.Errorf("host key for %s is revoked (%s:%d), refusing to connect",
+			hostname, revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+	}
+
+	return fmt.Errorf("host key verification failed for %s: %v", hostname, err)
+}
+
+// ensureKnownHostsFile creates an empty known_hosts file (and its directory) if missing
+func ensureKnownHostsFile(file string) error {
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("unable to create known_hosts directory: %v", err)
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to create known_hosts file %s: %v", file, err)
+	}
+	return f.Close()
+}
+
+// appendKnownHost records a host key in a known_hosts file
+func appendKnownHost(file, hostname string, key ssh.PublicKey) error {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open known_hosts file %s: %v", file, err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"); err != nil {
+		return fmt.Errorf("unable to write known_hosts file %s: %v", file, err)
+	}
+	return nil
+}
+
+// probeKey is a placeholder key used to ask a known_hosts callback which keys it has for a host
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "probe" }
+func (probeKey) Marshal() []byte                              { return []byte("probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("probe key") }
+
+// knownKeyAlgorithms returns the host key algorithms already recorded for addr,
+// so the handshake negotiates a key type that can actually be verified
+func knownKeyAlgorithms(callback ssh.HostKeyCallback, addr string) []string {
+	err := callback(addr, &net.TCPAddr{IP: net.IPv4zero}, probeKey{})
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, want := range keyErr.Want {
+		for _, algo := range hostKeyAlgorithmsFor(want.Key.Type()) {
+			if !seen[algo] {
+				seen[algo] = true
+				algorithms = append(algorithms, algo)
+			}
+		}
+	}
+	return algorithms
+}
+
+// hostKeyAlgorithmsFor maps a key type to the signature algorithms that can present it
+func hostKeyAlgorithmsFor(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	default:
+		return []string{keyType}
+	}
+}
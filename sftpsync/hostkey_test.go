@@ -0,0 +1,159 @@
+package sftpsync
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SFTPConfig
+		want   string
+	}{
+		{"nothing configured", SFTPConfig{}, HostKeyPolicyKnownHosts},
+		{"known_hosts file only", SFTPConfig{KnownHostsFile: "/tmp/kh"}, HostKeyPolicyKnownHosts},
+		{"fingerprints", SFTPConfig{HostKeyFingerprints: []string{"SHA256:abc"}}, HostKeyPolicyFingerprint},
+		{"explicit tofu", SFTPConfig{HostKeyPolicy: HostKeyPolicyTOFU}, HostKeyPolicyTOFU},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostKeyPolicy(tt.config); got != tt.want {
+				t.Errorf("hostKeyPolicy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateHostKeyPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SFTPConfig
+		wantErr bool
+	}{
+		{"default", SFTPConfig{}, false},
+		{"tofu", SFTPConfig{HostKeyPolicy: HostKeyPolicyTOFU}, false},
+		{"fingerprint without fingerprints", SFTPConfig{HostKeyPolicy: HostKeyPolicyFingerprint}, true},
+		{"unknown policy", SFTPConfig{HostKeyPolicy: "insecure"}, true},
+		{"bad jump host", SFTPConfig{JumpHosts: []SFTPConfig{{HostKeyPolicy: "nope"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateHostKeyPolicy(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateHostKeyPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFingerprintCallback(t *testing.T) {
+	key := testHostKey(t)
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+
+	tests := []struct {
+		name         string
+		fingerprints []string
+		wantErr      bool
+	}{
+		{"sha256 match", []string{ssh.FingerprintSHA256(key)}, false},
+		{"md5 match", []string{"MD5:" + ssh.FingerprintLegacyMD5(key)}, false},
+		{"padded match", []string{"  " + ssh.FingerprintSHA256(key) + " "}, false},
+		{"mismatch", []string{ssh.FingerprintSHA256(testHostKey(t))}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fingerprintCallback(tt.fingerprints)("example.com:22", remote, key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("callback error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTOFUCallback(t *testing.T) {
+	key := testHostKey(t)
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+
+	newCallback := func(file string, prompt HostKeyPrompt) ssh.HostKeyCallback {
+		t.Helper()
+		callback, _, err := newHostKeyCallback(SFTPConfig{
+			Host: "example.com", Port: 22, HostKeyPolicy: HostKeyPolicyTOFU, KnownHostsFile: file,
+		}, prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return callback
+	}
+
+	t.Run("no prompt rejects unknown keys", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "known_hosts")
+		if err := newCallback(file, nil)("example.com:22", remote, key); err == nil {
+			t.Fatal("unknown key accepted without a prompt")
+		}
+		if err := newCallback(file, func(string, ssh.PublicKey) bool { return true })("example.com:22", remote, key); err != nil {
+			t.Fatalf("key was recorded by the rejected attempt: %v", err)
+		}
+	})
+
+	t.Run("declined prompt", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "known_hosts")
+		decline := func(string, ssh.PublicKey) bool { return false }
+		if err := newCallback(file, decline)("example.com:22", remote, key); err == nil {
+			t.Fatal("declined key accepted")
+		}
+	})
+
+	t.Run("accepted key is pinned", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "known_hosts")
+		prompts := 0
+		accept := func(string, ssh.PublicKey) bool { prompts++; return true }
+
+		if err := newCallback(file, accept)("example.com:22", remote, key); err != nil {
+			t.Fatalf("first connection: %v", err)
+		}
+		if err := newCallback(file, nil)("example.com:22", remote, key); err != nil {
+			t.Fatalf("recorded key rejected: %v", err)
+		}
+		err := newCallback(file, accept)("example.com:22", remote, testHostKey(t))
+		if err == nil || !strings.Contains(err.Error(), "HOST KEY MISMATCH") {
+			t.Fatalf("changed key: got %v, want a HOST KEY MISMATCH error", err)
+		}
+		if prompts != 1 {
+			t.Errorf("prompted %d times, want 1", prompts)
+		}
+	})
+}
+
+func TestKnownHostsPolicyRejectsUnknownHosts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if err := ensureKnownHostsFile(file); err != nil {
+		t.Fatal(err)
+	}
+	callback, _, err := newHostKeyCallback(SFTPConfig{Host: "example.com", Port: 22, KnownHostsFile: file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	if err := callback("example.com:22", remote, testHostKey(t)); err == nil {
+		t.Fatal("unknown host accepted under the default policy")
+	}
+}
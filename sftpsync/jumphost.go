@@ -0,0 +1,95 @@
+package sftpsync
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// JumpHostError reports which jump host on the way to a server could not be
+// reached or refused the login
+type JumpHostError struct {
+	Hop  int // 1 for the first jump host
+	Hops int
+	Addr string
+	Err  error
+}
+
+func (e *JumpHostError) Error() string {
+	return fmt.Sprintf("jump host %d of %d (%s): %v", e.Hop, e.Hops, e.Addr, e.Err)
+}
+
+func (e *JumpHostError) Unwrap() error {
+	return e.Err
+}
+
+// dialSSH opens the SSH connection to config, tunnelling through each of its
+// jump hosts in turn like OpenSSH's ProxyJump. Only the first hop is dialled
+// directly, through the endpoint's proxy if it has one. The jump host
+// connections are returned too, as they must stay open as long as the tunnelled one.
+func (s *SFTPSync) dialSSH(config SFTPConfig) (*ssh.Client, []*ssh.Client, error) {
+	var jumps []*ssh.Client
+	var via *ssh.Client
+	dial := func(hop SFTPConfig, addr string) (net.Conn, error) {
+		if via == nil {
+			hop.Proxy, hop.ProxyPassword = config.Proxy, config.ProxyPassword
+			return dialTCP(hop, addr)
+		}
+		conn, err := via.Dial("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("previous hop could not open a tunnel: %v", err)
+		}
+		return conn, nil
+	}
+
+	for i, hop := range config.JumpHosts {
+		addr := net.JoinHostPort(hop.Host, strconv.Itoa(hop.Port))
+		client, err := s.dialHop(hop, addr, dial)
+		if err != nil {
+			closeJumpHosts(jumps)
+			return nil, nil, &JumpHostError{Hop: i + 1, Hops: len(config.JumpHosts), Addr: addr, Err: err}
+		}
+		jumps = append(jumps, client)
+		via = client
+	}
+
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	client, err := s.dialHop(config, addr, dial)
+	if err != nil {
+		closeJumpHosts(jumps)
+		if via != nil {
+			return nil, nil, fmt.Errorf("%v (through %d jump hosts)", err, len(jumps))
+		}
+		return nil, nil, err
+	}
+	return client, jumps, nil
+}
+
+// dialHop logs in to one SSH server over the connection dial opens to it
+func (s *SFTPSync) dialHop(config SFTPConfig, addr string, dial func(SFTPConfig, string) (net.Conn, error)) (*ssh.Client, error) {
+	sshConfig, releaseAuth, err := s.sshClientConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAuth()
+
+	conn, err := dial(config, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial SSH: %v", err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to dial SSH: %v", err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// closeJumpHosts closes jump host connections, innermost first
+func closeJumpHosts(jumps []*ssh.Client) {
+	for i := len(jumps) - 1; i >= 0; i-- {
+		jumps[i].Close()
+	}
+}
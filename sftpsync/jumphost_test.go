@@ -0,0 +1,134 @@
+package sftpsync
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// testJumpChain starts a target server and two jump hosts, and returns the
+// settings to reach the target through them
+func testJumpChain(t *testing.T) (config SFTPConfig, hops []*testSSHServer, target *testSSHServer) {
+	t.Helper()
+	clearProxyEnv(t)
+	target = newTestSSHServer(t)
+	hops = []*testSSHServer{newTestSSHServer(t), newTestSSHServer(t)}
+	config = target.config()
+	for _, hop := range hops {
+		config.JumpHosts = append(config.JumpHosts, hop.config())
+	}
+	return config, hops, target
+}
+
+// tunnelTargets lists where the server's tunnels led
+func tunnelTargets(tunnels []testTunnel) []string {
+	var targets []string
+	for _, tunnel := range tunnels {
+		targets = append(targets, tunnel.target)
+	}
+	return targets
+}
+
+func TestDialSSHThroughJumpHosts(t *testing.T) {
+	config, hops, target := testJumpChain(t)
+	proxy := testSOCKS5Proxy(t, "", "", 0x00)
+	config.Proxy = "socks5://" + proxy.addr
+
+	s := NewSFTPSync(config, config, SyncConfig{})
+	client, jumps, err := s.dialSSH(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jumps) != 2 {
+		t.Fatalf("got %d jump host connections, want 2", len(jumps))
+	}
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sftpClient.Getwd(); err != nil {
+		t.Errorf("SFTP through the jump hosts does not work: %v", err)
+	}
+	sftpClient.Close()
+	client.Close()
+	closeJumpHosts(jumps)
+
+	// Only the first hop goes through the proxy; the others are tunnelled
+	if got := proxy.requested(); !reflect.DeepEqual(got, []string{hops[0].addr}) {
+		t.Errorf("proxy was asked for %v, want only the first hop %s", got, hops[0].addr)
+	}
+	if got := tunnelTargets(hops[0].openedTunnels(t)); !reflect.DeepEqual(got, []string{hops[1].addr}) {
+		t.Errorf("first hop tunnelled to %v, want the second hop", got)
+	}
+	if got := tunnelTargets(hops[1].openedTunnels(t)); !reflect.DeepEqual(got, []string{target.addr}) {
+		t.Errorf("second hop tunnelled to %v, want the target", got)
+	}
+	if got := target.openSessions(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("target saw sessions %v, want one", got)
+	}
+}
+
+func TestDialSSHJumpHostErrors(t *testing.T) {
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := unreachable.Addr().String()
+	unreachable.Close()
+
+	tests := []struct {
+		name    string
+		change  func(config *SFTPConfig)
+		wantHop int    // 0 when the target itself fails
+		wantErr string // for a failing target
+	}{
+		{"first hop unreachable", func(config *SFTPConfig) {
+			host, port, _ := net.SplitHostPort(closedAddr)
+			config.JumpHosts[0].Host = host
+			config.JumpHosts[0].Port, _ = strconv.Atoi(port)
+		}, 1, ""},
+		{"second hop refuses the login", func(config *SFTPConfig) { config.JumpHosts[1].Password = "wrong" }, 2, ""},
+		{"target refuses the login", func(config *SFTPConfig) { config.Password = "wrong" }, 0, "through 2 jump hosts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, hops, _ := testJumpChain(t)
+			tt.change(&config)
+
+			s := NewSFTPSync(config, config, SyncConfig{})
+			_, _, err := s.dialSSH(config)
+			if err == nil {
+				t.Fatal("dialSSH() succeeded")
+			}
+			var hopErr *JumpHostError
+			if tt.wantHop == 0 {
+				if errors.As(err, &hopErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("dialSSH() error = %v, want a target error mentioning %q", err, tt.wantErr)
+				}
+			} else {
+				wantAddr := net.JoinHostPort(config.JumpHosts[tt.wantHop-1].Host, strconv.Itoa(config.JumpHosts[tt.wantHop-1].Port))
+				if !errors.As(err, &hopErr) || hopErr.Hop != tt.wantHop || hopErr.Hops != 2 || hopErr.Addr != wantAddr {
+					t.Fatalf("dialSSH() error = %v, want jump host %d of 2 (%s)", err, tt.wantHop, wantAddr)
+				}
+				if !strings.HasPrefix(err.Error(), "jump host ") {
+					t.Errorf("error = %q, want it to name the jump host", err)
+				}
+			}
+
+			// The hops that were reached are closed innermost first: each
+			// tunnel is closed by its client before the connection carrying it
+			for i, hop := range hops {
+				for _, tunnel := range hop.openedTunnels(t) {
+					if !tunnel.closed {
+						t.Errorf("hop %d: tunnel to %s was dropped with its connection instead of closed first", i+1, tunnel.target)
+					}
+				}
+			}
+		})
+	}
+}
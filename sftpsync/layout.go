@@ -0,0 +1,205 @@
+package sftpsync
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultDateLayout is the ddmmyyyy directory name used when no layout is configured
+const DefaultDateLayout = "02012006"
+
+// dateTokens are the placeholders accepted in token-style layouts, longest first.
+// They may be written bare (YYYY) or in braces ({YYYY}).
+var dateTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"},
+	{"YY", "06"},
+	{"MM", "01"},
+	{"DD", "02"},
+}
+
+// DateDir is one day of the sync window, named by the source and destination layouts
+type DateDir struct {
+	Date        time.Time `json:"date"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+}
+
+// isBracedLayout reports whether a layout writes its tokens in braces, as in
+// "SUMMARY_{YYYY}{MM}{DD}"; text outside the braces is then all literal
+func isBracedLayout(layout string) bool {
+	for _, t := range dateTokens {
+		if strings.Contains(layout, "{"+t.token+"}") {
+			return true
+		}
+	}
+	return false
+}
+
+// isTokenLayout reports whether a layout uses YYYY/YY/MM/DD tokens, braced or
+// bare, rather than a Go time layout
+func isTokenLayout(layout string) bool {
+	for _, t := range dateTokens {
+		if strings.Contains(layout, t.token) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenAt returns the Go layout of the date token s starts with and the
+// token's length, or a zero length when s does not start with one
+func tokenAt(s string, braced bool) (string, int) {
+	for _, t := range dateTokens {
+		token := t.token
+		if braced {
+			token = "{" + token + "}"
+		}
+		if strings.HasPrefix(s, token) {
+			return t.layout, len(token)
+		}
+	}
+	return "", 0
+}
+
+// FormatDateLayout renders the directory for date. A layout with {YYYY}, {YY},
+// {MM} or {DD} is a token template and everything outside the braces is
+// literal. Bare YYYY, YY, MM and DD work too as long as no letter touches
+// them ("KYC_YYYYMMDD"). Any other layout is a Go time layout ("2006/01/02",
+// "02-01-2006").
+func FormatDateLayout(layout string, date time.Time) string {
+	if !isTokenLayout(layout) {
+		return date.Format(layout)
+	}
+
+	braced := isBracedLayout(layout)
+	var b strings.Builder
+	for i := 0; i < len(layout); {
+		if tokenLayout, n := tokenAt(layout[i:], braced); n > 0 {
+			b.WriteString(date.Format(tokenLayout))
+			i += n
+			continue
+		}
+		b.WriteByte(layout[i])
+		i++
+	}
+	return b.String()
+}
+
+// bareTokenClash returns the first bare token in layout that touches a literal
+// letter, like the MM in "SUMMARY_YYYYMMDD", which would be replaced inside the word
+func bareTokenClash(layout string) string {
+	isLetter := func(c byte) bool { return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' }
+	afterLetter := false
+	for i := 0; i < len(layout); {
+		_, n := tokenAt(layout[i:], false)
+		if n == 0 {
+			afterLetter = isLetter(layout[i])
+			i++
+			continue
+		}
+		next := i + n
+		if afterLetter {
+			return layout[i:next]
+		}
+		if next < len(layout) && isLetter(layout[next]) {
+			if _, m := tokenAt(layout[next:], false); m == 0 {
+				return layout[i:next]
+			}
+		}
+		afterLetter = false
+		i = next
+	}
+	return ""
+}
+
+// ValidateDateLayouts checks that the source and destination layouts produce
+// usable relative paths that are different for every day
+func ValidateDateLayouts(config SyncConfig) error {
+	layouts := map[string]string{"date_layout": config.DateLayout}
+	if config.DestDateLayout != "" {
+		layouts["destination_date_layout"] = config.DestDateLayout
+	}
+
+	base := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.Local)
+	samples := []time.Time{base, base.AddDate(0, 0, 1), base.AddDate(0, 1, 0), base.AddDate(1, 0, 0)}
+
+	for name, layout := range layouts {
+		if layout == "" {
+			continue
+		}
+		if isTokenLayout(layout) && !isBracedLayout(layout) {
+			if token := bareTokenClash(layout); token != "" {
+				return fmt.Errorf("%s %q: %s runs into the letters next to it; write the tokens in braces, e.g. \"SUMMARY_{YYYY}{MM}{DD}\"", name, layout, token)
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, date := range samples {
+			dir := FormatDateLayout(layout, date)
+			if dir == "" || strings.HasPrefix(dir, "/") {
+				return fmt.Errorf("%s %q must produce a relative path", name, layout)
+			}
+			for _, elem := range strings.Split(dir, "/") {
+				if elem == "" || elem == "." || elem == ".." {
+					return fmt.Errorf("%s %q produces an invalid path %q", name, layout, dir)
+				}
+			}
+			if seen[dir] {
+				return fmt.Errorf("%s %q does not include the full date (day, month and year)", name, layout)
+			}
+			seen[dir] = true
+		}
+	}
+
+	return nil
+}
+
+// sourceDateLayout returns the configured source layout or the default
+func (s *SFTPSync) sourceDateLayout() string {
+	if s.SyncConfig.DateLayout == "" {
+		return DefaultDateLayout
+	}
+	return s.SyncConfig.DateLayout
+}
+
+// destDateLayout returns the destination layout, which defaults to the source layout
+func (s *SFTPSync) destDateLayout() string {
+	if s.SyncConfig.DestDateLayout == "" {
+		return s.sourceDateLayout()
+	}
+	return s.SyncConfig.DestDateLayout
+}
+
+// sourceDirNames returns the source-side directory of each date
+func sourceDirNames(dateDirs []DateDir) []string {
+	names := make([]string, len(dateDirs))
+	for i, d := range dateDirs {
+		names[i] = d.Source
+	}
+	return names
+}
+
+// destDirNames returns the destination-side directory of each date
+func destDirNames(dateDirs []DateDir) []string {
+	names := make([]string, len(dateDirs))
+	for i, d := range dateDirs {
+		names[i] = d.Destination
+	}
+	return names
+}
+
+// destRelativePath maps a source-relative path to where it belongs on the
+// destination by swapping its source date directory for the destination one
+func destRelativePath(dateDirs []DateDir, sourceRelative string) string {
+	for _, d := range dateDirs {
+		if sourceRelative == d.Source {
+			return d.Destination
+		}
+		if rest, ok := strings.CutPrefix(sourceRelative, d.Source+"/"); ok {
+			return path.Join(d.Destination, rest)
+		}
+	}
+	return sourceRelative
+}
@@ -0,0 +1,97 @@
+package sftpsync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDateLayout(t *testing.T) {
+	date := time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		layout string
+		want   string
+	}{
+		{DefaultDateLayout, "16102026"},
+		{"2006/01/02", "2026/10/16"},
+		{"02-01-2006", "16-10-2026"},
+		{"YYYY/MM/DD", "2026/10/16"},
+		{"YYYYMMDD", "20261016"},
+		{"DD-MM-YYYY", "16-10-2026"},
+		{"DDMMYY", "161026"},
+		{"KYC_YYYYMMDD", "KYC_20261016"},
+		{"{YYYY}/{MM}/{DD}", "2026/10/16"},
+		{"KYC_{YYYY}{MM}{DD}", "KYC_20261016"},
+		{"SUMMARY_{YYYY}{MM}{DD}", "SUMMARY_20261016"},
+		{"ADD_{DD}{MM}{YYYY}", "ADD_16102026"},
+		{"{YY}MM_{MM}", "26MM_10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			if got := FormatDateLayout(tt.layout, date); got != tt.want {
+				t.Errorf("FormatDateLayout(%q) = %q, want %q", tt.layout, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDateLayouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  string
+		wantErr bool
+	}{
+		{"default", "", false},
+		{"go layout", "02012006", false},
+		{"nested tokens", "YYYY/MM/DD", false},
+		{"compact tokens", "YYYYMMDD", false},
+		{"dashed tokens", "DD-MM-YYYY", false},
+		{"prefixed tokens", "KYC_YYYYMMDD", false},
+		{"braced tokens", "{YYYY}/{MM}/{DD}", false},
+		{"braced prefix with token letters", "SUMMARY_{YYYY}{MM}{DD}", false},
+		{"token inside a word", "SUMMARY_YYYYMMDD", true},
+		{"token after a letter", "ADD_DD-MM-YYYY", true},
+		{"token before a letter", "YYYYMMDDX", true},
+		{"token run into a letter", "KYCYYYYMMDD", true},
+		{"bare token in a braced layout", "KYC_{YYYY}MMDD", true},
+		{"lowercase is not a token", "yyyymmdd", true},
+		{"no day", "YYYY/MM", true},
+		{"absolute", "/YYYY/MM/DD", true},
+		{"empty segment", "YYYY//MM/DD", true},
+		{"parent segment", "../YYYYMMDD", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, config := range []SyncConfig{{DateLayout: tt.layout}, {DestDateLayout: tt.layout}} {
+				if err := ValidateDateLayouts(config); (err != nil) != tt.wantErr {
+					t.Errorf("ValidateDateLayouts(%+v) error = %v, wantErr %v", config, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestDestRelativePath(t *testing.T) {
+	dateDirs := []DateDir{
+		{Source: "2026/10/16", Destination: "KYC_20261016"},
+		{Source: "2026/10/15", Destination: "KYC_20261015"},
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"2026/10/16", "KYC_20261016"},
+		{"2026/10/16/a.csv", "KYC_20261016/a.csv"},
+		{"2026/10/15/sub/b.csv", "KYC_20261015/sub/b.csv"},
+		{"2026/10/160/c.csv", "2026/10/160/c.csv"},
+		{"other/d.csv", "other/d.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			if got := destRelativePath(dateDirs, tt.source); got != tt.want {
+				t.Errorf("destRelativePath(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package sftpsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ManifestEntry is the cached state of one destination file
+type ManifestEntry struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Hash     string    `json:"hash"`
+	HashedAt time.Time `json:"hashed_at"`
+}
+
+// matches reports whether the entry still describes file, so its hash can be reused.
+// SFTP only carries whole seconds, so times are compared at that resolution.
+func (e ManifestEntry) matches(file *FileInfo) bool {
+	return e.Hash != "" && e.Size == file.Size && e.ModTime.Unix() == file.ModTime.Unix()
+}
+
+// Manifest is a local bbolt database caching destination file hashes, keyed by
+// destination path. Each destination server and root gets its own bucket so one
+// file can serve several configurations.
+type Manifest struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// DefaultManifestFile returns the manifest used when sync.manifest_file is not set
+func DefaultManifestFile() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine user config directory: %v", err)
+	}
+	return filepath.Join(configDir, "oneclick-kra-sftp-sync", "manifest.db"), nil
+}
+
+// OpenManifest opens (creating if needed) the manifest file and selects the bucket
+func OpenManifest(file, bucket string) (*Manifest, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create manifest directory: %v", err)
+	}
+
+	// Another run holding the file lock should not hang this one forever
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest %s: %v", file, err)
+	}
+
+	m := &Manifest{db: db, bucket: []byte(bucket)}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(m.bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise manifest %s: %v", file, err)
+	}
+
+	return m, nil
+}
+
+// Close releases the manifest file
+func (m *Manifest) Close() error {
+	return m.db.Close()
+}
+
+// Entries returns all cached entries whose path is under one of the given prefixes
+func (m *Manifest) Entries(prefixes []string) (map[string]ManifestEntry, error) {
+	entries := make(map[string]ManifestEntry)
+
+	err := m.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(m.bucket).Cursor()
+		for _, prefix := range prefixes {
+			p := []byte(strings.TrimSuffix(prefix, "/") + "/")
+			for k, v := c.Seek(p); k != nil && strings.HasPrefix(string(k), string(p)); k, v = c.Next() {
+				var entry ManifestEntry
+				if err := json.Unmarshal(v, &entry); err != nil {
+					continue
+				}
+				entries[string(k)] = entry
+			}
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// Put stores or replaces entries
+func (m *Manifest) Put(entries map[string]ManifestEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return m.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(m.bucket)
+		for filePath, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(filePath), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes entries; missing paths are ignored
+func (m *Manifest) Delete(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	return m.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(m.bucket)
+		for _, filePath := range paths {
+			if err := b.Delete([]byte(filePath)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// manifestBucket names the bucket for the configured destination server and root
+func (s *SFTPSync) manifestBucket() string {
+	return fmt.Sprintf("%s@%s:%d%s", s.DestinationConfig.Username, s.DestinationConfig.Host, s.DestinationConfig.Port, s.SyncConfig.DestinationPath)
+}
+
+// openManifest opens the configured manifest once per SFTPSync. Failing to open
+// it is not fatal: destination files are simply all hashed again.
+func (s *SFTPSync) openManifest() *Manifest {
+	if s.manifest != nil {
+		return s.manifest
+	}
+
+	file := s.SyncConfig.ManifestFile
+	if file == "" {
+		var err error
+		if file, err = DefaultManifestFile(); err != nil {
+			log.Printf("Warning: Manifest disabled: %v", err)
+			return nil
+		}
+	}
+
+	m, err := OpenManifest(file, s.manifestBucket())
+	if err != nil {
+		log.Printf("Warning: Manifest disabled, every destination file will be hashed: %v", err)
+		return nil
+	}
+
+	s.manifest = m
+	return m
+}
+
+// hashDestinationWithContext fills in Hash for the given destination graph files,
+// reusing manifest hashes for files whose size and modification time are unchanged
+// and hashing only new or changed files. Entries for files that have disappeared
+// from fully scanned directories are pruned.
+func (s *SFTPSync) hashDestinationWithContext(ctx context.Context, graph *DirectoryGraph, dateDirs []DateDir, files []*FileInfo) error {
+	m := s.openManifest()
+	cached := map[string]ManifestEntry{}
+	if m != nil {
+		var err error
+		if cached, err = m.Entries(s.manifestPrefixes(dateDirs)); err != nil {
+			log.Printf("Warning: Failed to read manifest: %v", err)
+			cached = map[string]ManifestEntry{}
+		}
+	}
+
+	var toHash []*FileInfo
+	for _, file := range files {
+		if entry, ok := cached[file.Path]; ok && entry.matches(file) {
+			file.Hash = entry.Hash
+			continue
+		}
+		toHash = append(toHash, file)
+	}
+
+	if len(toHash) > 0 {
+		client, err := s.dest.client()
+		if err != nil {
+			return err
+		}
+		if err := s.hashFilesWithContext(ctx, client, "destination", toHash); err != nil {
+			return err
+		}
+	}
+	log.Printf("🧮 Destination hashes: %d from manifest, %d hashed", len(files)-len(toHash), len(toHash))
+
+	if m == nil {
+		return nil
+	}
+
+	now := time.Now()
+	updates := make(map[string]ManifestEntry, len(toHash))
+	for _, file := range toHash {
+		if file.Hash != "" {
+			updates[file.Path] = ManifestEntry{Size: file.Size, ModTime: file.ModTime, Hash: file.Hash, HashedAt: now}
+		}
+	}
+	if err := m.Put(updates); err != nil {
+		log.Printf("Warning: Failed to update manifest: %v", err)
+	}
+
+	var stale []string
+	for filePath := range cached {
+		if _, exists := graph.Files[filePath]; !exists && !graph.scanFailedAbove(filePath) {
+			stale = append(stale, filePath)
+		}
+	}
+	if err := m.Delete(stale); err != nil {
+		log.Printf("Warning: Failed to prune manifest: %v", err)
+	}
+
+	return nil
+}
+
+// hashFilesWithContext hashes files on one side concurrently, setting Hash on each.
+// Files that cannot be read are logged and left without a hash.
+func (s *SFTPSync) hashFilesWithContext(ctx context.Context, client *sftp.Client, side string, files []*FileInfo) error {
+	if len(files) == 0 {
+		return nil
+	}
+	log.Printf("Hashing %d %s files...", len(files), side)
+
+	workers := s.SyncConfig.MaxConcurrentTransfers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	fileChan := make(chan *FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range fileChan {
+				hash, err := s.calculateRemoteFileHash(client, file.Path)
+				if err != nil {
+					log.Printf("Warning: Failed to calculate hash for %s: %v", file.Path, err)
+					continue
+				}
+				file.Hash = hash
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for _, file := range files {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		case fileChan <- file:
+		}
+	}
+	close(fileChan)
+	wg.Wait()
+
+	return err
+}
+
+// recordTransfer updates the manifest after a file has been renamed into place.
+// Without a verified hash the entry is dropped so the next scan hashes the file.
+func (s *SFTPSync) recordTransfer(destPath string, size int64, modTime time.Time, hash string) {
+	if s.manifest == nil {
+		return
+	}
+
+	var err error
+	if hash == "" {
+		err = s.manifest.Delete([]string{destPath})
+	} else {
+		err = s.manifest.Put(map[string]ManifestEntry{
+			destPath: {Size: size, ModTime: modTime, Hash: hash, HashedAt: time.Now()},
+		})
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to update manifest for %s: %v", destPath, err)
+	}
+}
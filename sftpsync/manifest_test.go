@@ -0,0 +1,167 @@
+package sftpsync
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func testManifest(t *testing.T, file, bucket string) *Manifest {
+	t.Helper()
+	m, err := OpenManifest(file, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestManifestEntries(t *testing.T) {
+	m := testManifest(t, filepath.Join(t.TempDir(), "manifest.db"), "kra@example.com:22/data")
+	entry := ManifestEntry{Size: 1, Hash: "abc"}
+	err := m.Put(map[string]ManifestEntry{
+		"/data/16102026/a.csv":     entry,
+		"/data/16102026/sub/b.csv": entry,
+		"/data/160920260/c.csv":    entry,
+		"/data/15102026/d.csv":     entry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		prefixes []string
+		want     []string
+	}{
+		{"one date directory", []string{"/data/16102026"}, []string{"/data/16102026/a.csv", "/data/16102026/sub/b.csv"}},
+		{"trailing slash", []string{"/data/16102026/"}, []string{"/data/16102026/a.csv", "/data/16102026/sub/b.csv"}},
+		{"several directories", []string{"/data/15102026", "/data/160920260"}, []string{"/data/15102026/d.csv", "/data/160920260/c.csv"}},
+		{"nothing cached", []string{"/data/01012026"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := m.Entries(tt.prefixes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for filePath := range entries {
+				got = append(got, filePath)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Entries(%v) = %v, want %v", tt.prefixes, got, tt.want)
+			}
+		})
+	}
+
+	if err := m.Delete([]string{"/data/16102026/a.csv", "/data/missing.csv"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := m.Entries([]string{"/data/16102026"})
+	if _, ok := entries["/data/16102026/a.csv"]; ok || len(entries) != 1 {
+		t.Errorf("after Delete: %v", entries)
+	}
+}
+
+func TestManifestBuckets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "manifest.db")
+	first := testManifest(t, file, "kra@one:22/data")
+	if err := first.Put(map[string]ManifestEntry{"/data/16102026/a.csv": {Hash: "abc"}}); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	second := testManifest(t, file, "kra@two:22/data")
+	entries, err := second.Entries([]string{"/data/16102026"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("another destination's bucket leaked entries: %v", entries)
+	}
+}
+
+func TestManifestEntryMatches(t *testing.T) {
+	modTime := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	entry := ManifestEntry{Size: 10, ModTime: modTime, Hash: "abc"}
+
+	tests := []struct {
+		name  string
+		entry ManifestEntry
+		file  FileInfo
+		want  bool
+	}{
+		{"unchanged", entry, FileInfo{Size: 10, ModTime: modTime}, true},
+		{"sub-second difference", entry, FileInfo{Size: 10, ModTime: modTime.Add(500 * time.Millisecond)}, true},
+		{"size changed", entry, FileInfo{Size: 11, ModTime: modTime}, false},
+		{"touched", entry, FileInfo{Size: 10, ModTime: modTime.Add(time.Second)}, false},
+		{"never hashed", ManifestEntry{Size: 10, ModTime: modTime}, FileInfo{Size: 10, ModTime: modTime}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.matches(&tt.file); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashDestinationUsesManifest(t *testing.T) {
+	client := testSFTPClient(t)
+	client.MkdirAll("/dst/16102026")
+	client.MkdirAll("/dst/15102026")
+	writeRemote(t, client, "/dst/16102026/a.csv", "1,100\n")
+
+	s := testCompareSync(t, CompareChecksum, 0)
+	s.dest = testPool(client, "destination")
+	dateDirs := []DateDir{{Destination: "16102026"}, {Destination: "15102026"}}
+	modTime := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+	hash := func(graph *DirectoryGraph) *FileInfo {
+		t.Helper()
+		file := &FileInfo{Path: "/dst/16102026/a.csv", RelativePath: "16102026/a.csv", Size: 6, ModTime: modTime}
+		graph.AddFile(file)
+		if err := s.hashDestinationWithContext(context.Background(), graph, dateDirs, []*FileInfo{file}); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	if file := hash(NewDirectoryGraph("/dst")); file.Hash != md5Hex("1,100\n") {
+		t.Fatalf("first run hash = %q, want the file's MD5", file.Hash)
+	}
+
+	// Same size and time: the cached hash is used without reading the file
+	writeRemote(t, client, "/dst/16102026/a.csv", "1,999\n")
+	if file := hash(NewDirectoryGraph("/dst")); file.Hash != md5Hex("1,100\n") {
+		t.Fatalf("second run hash = %q, want the cached one", file.Hash)
+	}
+
+	// Entries of files gone from the destination are pruned, unless their
+	// directory could not be scanned
+	m := s.openManifest()
+	stale := ManifestEntry{Size: 1, Hash: "abc"}
+	if err := m.Put(map[string]ManifestEntry{"/dst/16102026/gone.csv": stale, "/dst/15102026/unreadable/b.csv": stale}); err != nil {
+		t.Fatal(err)
+	}
+	graph := NewDirectoryGraph("/dst")
+	graph.AddScanError("/dst/15102026/unreadable", errors.New("permission denied"))
+	hash(graph)
+
+	entries, err := m.Entries(s.manifestPrefixes(dateDirs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entries["/dst/16102026/gone.csv"]; ok {
+		t.Error("entry of a deleted file was kept")
+	}
+	if _, ok := entries["/dst/15102026/unreadable/b.csv"]; !ok {
+		t.Error("entry under a failed scan was pruned")
+	}
+}
@@ -0,0 +1,196 @@
+package sftpsync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestReport summarises a manifest rebuild or verification
+type ManifestReport struct {
+	Files     int      `json:"files"`
+	Hashed    int      `json:"hashed"`
+	Matched   int      `json:"matched"`
+	Corrupt   []string `json:"corrupt,omitempty"`
+	Changed   []string `json:"changed,omitempty"`
+	Untracked []string `json:"untracked,omitempty"`
+	Orphaned  []string `json:"orphaned,omitempty"`
+}
+
+// OK reports whether verification found no file whose content changed behind
+// an unchanged size and modification time
+func (r *ManifestReport) OK() bool {
+	return len(r.Corrupt) == 0
+}
+
+// Print logs the report
+func (r *ManifestReport) Print() {
+	log.Println(strings.Repeat("=", 60))
+	log.Println("🧮 MANIFEST")
+	log.Println(strings.Repeat("=", 60))
+
+	for _, p := range r.Corrupt {
+		log.Printf("   %-10s %s", "corrupt", p)
+	}
+	for _, p := range r.Changed {
+		log.Printf("   %-10s %s", "changed", p)
+	}
+	for _, p := range r.Untracked {
+		log.Printf("   %-10s %s", "untracked", p)
+	}
+	for _, p := range r.Orphaned {
+		log.Printf("   %-10s %s", "orphaned", p)
+	}
+
+	log.Printf("📁 Destination files: %d, hashed: %d", r.Files, r.Hashed)
+	log.Printf("✅ Matching manifest: %d", r.Matched)
+	if len(r.Changed)+len(r.Untracked)+len(r.Orphaned) > 0 {
+		log.Printf("🔄 Out of date (refreshed by the next sync): %d changed, %d untracked, %d orphaned", len(r.Changed), len(r.Untracked), len(r.Orphaned))
+	}
+	if !r.OK() {
+		log.Printf("❌ Content differs with unchanged size and time: %d", len(r.Corrupt))
+	}
+	log.Println(strings.Repeat("=", 60))
+}
+
+// RebuildManifestWithContext discards the manifest entries for the sync window and
+// re-hashes every destination file in it. Only the destination is contacted.
+func (s *SFTPSync) RebuildManifestWithContext(ctx context.Context) (*ManifestReport, error) {
+	graph, dateDirs, err := s.hashWholeDestination(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	m := s.openManifest()
+	if m == nil {
+		return nil, fmt.Errorf("manifest is not available")
+	}
+
+	old, err := m.Entries(s.manifestPrefixes(dateDirs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	stale := make([]string, 0, len(old))
+	for filePath := range old {
+		stale = append(stale, filePath)
+	}
+	if err := m.Delete(stale); err != nil {
+		return nil, fmt.Errorf("failed to clear manifest: %w", err)
+	}
+
+	report := &ManifestReport{Files: len(graph.Files)}
+	now := time.Now()
+	entries := make(map[string]ManifestEntry, len(graph.Files))
+	for _, file := range graph.Files {
+		if file.Hash == "" {
+			continue
+		}
+		entries[file.Path] = ManifestEntry{Size: file.Size, ModTime: file.ModTime, Hash: file.Hash, HashedAt: now}
+		report.Hashed++
+	}
+	if err := m.Put(entries); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	log.Printf("Manifest rebuilt: %d entries (%d previous entries replaced)", len(entries), len(old))
+	return report, nil
+}
+
+// VerifyManifestWithContext re-hashes every destination file in the sync window and
+// compares the results with the manifest without modifying it
+func (s *SFTPSync) VerifyManifestWithContext(ctx context.Context) (*ManifestReport, error) {
+	graph, dateDirs, err := s.hashWholeDestination(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	m := s.openManifest()
+	if m == nil {
+		return nil, fmt.Errorf("manifest is not available")
+	}
+
+	entries, err := m.Entries(s.manifestPrefixes(dateDirs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	report := &ManifestReport{Files: len(graph.Files)}
+	for _, file := range graph.Files {
+		if file.Hash != "" {
+			report.Hashed++
+		}
+
+		entry, ok := entries[file.Path]
+		switch {
+		case !ok:
+			report.Untracked = append(report.Untracked, file.RelativePath)
+		case !entry.matches(file):
+			report.Changed = append(report.Changed, file.RelativePath)
+		case file.Hash != "" && file.Hash != entry.Hash:
+			report.Corrupt = append(report.Corrupt, file.RelativePath)
+		default:
+			report.Matched++
+		}
+	}
+	for filePath := range entries {
+		if _, exists := graph.Files[filePath]; !exists && !graph.scanFailedAbove(filePath) {
+			report.Orphaned = append(report.Orphaned, relativeTo(s.SyncConfig.DestinationPath, filePath))
+		}
+	}
+
+	sort.Strings(report.Corrupt)
+	sort.Strings(report.Changed)
+	sort.Strings(report.Untracked)
+	sort.Strings(report.Orphaned)
+
+	return report, nil
+}
+
+// hashWholeDestination connects to the destination only, scans the sync window
+// and hashes every file in it. The caller must Close the SFTPSync on success.
+func (s *SFTPSync) hashWholeDestination(ctx context.Context) (*DirectoryGraph, []DateDir, error) {
+	var err error
+	s.dest, err = newSessionPool(s, "destination", s.DestinationConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to destination SFTP: %v", err)
+	}
+	log.Println("Connected to destination SFTP server")
+
+	client, err := s.dest.client()
+	if err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+	dateDirs := s.dateWindow()
+	graph, err := s.buildDirectoryGraphWithContext(ctx, "destination", client, s.SyncConfig.DestinationPath, destDirNames(dateDirs))
+	if err != nil {
+		s.Close()
+		return nil, nil, fmt.Errorf("failed to build destination graph: %w", err)
+	}
+
+	files := make([]*FileInfo, 0, len(graph.Files))
+	for _, file := range graph.Files {
+		files = append(files, file)
+	}
+	if err := s.hashFilesWithContext(ctx, client, "destination", files); err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+
+	return graph, dateDirs, nil
+}
+
+// manifestPrefixes returns the destination date directories the manifest is consulted for
+func (s *SFTPSync) manifestPrefixes(dateDirs []DateDir) []string {
+	prefixes := make([]string, len(dateDirs))
+	for i, dir := range dateDirs {
+		prefixes[i] = path.Join(s.SyncConfig.DestinationPath, dir.Destination)
+	}
+	return prefixes
+}
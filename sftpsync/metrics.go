@@ -0,0 +1,271 @@
+package sftpsync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runDurationBuckets are the upper bounds, in seconds, of the run duration histogram
+var runDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1800, 3600, 7200, 14400}
+
+// Metrics collects Prometheus metrics from the events of every run it observes
+// and renders them in the text exposition format. It is safe for concurrent use.
+type Metrics struct {
+	mutex sync.Mutex
+
+	filesTransferred int64
+	filesSkipped     int64
+	filesFailed      int64
+	filesDeleted     int64
+	bytesTransferred int64
+	inFlight         int64
+
+	runs        map[[2]string]int64 // trigger, result
+	lastSuccess map[string]time.Time
+	lastRun     map[string]time.Time
+
+	durationCounts []int64 // per bucket, not cumulative; the last counts +Inf
+	durationSum    float64
+	durationCount  int64
+
+	scannedFiles     map[string]int64 // per side
+	scannedDirs      map[string]int64
+	connectionErrors map[string]int64
+}
+
+// NewMetrics returns an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		runs:             make(map[[2]string]int64),
+		lastSuccess:      make(map[string]time.Time),
+		lastRun:          make(map[string]time.Time),
+		durationCounts:   make([]int64, len(runDurationBuckets)+1),
+		scannedFiles:     make(map[string]int64),
+		scannedDirs:      make(map[string]int64),
+		connectionErrors: make(map[string]int64),
+	}
+}
+
+// Observe records the runs of s from now on, labelled with its Trigger, which
+// must be set beforehand. It returns a function that stops observing.
+func (m *Metrics) Observe(s *SFTPSync) (unsubscribe func()) {
+	trigger := s.Trigger
+	if trigger == "" {
+		trigger = "manual"
+	}
+	inFlight := int64(0) // this sync's share of m.inFlight
+
+	return s.Subscribe(func(e Event) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		switch e.Type {
+		case EventDirectoryScanned:
+			m.scannedFiles[e.Side] += int64(e.Files)
+			m.scannedDirs[e.Side] += int64(e.Dirs)
+		case EventPhaseFinished:
+			if e.Phase == PhaseConnecting && e.Side != "" {
+				m.connectionErrors[e.Side]++
+			}
+		case EventConnectionLost:
+			m.connectionErrors[e.Side]++
+		case EventFileStarted:
+			inFlight++
+			m.inFlight++
+		case EventFileDone:
+			inFlight--
+			m.inFlight--
+			m.filesTransferred++
+			m.bytesTransferred += e.Size
+		case EventFileFailed:
+			inFlight--
+			m.inFlight--
+			m.filesFailed++
+		case EventFileDeleted:
+			m.filesDeleted++
+		case EventRunSummary:
+			// Transfers abandoned on cancellation never report back
+			m.inFlight -= inFlight
+			inFlight = 0
+
+			if e.Summary != nil {
+				m.filesSkipped += int64(e.Summary.SkippedFiles)
+			}
+			m.runs[[2]string{trigger, e.Result}]++
+			m.lastRun[trigger] = e.Time
+			if e.Result == ResultSuccess {
+				m.lastSuccess[trigger] = e.Time
+			}
+
+			seconds := e.Duration.Seconds()
+			bucket := sort.SearchFloat64s(runDurationBuckets, seconds)
+			m.durationCounts[bucket]++
+			m.durationSum += seconds
+			m.durationCount++
+		}
+	})
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	out := &countingWriter{w: bufio.NewWriter(w)}
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	value := func(name string, v float64, labels ...string) {
+		fmt.Fprintf(out, "%s%s %s\n", name, formatLabels(labels...), strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	perLabel := func(name, label string, values map[string]int64) {
+		for _, key := range sortedKeys(values) {
+			value(name, float64(values[key]), label, key)
+		}
+	}
+
+	metric("sftpsync_files_transferred_total", "counter", "Files transferred in full.")
+	value("sftpsync_files_transferred_total", float64(m.filesTransferred))
+	metric("sftpsync_files_skipped_total", "counter", "Files found already up to date.")
+	value("sftpsync_files_skipped_total", float64(m.filesSkipped))
+	metric("sftpsync_files_failed_total", "counter", "Files that could not be transferred after all retries.")
+	value("sftpsync_files_failed_total", float64(m.filesFailed))
+	metric("sftpsync_files_deleted_total", "counter", "Destination files deleted in mirror mode.")
+	value("sftpsync_files_deleted_total", float64(m.filesDeleted))
+	metric("sftpsync_bytes_transferred_total", "counter", "Bytes of files transferred in full.")
+	value("sftpsync_bytes_transferred_total", float64(m.bytesTransferred))
+	metric("sftpsync_transfers_in_flight", "gauge", "Files being transferred right now.")
+	value("sftpsync_transfers_in_flight", float64(m.inFlight))
+
+	metric("sftpsync_runs_total", "counter", "Finished runs by trigger and result.")
+	runKeys := make([][2]string, 0, len(m.runs))
+	for key := range m.runs {
+		runKeys = append(runKeys, key)
+	}
+	sort.Slice(runKeys, func(i, j int) bool {
+		if runKeys[i][0] != runKeys[j][0] {
+			return runKeys[i][0] < runKeys[j][0]
+		}
+		return runKeys[i][1] < runKeys[j][1]
+	})
+	for _, key := range runKeys {
+		value("sftpsync_runs_total", float64(m.runs[key]), "trigger", key[0], "result", key[1])
+	}
+
+	metric("sftpsync_last_run_timestamp_seconds", "gauge", "Unix time the last run finished, by trigger.")
+	for _, trigger := range sortedKeys(m.lastRun) {
+		value("sftpsync_last_run_timestamp_seconds", float64(m.lastRun[trigger].Unix()), "trigger", trigger)
+	}
+	metric("sftpsync_last_success_timestamp_seconds", "gauge", "Unix time the last fully successful run finished, by trigger.")
+	for _, trigger := range sortedKeys(m.lastSuccess) {
+		value("sftpsync_last_success_timestamp_seconds", float64(m.lastSuccess[trigger].Unix()), "trigger", trigger)
+	}
+
+	metric("sftpsync_run_duration_seconds", "histogram", "Duration of finished runs.")
+	cumulative := int64(0)
+	for i, bound := range runDurationBuckets {
+		cumulative += m.durationCounts[i]
+		value("sftpsync_run_duration_seconds_bucket", float64(cumulative), "le", fmt.Sprint(bound))
+	}
+	value("sftpsync_run_duration_seconds_bucket", float64(m.durationCount), "le", "+Inf")
+	value("sftpsync_run_duration_seconds_sum", m.durationSum)
+	value("sftpsync_run_duration_seconds_count", float64(m.durationCount))
+
+	metric("sftpsync_scanned_files_total", "counter", "Files found while scanning date directories, by side.")
+	perLabel("sftpsync_scanned_files_total", "side", m.scannedFiles)
+	metric("sftpsync_scanned_dirs_total", "counter", "Directories read while scanning date directories, by side.")
+	perLabel("sftpsync_scanned_dirs_total", "side", m.scannedDirs)
+	metric("sftpsync_connection_errors_total", "counter", "Failed connection attempts and connections lost mid-run, by endpoint.")
+	perLabel("sftpsync_connection_errors_total", "endpoint", m.connectionErrors)
+
+	if out.err == nil {
+		out.err = out.w.Flush()
+	}
+	return out.n, out.err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scrape
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(rw)
+}
+
+// WriteFile writes the metrics for the node_exporter textfile collector. The
+// file is replaced atomically so the collector never reads it half-written.
+func (m *Metrics) WriteFile(path string) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := m.WriteTo(temp); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
+
+// formatLabels renders name/value pairs as a Prometheus label set
+func formatLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		fmt.Fprintf(&b, `%s="%s"`, pairs[i], escaped)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter remembers the bytes written and the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
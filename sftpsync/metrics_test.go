@@ -0,0 +1,164 @@
+package sftpsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const wantMetrics = `# HELP sftpsync_files_transferred_total Files transferred in full.
+# TYPE sftpsync_files_transferred_total counter
+sftpsync_files_transferred_total 1
+# HELP sftpsync_files_skipped_total Files found already up to date.
+# TYPE sftpsync_files_skipped_total counter
+sftpsync_files_skipped_total 2
+# HELP sftpsync_files_failed_total Files that could not be transferred after all retries.
+# TYPE sftpsync_files_failed_total counter
+sftpsync_files_failed_total 1
+# HELP sftpsync_files_deleted_total Destination files deleted in mirror mode.
+# TYPE sftpsync_files_deleted_total counter
+sftpsync_files_deleted_total 1
+# HELP sftpsync_bytes_transferred_total Bytes of files transferred in full.
+# TYPE sftpsync_bytes_transferred_total counter
+sftpsync_bytes_transferred_total 100
+# HELP sftpsync_transfers_in_flight Files being transferred right now.
+# TYPE sftpsync_transfers_in_flight gauge
+sftpsync_transfers_in_flight 0
+# HELP sftpsync_runs_total Finished runs by trigger and result.
+# TYPE sftpsync_runs_total counter
+sftpsync_runs_total{trigger="cli",result="partial"} 1
+sftpsync_runs_total{trigger="schedule:nightly",result="success"} 1
+# HELP sftpsync_last_run_timestamp_seconds Unix time the last run finished, by trigger.
+# TYPE sftpsync_last_run_timestamp_seconds gauge
+sftpsync_last_run_timestamp_seconds{trigger="cli"} 1792000000
+sftpsync_last_run_timestamp_seconds{trigger="schedule:nightly"} 1792003600
+# HELP sftpsync_last_success_timestamp_seconds Unix time the last fully successful run finished, by trigger.
+# TYPE sftpsync_last_success_timestamp_seconds gauge
+sftpsync_last_success_timestamp_seconds{trigger="schedule:nightly"} 1792003600
+# HELP sftpsync_run_duration_seconds Duration of finished runs.
+# TYPE sftpsync_run_duration_seconds histogram
+sftpsync_run_duration_seconds_bucket{le="10"} 0
+sftpsync_run_duration_seconds_bucket{le="30"} 0
+sftpsync_run_duration_seconds_bucket{le="60"} 1
+sftpsync_run_duration_seconds_bucket{le="120"} 1
+sftpsync_run_duration_seconds_bucket{le="300"} 1
+sftpsync_run_duration_seconds_bucket{le="600"} 1
+sftpsync_run_duration_seconds_bucket{le="1800"} 1
+sftpsync_run_duration_seconds_bucket{le="3600"} 1
+sftpsync_run_duration_seconds_bucket{le="7200"} 2
+sftpsync_run_duration_seconds_bucket{le="14400"} 2
+sftpsync_run_duration_seconds_bucket{le="+Inf"} 2
+sftpsync_run_duration_seconds_sum 7245
+sftpsync_run_duration_seconds_count 2
+# HELP sftpsync_scanned_files_total Files found while scanning date directories, by side.
+# TYPE sftpsync_scanned_files_total counter
+sftpsync_scanned_files_total{side="destination"} 1
+sftpsync_scanned_files_total{side="source"} 3
+# HELP sftpsync_scanned_dirs_total Directories read while scanning date directories, by side.
+# TYPE sftpsync_scanned_dirs_total counter
+sftpsync_scanned_dirs_total{side="destination"} 1
+sftpsync_scanned_dirs_total{side="source"} 2
+# HELP sftpsync_connection_errors_total Failed connection attempts and connections lost mid-run, by endpoint.
+# TYPE sftpsync_connection_errors_total counter
+sftpsync_connection_errors_total{endpoint="destination"} 1
+sftpsync_connection_errors_total{endpoint="source"} 1
+`
+
+// testMetrics observes a partial run from the command line, which leaves a
+// transfer in flight when it ends, and a successful scheduled run
+func testMetrics(t *testing.T) *Metrics {
+	t.Helper()
+	metrics := NewMetrics()
+
+	cli := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{})
+	cli.Trigger = "cli"
+	unsubscribe := metrics.Observe(cli)
+	for _, e := range []Event{
+		{Type: EventPhaseFinished, Phase: PhaseConnecting, Side: "destination", Error: "connection refused"},
+		{Type: EventPhaseFinished, Phase: PhaseConnecting},
+		{Type: EventDirectoryScanned, Side: "source", Files: 3, Dirs: 2},
+		{Type: EventDirectoryScanned, Side: "destination", Files: 1, Dirs: 1},
+		{Type: EventFileStarted, Path: "a.csv"},
+		{Type: EventFileStarted, Path: "b.csv"},
+		{Type: EventFileStarted, Path: "c.csv"},
+		{Type: EventFileDone, Path: "a.csv", Size: 100},
+		{Type: EventConnectionLost, Side: "source"},
+		{Type: EventFileFailed, Path: "b.csv", Size: 50},
+		{Type: EventFileDeleted, Path: "old.csv", Size: 10},
+		{Type: EventRunSummary, Time: time.Unix(1792000000, 0), Result: ResultPartial, Duration: 45 * time.Second, Summary: &StatsSnapshot{SkippedFiles: 2}},
+	} {
+		cli.emit(e)
+	}
+	unsubscribe()
+
+	scheduled := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{})
+	scheduled.Trigger = "schedule:nightly"
+	defer metrics.Observe(scheduled)()
+	scheduled.emit(Event{Type: EventRunSummary, Time: time.Unix(1792003600, 0), Result: ResultSuccess, Duration: 2 * time.Hour})
+
+	// Events after unsubscribing are not counted
+	cli.emit(Event{Type: EventFileDone, Path: "late.csv", Size: 1})
+	return metrics
+}
+
+func TestMetricsWriteTo(t *testing.T) {
+	var out strings.Builder
+	n, err := testMetrics(t).WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != wantMetrics {
+		t.Errorf("metrics =\n%s\nwant\n%s", out.String(), wantMetrics)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("WriteTo() = %d bytes, wrote %d", n, out.Len())
+	}
+}
+
+func TestMetricsWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sftpsync.prom")
+	if err := os.WriteFile(path, []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testMetrics(t).WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != wantMetrics {
+		t.Errorf("metrics file =\n%s\nwant\n%s", data, wantMetrics)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("metrics file mode = %v (%v), want 0644", info.Mode().Perm(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d files, want the temp file gone", len(entries))
+	}
+
+	if err := NewMetrics().WriteFile(filepath.Join(dir, "missing", "sftpsync.prom")); err == nil {
+		t.Error("WriteFile() into a missing directory succeeded")
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		pairs []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"side", "source"}, `{side="source"}`},
+		{[]string{"trigger", "cli", "result", "success"}, `{trigger="cli",result="success"}`},
+		{[]string{"trigger", "schedule:\"odd\\job\"\n"}, `{trigger="schedule:\"odd\\job\"\n"}`},
+	}
+	for _, tt := range tests {
+		if got := formatLabels(tt.pairs...); got != tt.want {
+			t.Errorf("formatLabels(%q) = %s, want %s", tt.pairs, got, tt.want)
+		}
+	}
+}
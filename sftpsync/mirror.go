@@ -0,0 +1,174 @@
+package sftpsync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxDeletionPercent caps mirror deletions when neither max_deletions
+// nor max_deletion_percent is set, so an empty source listing cannot wipe the
+// destination. A negative limit turns a check off.
+const DefaultMaxDeletionPercent = 10.0
+
+// planDeletions adds destination files and directories that no longer exist at
+// source to the plan. Date directories whose source scan failed are left alone,
+// since a missing listing would otherwise look like a mass deletion.
+func (s *SFTPSync) planDeletions(plan *SyncPlan, sourceGraph, destGraph *DirectoryGraph) {
+	protected := make(map[string]bool)
+	for _, dateDir := range plan.DateDirs {
+		if sourceGraph.HasScanErrorsUnder(path.Join(s.SyncConfig.SourcePath, dateDir.Source)) {
+			protected[dateDir.Destination] = true
+			plan.ProtectedDateDirs = append(plan.ProtectedDateDirs, dateDir.Destination)
+		}
+	}
+
+	// dateDirOf returns the destination date directory a destination-relative path belongs to
+	dateDirOf := func(relativePath string) string {
+		for _, dateDir := range plan.DateDirs {
+			if relativePath == dateDir.Destination || strings.HasPrefix(relativePath, dateDir.Destination+"/") {
+				return dateDir.Destination
+			}
+		}
+		return ""
+	}
+
+	sourceGraph.mutex.RLock()
+	destGraph.mutex.RLock()
+	defer sourceGraph.mutex.RUnlock()
+	defer destGraph.mutex.RUnlock()
+
+	// Source paths keyed by where they would live on the destination
+	sourceFiles := make(map[string]bool, len(sourceGraph.Files))
+	for _, f := range sourceGraph.Files {
+		sourceFiles[destRelativePath(plan.DateDirs, f.RelativePath)] = true
+	}
+	sourceDirs := make(map[string]bool, len(sourceGraph.Dirs))
+	for dir := range sourceGraph.Dirs {
+		sourceDirs[destRelativePath(plan.DateDirs, relativeTo(s.SyncConfig.SourcePath, dir))] = true
+	}
+
+	for _, destFile := range destGraph.Files {
+		dateDir := dateDirOf(destFile.RelativePath)
+		if dateDir == "" || protected[dateDir] || sourceFiles[destFile.RelativePath] {
+			continue
+		}
+		plan.Deletions = append(plan.Deletions, PlannedDeletion{
+			Path:         destFile.Path,
+			RelativePath: destFile.RelativePath,
+			Size:         destFile.Size,
+		})
+	}
+
+	for dir := range destGraph.Dirs {
+		relativePath := relativeTo(s.SyncConfig.DestinationPath, dir)
+		dateDir := dateDirOf(relativePath)
+		if dateDir == "" || protected[dateDir] || sourceDirs[relativePath] {
+			continue
+		}
+		plan.DeleteDirs = append(plan.DeleteDirs, dir)
+	}
+
+	sort.Slice(plan.Deletions, func(i, j int) bool {
+		return plan.Deletions[i].RelativePath < plan.Deletions[j].RelativePath
+	})
+	// Deepest directories first so parents are empty by the time we reach them
+	sort.Slice(plan.DeleteDirs, func(i, j int) bool {
+		return strings.Count(plan.DeleteDirs[i], "/") > strings.Count(plan.DeleteDirs[j], "/")
+	})
+}
+
+// checkDeletionLimits returns an error if the planned deletions exceed the configured safety limits
+func (s *SFTPSync) checkDeletionLimits(plan *SyncPlan) error {
+	count := len(plan.Deletions)
+	if count == 0 {
+		return nil
+	}
+
+	if s.SyncConfig.MaxDeletions > 0 && count > s.SyncConfig.MaxDeletions {
+		return fmt.Errorf("mirror would delete %d destination files, more than max_deletions (%d); aborting run",
+			count, s.SyncConfig.MaxDeletions)
+	}
+
+	maxPercent, hint := s.SyncConfig.MaxDeletionPercent, ""
+	if maxPercent == 0 && s.SyncConfig.MaxDeletions == 0 {
+		maxPercent = DefaultMaxDeletionPercent
+		hint = ", the default when no limit is set"
+	}
+	if maxPercent > 0 && plan.DestinationFiles > 0 {
+		percent := float64(count) / float64(plan.DestinationFiles) * 100
+		if percent > maxPercent {
+			return fmt.Errorf("mirror would delete %d of %d destination files (%.1f%%), more than max_deletion_percent (%.1f%%%s); aborting run",
+				count, plan.DestinationFiles, percent, maxPercent, hint)
+		}
+	}
+
+	return nil
+}
+
+// deleteWithContext removes the files and now-empty directories planned for deletion
+func (s *SFTPSync) deleteWithContext(ctx context.Context, plan *SyncPlan) error {
+	for _, dateDir := range plan.ProtectedDateDirs {
+		log.Printf("⚠️  Skipping mirror deletions in %s: source scan reported errors", dateDir)
+	}
+
+	if len(plan.Deletions) == 0 && len(plan.DeleteDirs) == 0 {
+		return nil
+	}
+
+	log.Printf("🗑️  Mirror: deleting %d files missing at source...", len(plan.Deletions))
+
+	started := s.startPhase(PhaseDeleting, len(plan.Deletions), 0)
+	client, err := s.dest.client()
+	if err != nil {
+		s.finishPhase(PhaseDeleting, started, err)
+		return err
+	}
+	for i, deletion := range plan.Deletions {
+		select {
+		case <-ctx.Done():
+			s.finishPhase(PhaseDeleting, started, ctx.Err())
+			return ctx.Err()
+		default:
+		}
+
+		if err := client.Remove(deletion.Path); err != nil {
+			log.Printf("❌ Failed to delete %s: %v", deletion.RelativePath, err)
+		} else {
+			s.Stats.mutex.Lock()
+			s.Stats.DeletedFiles++
+			s.Stats.mutex.Unlock()
+			log.Printf("Deleted: %s", deletion.RelativePath)
+			s.emit(Event{Type: EventFileDeleted, Path: deletion.RelativePath, Size: deletion.Size})
+		}
+		s.reportProgress(Progress{Phase: PhaseDeleting, FilesDone: i + 1, FilesTotal: len(plan.Deletions)})
+	}
+
+	for _, dir := range plan.DeleteDirs {
+		select {
+		case <-ctx.Done():
+			s.finishPhase(PhaseDeleting, started, ctx.Err())
+			return ctx.Err()
+		default:
+		}
+
+		// RemoveDirectory fails on non-empty directories, which is what we want
+		if err := client.RemoveDirectory(dir); err != nil {
+			log.Printf("Warning: Could not remove directory %s: %v", dir, err)
+			continue
+		}
+		log.Printf("Removed empty directory: %s", dir)
+	}
+
+	s.finishPhase(PhaseDeleting, started, nil)
+	return nil
+}
+
+// relativeTo returns p relative to root using forward slashes
+func relativeTo(root, p string) string {
+	rel := strings.TrimPrefix(p, root)
+	return strings.TrimPrefix(rel, "/")
+}
@@ -0,0 +1,127 @@
+package sftpsync
+
+import (
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testGraph builds a directory graph from paths relative to root; entries
+// ending in "/" are directories
+func testGraph(root string, entries ...string) *DirectoryGraph {
+	graph := NewDirectoryGraph(root)
+	for _, entry := range entries {
+		if dir, ok := strings.CutSuffix(entry, "/"); ok {
+			graph.AddDir(path.Join(root, dir))
+			continue
+		}
+		graph.AddFile(&FileInfo{Path: path.Join(root, entry), RelativePath: entry, Size: 1})
+	}
+	return graph
+}
+
+func TestPlanDeletions(t *testing.T) {
+	dateDirs := []DateDir{
+		{Source: "2026/10/16", Destination: "20261016"},
+		{Source: "2026/10/15", Destination: "20261015"},
+	}
+
+	tests := []struct {
+		name      string
+		source    *DirectoryGraph
+		dest      *DirectoryGraph
+		wantFiles []string
+		wantDirs  []string
+	}{
+		{
+			name:      "files missing at source are deleted",
+			source:    testGraph("/src", "2026/10/16/", "2026/10/16/a.csv"),
+			dest:      testGraph("/dst", "20261016/", "20261016/a.csv", "20261016/b.csv"),
+			wantFiles: []string{"20261016/b.csv"},
+		},
+		{
+			name:   "files outside the synced date directories are kept",
+			source: testGraph("/src", "2026/10/16/"),
+			dest:   testGraph("/dst", "20261016/", "20200101/old.csv", "notes.txt"),
+		},
+		{
+			name:      "directories gone at source are removed deepest first",
+			source:    testGraph("/src", "2026/10/16/"),
+			dest:      testGraph("/dst", "20261016/", "20261016/x/", "20261016/x/y/", "20261016/x/y/f.csv"),
+			wantFiles: []string{"20261016/x/y/f.csv"},
+			wantDirs:  []string{"/dst/20261016/x/y", "/dst/20261016/x"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{SourcePath: "/src", DestinationPath: "/dst", Mirror: true})
+			plan := &SyncPlan{DateDirs: dateDirs}
+			s.planDeletions(plan, tt.source, tt.dest)
+
+			var files []string
+			for _, deletion := range plan.Deletions {
+				files = append(files, deletion.RelativePath)
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("deletions = %v, want %v", files, tt.wantFiles)
+			}
+			if !reflect.DeepEqual(plan.DeleteDirs, tt.wantDirs) {
+				t.Errorf("directory deletions = %v, want %v", plan.DeleteDirs, tt.wantDirs)
+			}
+		})
+	}
+}
+
+func TestPlanDeletionsProtectsFailedScans(t *testing.T) {
+	source := testGraph("/src")
+	source.AddScanError("/src/2026/10/16", errors.New("permission denied"))
+	dest := testGraph("/dst", "20261016/", "20261016/a.csv")
+
+	s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{SourcePath: "/src", DestinationPath: "/dst", Mirror: true})
+	plan := &SyncPlan{DateDirs: []DateDir{{Source: "2026/10/16", Destination: "20261016"}}}
+	s.planDeletions(plan, source, dest)
+
+	if len(plan.Deletions) != 0 || len(plan.DeleteDirs) != 0 {
+		t.Errorf("planned deletions under a failed scan: %v %v", plan.Deletions, plan.DeleteDirs)
+	}
+	if !reflect.DeepEqual(plan.ProtectedDateDirs, []string{"20261016"}) {
+		t.Errorf("protected = %v, want [20261016]", plan.ProtectedDateDirs)
+	}
+}
+
+func TestCheckDeletionLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		deletions  int
+		destFiles  int
+		maxCount   int
+		maxPercent float64
+		wantErr    bool
+	}{
+		{"nothing to delete", 0, 100, 0, 0, false},
+		{"within the default percentage", 10, 100, 0, 0, false},
+		{"over the default percentage", 11, 100, 0, 0, true},
+		{"empty source listing", 50, 50, 0, 0, true},
+		{"default off with a count limit", 50, 50, 100, 0, false},
+		{"count limit exceeded", 6, 100, 5, 0, true},
+		{"explicit percentage", 40, 100, 0, 50, false},
+		{"explicit percentage exceeded", 60, 100, 0, 50, true},
+		{"percentage disabled", 50, 50, 0, -1, false},
+		{"both disabled", 50, 50, -1, -1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{
+				Mirror: true, MaxDeletions: tt.maxCount, MaxDeletionPercent: tt.maxPercent,
+			})
+			plan := &SyncPlan{DestinationFiles: tt.destFiles, Deletions: make([]PlannedDeletion, tt.deletions)}
+			if err := s.checkDeletionLimits(plan); (err != nil) != tt.wantErr {
+				t.Errorf("checkDeletionLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package sftpsync
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification triggers, as listed in the "on" setting of each channel
+const (
+	NotifyFailure   = "failure"   // the run failed
+	NotifyPartial   = "partial"   // the run finished but some files failed
+	NotifyCancelled = "cancelled" // the run was stopped
+	NotifySuccess   = "success"   // every file is up to date
+	NotifyNoFiles   = "no_files"  // today's source directory is missing or empty
+)
+
+// defaultNotifyOn is used for a channel without an "on" list
+var defaultNotifyOn = []string{NotifyFailure, NotifyPartial}
+
+const (
+	defaultMaxFailedFiles = 20
+	notifyTimeout         = 30 * time.Second
+)
+
+// notifyTriggers maps run results to the trigger that announces them
+var notifyTriggers = map[string]string{
+	ResultFailed:    NotifyFailure,
+	ResultPartial:   NotifyPartial,
+	ResultCancelled: NotifyCancelled,
+	ResultSuccess:   NotifySuccess,
+}
+
+// Notification is what a run sends to its channels. Webhooks receive it as
+// JSON; Text makes the payload readable by Slack and Teams incoming webhooks.
+type Notification struct {
+	Text        string         `json:"text"`
+	Event       string         `json:"event"`
+	Subject     string         `json:"subject"`
+	Host        string         `json:"host"`
+	RunID       string         `json:"run_id,omitempty"`
+	Trigger     string         `json:"trigger"`
+	Result      string         `json:"result"`
+	Error       string         `json:"error,omitempty"`
+	NoFilesDir  string         `json:"no_files_dir,omitempty"`
+	Stats       *StatsSnapshot `json:"stats,omitempty"`
+	FailedFiles []FileRecord   `json:"failed_files"`
+	FailedTotal int            `json:"failed_total"`
+}
+
+// Notifier sends email and webhook notifications when the runs it observes finish
+type Notifier struct {
+	config   NotificationsConfigJSON
+	email    map[string]bool
+	webhooks []map[string]bool
+	client   *http.Client
+	pending  sync.WaitGroup
+}
+
+// NewNotifier validates the notifications section. A section without channels
+// gives a Notifier that never sends anything.
+func NewNotifier(config NotificationsConfigJSON) (*Notifier, error) {
+	n := &Notifier{config: config, client: &http.Client{Timeout: notifyTimeout}}
+	if n.config.MaxFailedFiles <= 0 {
+		n.config.MaxFailedFiles = defaultMaxFailedFiles
+	}
+
+	if email := config.Email; email != nil {
+		if email.SMTPHost == "" || email.From == "" || len(email.To) == 0 {
+			return nil, fmt.Errorf("notifications email needs smtp_host, from and at least one to address")
+		}
+		triggers, err := parseNotifyOn(email.On)
+		if err != nil {
+			return nil, fmt.Errorf("notifications email: %v", err)
+		}
+		n.email = triggers
+	}
+
+	for i, webhook := range config.Webhooks {
+		if !strings.HasPrefix(webhook.URL, "https://") && !strings.HasPrefix(webhook.URL, "http://") {
+			return nil, fmt.Errorf("notifications webhook %d: url must be an http or https URL", i+1)
+		}
+		triggers, err := parseNotifyOn(webhook.On)
+		if err != nil {
+			return nil, fmt.Errorf("notifications webhook %d: %v", i+1, err)
+		}
+		n.webhooks = append(n.webhooks, triggers)
+	}
+
+	return n, nil
+}
+
+// parseNotifyOn checks a channel's triggers
+func parseNotifyOn(on []string) (map[string]bool, error) {
+	if len(on) == 0 {
+		on = defaultNotifyOn
+	}
+	triggers := make(map[string]bool, len(on))
+	for _, trigger := range on {
+		switch trigger {
+		case NotifyFailure, NotifyPartial, NotifyCancelled, NotifySuccess, NotifyNoFiles:
+			triggers[trigger] = true
+		default:
+			return nil, fmt.Errorf("unknown trigger %q (use %s, %s, %s, %s or %s)", trigger,
+				NotifyFailure, NotifyPartial, NotifyCancelled, NotifySuccess, NotifyNoFiles)
+		}
+	}
+	return triggers, nil
+}
+
+// Observe notifies about the runs of s from now on. Messages are sent in the
+// background; call Wait before exiting. It returns a function that stops observing.
+func (n *Notifier) Observe(s *SFTPSync) (unsubscribe func()) {
+	if n.email == nil && len(n.webhooks) == 0 {
+		return func() {}
+	}
+
+	today := s.newDateDir(time.Now()).Source
+	var mutex sync.Mutex
+	var failed []FileRecord
+	noFiles := false
+
+	return s.Subscribe(func(e Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch e.Type {
+		case EventDirectoryScanned:
+			if e.Side == "source" && e.Path == today && e.Files == 0 {
+				noFiles = true
+			}
+		case EventFileFailed:
+			failed = append(failed, FileRecord{Path: e.Path, Outcome: FileFailed, Size: e.Size, Duration: e.Duration, Error: e.Error})
+		case EventRunSummary:
+			n.send(n.compose(s, e, failed, noFiles, today), noFiles)
+			failed, noFiles = nil, false
+		}
+	})
+}
+
+// Wait blocks until every notification has been sent or has failed
+func (n *Notifier) Wait() {
+	n.pending.Wait()
+}
+
+// compose builds the notification for a finished run
+func (n *Notifier) compose(s *SFTPSync, e Event, failed []FileRecord, noFiles bool, today string) *Notification {
+	hostname, _ := os.Hostname()
+	trigger := s.Trigger
+	if trigger == "" {
+		trigger = "manual"
+	}
+
+	message := &Notification{
+		Event:       notifyTriggers[e.Result],
+		Host:        hostname,
+		Trigger:     trigger,
+		Result:      e.Result,
+		Error:       e.Error,
+		Stats:       e.Summary,
+		FailedTotal: len(failed),
+		FailedFiles: failed,
+		RunID:       s.runID,
+	}
+	if len(message.FailedFiles) > n.config.MaxFailedFiles {
+		message.FailedFiles = message.FailedFiles[:n.config.MaxFailedFiles]
+	}
+	if noFiles {
+		message.NoFilesDir = today
+	}
+
+	message.Subject = fmt.Sprintf("[SFTP Sync] %s: %s run on %s", strings.ToUpper(e.Result), trigger, hostname)
+	if e.Result == ResultSuccess && noFiles {
+		message.Subject = fmt.Sprintf("[SFTP Sync] NO FILES: %s run on %s found nothing in %s", trigger, hostname, today)
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s\n", message.Subject)
+	fmt.Fprintf(&text, "%s@%s:%d%s -> %s@%s:%d%s\n",
+		s.SourceConfig.Username, s.SourceConfig.Host, s.SourceConfig.Port, s.SyncConfig.SourcePath,
+		s.DestinationConfig.Username, s.DestinationConfig.Host, s.DestinationConfig.Port, s.SyncConfig.DestinationPath)
+	if e.Error != "" {
+		fmt.Fprintf(&text, "Error: %s\n", e.Error)
+	}
+	if noFiles {
+		fmt.Fprintf(&text, "No files found for today in %s\n", today)
+	}
+	text.WriteString("\n")
+	for _, line := range s.statsLines() {
+		text.WriteString(line + "\n")
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&text, "\nFailed files (%d of %d):\n", len(message.FailedFiles), len(failed))
+		for _, f := range message.FailedFiles {
+			fmt.Fprintf(&text, "  %s: %s\n", f.Path, f.Error)
+		}
+	}
+	message.Text = text.String()
+	return message
+}
+
+// send delivers message to every channel whose triggers match, in the background
+func (n *Notifier) send(message *Notification, noFiles bool) {
+	wanted := func(triggers map[string]bool) string {
+		if triggers[message.Event] {
+			return message.Event
+		}
+		if noFiles && triggers[NotifyNoFiles] {
+			return NotifyNoFiles
+		}
+		return ""
+	}
+
+	if event := wanted(n.email); event != "" {
+		n.pending.Add(1)
+		go func() {
+			defer n.pending.Done()
+			if err := n.sendEmail(message); err != nil {
+				log.Printf("Warning: Email notification failed: %v", err)
+			}
+		}()
+	}
+	for i, triggers := range n.webhooks {
+		event := wanted(triggers)
+		if event == "" {
+			continue
+		}
+		payload := *message
+		payload.Event = event
+		webhook := n.config.Webhooks[i]
+		n.pending.Add(1)
+		go func() {
+			defer n.pending.Done()
+			if err := n.postWebhook(webhook, &payload); err != nil {
+				log.Printf("Warning: Webhook notification to %s failed: %v", redactURL(webhook.URL), err)
+			}
+		}()
+	}
+}
+
+// sendEmail delivers message over SMTP, upgrading to TLS with STARTTLS when the
+// server offers it, or over TLS from the start with implicit_tls (port 465)
+func (n *Notifier) sendEmail(message *Notification) error {
+	config := n.config.Email
+	port := config.SMTPPort
+	if port == 0 {
+		port = 587
+		if config.ImplicitTLS {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: config.SMTPHost}
+
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	var conn net.Conn
+	var err error
+	if config.ImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * notifyTimeout))
+
+	client, err := smtp.NewClient(conn, config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	defer client.Close()
+
+	if !config.ImplicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("STARTTLS failed: %v", err)
+			}
+		}
+	}
+	if config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.SMTPHost)); err != nil {
+			return fmt.Errorf("SMTP login failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	for _, to := range config.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s refused: %v", to, err)
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(data, "From: %s\r\n", config.From)
+	fmt.Fprintf(data, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(data, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(data, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(data, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprint(data, strings.ReplaceAll(message.Text, "\n", "\r\n"))
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// postWebhook posts message as JSON and expects a 2xx answer
+func (n *Notifier) postWebhook(webhook WebhookConfigJSON, message *Notification) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("server answered %s", response.Status)
+	}
+	return nil
+}
+
+// redactURL drops the path and query of a webhook URL, which often embed its secret
+func redactURL(url string) string {
+	scheme, rest, _ := strings.Cut(url, "://")
+	host, _, _ := strings.Cut(rest, "/")
+	return scheme + "://" + host + "/..."
+}
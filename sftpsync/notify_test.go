@@ -0,0 +1,186 @@
+package sftpsync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewNotifier(t *testing.T) {
+	email := func(change func(*EmailConfigJSON)) *EmailConfigJSON {
+		config := &EmailConfigJSON{SMTPHost: "smtp.example.com", From: "sync@example.com", To: []string{"ops@example.com"}}
+		change(config)
+		return config
+	}
+
+	tests := []struct {
+		name    string
+		config  NotificationsConfigJSON
+		wantErr string
+	}{
+		{"no channels", NotificationsConfigJSON{}, ""},
+		{"email", NotificationsConfigJSON{Email: email(func(*EmailConfigJSON) {})}, ""},
+		{"email without recipients", NotificationsConfigJSON{Email: email(func(c *EmailConfigJSON) { c.To = nil })}, "needs smtp_host, from and at least one to address"},
+		{"email with unknown trigger", NotificationsConfigJSON{Email: email(func(c *EmailConfigJSON) { c.On = []string{"sometimes"} })}, `notifications email: unknown trigger "sometimes"`},
+		{"webhook", NotificationsConfigJSON{Webhooks: []WebhookConfigJSON{{URL: "https://hooks.example.com/x", On: []string{NotifySuccess, NotifyNoFiles}}}}, ""},
+		{"webhook without scheme", NotificationsConfigJSON{Webhooks: []WebhookConfigJSON{{URL: "https://ok.example.com"}, {URL: "hooks.example.com/x"}}}, "notifications webhook 2: url must be an http or https URL"},
+		{"webhook with unknown trigger", NotificationsConfigJSON{Webhooks: []WebhookConfigJSON{{URL: "http://hooks.example.com", On: []string{"failed"}}}}, `notifications webhook 1: unknown trigger "failed"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNotifier(tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewNotifier() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("NewNotifier() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testWebhookServer records the notifications posted to it by path. Posts to
+// /broken are answered with a server error.
+type testWebhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	received map[string][]Notification
+	headers  map[string]http.Header
+}
+
+func newTestWebhookServer(t *testing.T) *testWebhookServer {
+	t.Helper()
+	srv := &testWebhookServer{received: make(map[string][]Notification), headers: make(map[string]http.Header)}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var message Notification
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("webhook body is not a notification: %v", err)
+		}
+		srv.mutex.Lock()
+		srv.received[r.URL.Path] = append(srv.received[r.URL.Path], message)
+		srv.headers[r.URL.Path] = r.Header.Clone()
+		srv.mutex.Unlock()
+		if r.URL.Path == "/broken" {
+			http.Error(rw, "down for maintenance", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// events returns the events of the notifications posted to path
+func (srv *testWebhookServer) events(path string) []string {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	var events []string
+	for _, message := range srv.received[path] {
+		events = append(events, message.Event)
+	}
+	sort.Strings(events)
+	return events
+}
+
+func TestNotifierWebhooks(t *testing.T) {
+	srv := newTestWebhookServer(t)
+	notifier, err := NewNotifier(NotificationsConfigJSON{
+		MaxFailedFiles: 2,
+		Webhooks: []WebhookConfigJSON{
+			{URL: srv.URL + "/default", Headers: map[string]string{"Authorization": "Bearer hook-secret"}},
+			{URL: srv.URL + "/success", On: []string{NotifySuccess}},
+			{URL: srv.URL + "/no-files", On: []string{NotifyNoFiles}},
+			{URL: srv.URL + "/broken", On: []string{NotifyFailure}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSFTPSync(SFTPConfig{Host: "source.example.com", Username: "sync", Port: 22}, SFTPConfig{Host: "dest.example.com", Username: "sync", Port: 22},
+		SyncConfig{SourcePath: "/out", DestinationPath: "/in", DateLayout: DefaultDateLayout})
+	s.Trigger = "schedule:nightly"
+	unsubscribe := notifier.Observe(s)
+	defer unsubscribe()
+	today := s.newDateDir(time.Now()).Source
+
+	// A clean run that found nothing for today
+	s.emit(Event{Type: EventDirectoryScanned, Side: "source", Path: today})
+	s.emit(Event{Type: EventRunSummary, Result: ResultSuccess, Summary: &StatsSnapshot{}})
+
+	// A failed run with more failed files than the message lists
+	for _, name := range []string{"a.csv", "b.csv", "c.csv"} {
+		s.emit(Event{Type: EventFileFailed, Path: today + "/" + name, Size: 1, Error: "permission denied"})
+	}
+	s.emit(Event{Type: EventRunSummary, Result: ResultFailed, Error: "destination went away", Summary: &StatsSnapshot{FailedFiles: 3}})
+	notifier.Wait()
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/default", []string{NotifyFailure}},
+		{"/success", []string{NotifySuccess}},
+		{"/no-files", []string{NotifyNoFiles}},
+		{"/broken", []string{NotifyFailure}},
+	}
+	for _, tt := range tests {
+		if got := srv.events(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s received %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	failure := srv.received["/default"][0]
+	if failure.Result != ResultFailed || failure.Trigger != "schedule:nightly" || failure.Error != "destination went away" {
+		t.Errorf("failure notification = %+v", failure)
+	}
+	if len(failure.FailedFiles) != 2 || failure.FailedTotal != 3 {
+		t.Errorf("failed files = %d of %d, want 2 of 3", len(failure.FailedFiles), failure.FailedTotal)
+	}
+	for _, want := range []string{"FAILED: schedule:nightly run", "sync@source.example.com:22/out -> sync@dest.example.com:22/in", "Error: destination went away", "Failed files (2 of 3)"} {
+		if !strings.Contains(failure.Text, want) {
+			t.Errorf("notification text has no %q:\n%s", want, failure.Text)
+		}
+	}
+	if got := srv.headers["/default"].Get("Authorization"); got != "Bearer hook-secret" {
+		t.Errorf("Authorization header = %q", got)
+	}
+	if got := srv.headers["/default"].Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	noFiles := srv.received["/no-files"][0]
+	if noFiles.NoFilesDir != today || !strings.Contains(noFiles.Subject, "NO FILES") {
+		t.Errorf("no-files notification = %+v", noFiles)
+	}
+}
+
+func TestPostWebhookReportsErrors(t *testing.T) {
+	srv := newTestWebhookServer(t)
+	notifier, err := NewNotifier(NotificationsConfigJSON{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.postWebhook(WebhookConfigJSON{URL: srv.URL + "/ok"}, &Notification{Event: NotifyFailure}); err != nil {
+		t.Errorf("postWebhook() error = %v", err)
+	}
+	err = notifier.postWebhook(WebhookConfigJSON{URL: srv.URL + "/broken"}, &Notification{Event: NotifyFailure})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("postWebhook() error = %v, want the server's 503", err)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	got := redactURL("https://hooks.slack.com/services/T000/B000/XXXX?token=secret")
+	if got != "https://hooks.slack.com/..." {
+		t.Errorf("redactURL() = %q", got)
+	}
+}
@@ -0,0 +1,237 @@
+package sftpsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons a source file is included in a sync plan
+const (
+	ReasonMissing     = "missing"
+	ReasonSizeDiffers = "size differs"
+	ReasonSourceNewer = "source newer"
+
+	// Checksum comparison modes only
+	ReasonChecksumDiffers     = "checksum differs"
+	ReasonChecksumUnavailable = "checksum unavailable"
+)
+
+// PlannedFile is a source file that a sync run would transfer
+type PlannedFile struct {
+	Path            string    `json:"path"`
+	RelativePath    string    `json:"relative_path"`
+	DestinationPath string    `json:"destination_path"`
+	Size            int64     `json:"size"`
+	ModTime         time.Time `json:"mod_time"`
+	Reason          string    `json:"reason"`
+
+	source *FileInfo
+}
+
+// PlannedDeletion is a destination file that mirror mode would remove
+type PlannedDeletion struct {
+	Path         string `json:"path"`
+	RelativePath string `json:"relative_path"`
+	Size         int64  `json:"size"`
+}
+
+// SyncPlan lists every file a sync run would transfer and why
+type SyncPlan struct {
+	GeneratedAt      time.Time      `json:"generated_at"`
+	SourcePath       string         `json:"source_path"`
+	DestinationPath  string         `json:"destination_path"`
+	CompareMode      string         `json:"compare_mode"`
+	MtimeTolerance   string         `json:"mtime_tolerance,omitempty"`
+	DateDirs         []DateDir      `json:"date_dirs"`
+	Files            []PlannedFile  `json:"files"`
+	UpToDate         int            `json:"up_to_date"`
+	UnchangedBy      map[string]int `json:"unchanged_by"`
+	TotalBytes       int64          `json:"total_bytes"`
+	DestinationFiles int            `json:"destination_files"`
+
+	// Mirror mode only
+	Deletions         []PlannedDeletion `json:"deletions,omitempty"`
+	DeleteDirs        []string          `json:"delete_dirs,omitempty"`
+	ProtectedDateDirs []string          `json:"protected_date_dirs,omitempty"`
+	DeletionLimit     string            `json:"deletion_limit,omitempty"`
+}
+
+// Plan connects to both servers and works out what Sync would transfer without copying anything
+func (s *SFTPSync) Plan() (*SyncPlan, error) {
+	return s.PlanWithContext(context.Background())
+}
+
+// PlanWithContext is Plan with cancellation support
+func (s *SFTPSync) PlanWithContext(ctx context.Context) (*SyncPlan, error) {
+	if err := s.Connect(); err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return s.buildPlan(ctx, s.dateWindow())
+}
+
+// buildPlan scans the date directories on both sides and compares them
+func (s *SFTPSync) buildPlan(ctx context.Context, dateDirs []DateDir) (plan *SyncPlan, err error) {
+	// Check for cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	// Scope is the number of date directories across both sides
+	started := s.startPhase(PhaseScanning, 2*len(dateDirs), 0)
+	defer func() { s.finishPhase(PhaseScanning, started, err) }()
+
+	if s.SyncConfig.Dates.IsZero() {
+		log.Printf("Syncing directories for last %d days: %v", s.SyncConfig.DaysToSync, sourceDirNames(dateDirs))
+	} else {
+		log.Printf("Syncing directories: %v", sourceDirNames(dateDirs))
+	}
+	if s.destDateLayout() != s.sourceDateLayout() {
+		log.Printf("Destination directories: %v", destDirNames(dateDirs))
+	}
+
+	// Build destination directory graph first (for comparison)
+	log.Println("Building destination directory graph...")
+	destClient, err := s.dest.client()
+	if err != nil {
+		return nil, err
+	}
+	destGraph, err := s.buildDirectoryGraphWithContext(ctx, "destination", destClient, s.SyncConfig.DestinationPath, destDirNames(dateDirs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build destination graph: %w", err)
+	}
+
+	// Check for cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	// Build source directory graph
+	log.Println("Building source directory graph...")
+	sourceClient, err := s.source.client()
+	if err != nil {
+		return nil, err
+	}
+	sourceGraph, err := s.buildDirectoryGraphWithContext(ctx, "source", sourceClient, s.SyncConfig.SourcePath, sourceDirNames(dateDirs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build source graph: %w", err)
+	}
+
+	// Check for cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	// Compare graphs and get files to sync
+	log.Printf("🔍 Comparing directory graphs (compare mode: %s)...", s.compareMode())
+	plan, err = s.compareGraphsWithContext(ctx, sourceGraph, destGraph, dateDirs)
+	if err != nil {
+		return nil, err
+	}
+	plan.DateDirs = dateDirs
+	plan.DestinationFiles = destGraph.GetFileCount()
+
+	if s.SyncConfig.Mirror {
+		s.planDeletions(plan, sourceGraph, destGraph)
+		if err := s.checkDeletionLimits(plan); err != nil {
+			plan.DeletionLimit = err.Error()
+		}
+	}
+
+	return plan, nil
+}
+
+// Print logs the plan as a table
+func (p *SyncPlan) Print() {
+	log.Println(strings.Repeat("=", 60))
+	log.Println("📋 SYNC PLAN (dry run - nothing was transferred)")
+	log.Println(strings.Repeat("=", 60))
+	log.Printf("🔍 Compare mode: %s", p.describeCompareMode())
+
+	for _, f := range p.Files {
+		log.Printf("   %-20s %12s  %s  %s", f.Reason, formatBytes(f.Size), f.ModTime.Format("2006-01-02 15:04:05"), f.RelativePath)
+	}
+
+	for _, d := range p.Deletions {
+		log.Printf("   %-20s %12s  %s", "delete", formatBytes(d.Size), d.RelativePath)
+	}
+
+	log.Printf("📁 Files to transfer: %d (%s)", len(p.Files), formatBytes(p.TotalBytes))
+	log.Printf("⏭️  Already up to date: %d%s", p.UpToDate, p.describeUnchanged())
+	if len(p.Deletions) > 0 || len(p.ProtectedDateDirs) > 0 {
+		log.Printf("🗑️  Files to delete (mirror): %d, empty directories to remove: %d", len(p.Deletions), len(p.DeleteDirs))
+	}
+	for _, dir := range p.ProtectedDateDirs {
+		log.Printf("⚠️  Not deleting in %s: source scan reported errors", dir)
+	}
+	if p.DeletionLimit != "" {
+		log.Printf("⚠️  %s", p.DeletionLimit)
+	}
+	log.Println(strings.Repeat("=", 60))
+}
+
+// describeCompareMode renders the compare mode and tolerance for logs
+func (p *SyncPlan) describeCompareMode() string {
+	if p.MtimeTolerance != "" {
+		return fmt.Sprintf("%s (mtime tolerance %s)", p.CompareMode, p.MtimeTolerance)
+	}
+	return p.CompareMode
+}
+
+// describeUnchanged renders the up-to-date breakdown, e.g. " (12 same size and mtime, 3 same checksum)"
+func (p *SyncPlan) describeUnchanged() string {
+	reasons := make([]string, 0, len(p.UnchangedBy))
+	for reason, count := range p.UnchangedBy {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	sort.Strings(reasons)
+	return " (" + strings.Join(reasons, ", ") + ")"
+}
+
+// logDecisions logs the per-file decisions and how many files were selected for each reason
+func (p *SyncPlan) logDecisions() {
+	counts := make(map[string]int)
+	for _, f := range p.Files {
+		log.Printf("   %-20s %s", f.Reason, f.RelativePath)
+		counts[f.Reason]++
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasons)
+
+	if len(reasons) > 0 {
+		log.Printf("📋 To transfer: %s", strings.Join(reasons, ", "))
+	}
+	log.Printf("⏭️  Up to date: %d%s", p.UpToDate, p.describeUnchanged())
+}
+
+// WriteJSON writes the plan to a JSON file
+func (p *SyncPlan) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package sftpsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPlan compares a fake source and destination graph with one file for
+// each reason and one that is up to date
+func testPlan(t *testing.T) *SyncPlan {
+	t.Helper()
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	source, dest := NewDirectoryGraph("/src"), NewDirectoryGraph("/dst")
+	for _, f := range []struct {
+		name       string
+		sourceSize int64
+		destSize   int64 // -1 for a file missing at the destination
+		sourceAge  time.Duration
+	}{
+		{"20261016/missing.csv", 10, -1, 0},
+		{"20261016/resized.csv", 20, 21, 0},
+		{"20261015/newer.csv", 30, 30, time.Minute},
+		{"20261015/same.csv", 40, 40, 0},
+	} {
+		source.AddFile(&FileInfo{Path: "/src/" + f.name, RelativePath: f.name, Size: f.sourceSize, ModTime: base.Add(f.sourceAge)})
+		if f.destSize >= 0 {
+			dest.AddFile(&FileInfo{Path: "/dst/" + f.name, RelativePath: f.name, Size: f.destSize, ModTime: base})
+		}
+	}
+
+	s := testCompareSync(t, CompareSizeMtime, 0)
+	plan, err := s.compareGraphsWithContext(context.Background(), source, dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func TestPlanReasons(t *testing.T) {
+	plan := testPlan(t)
+
+	want := []struct {
+		path   string
+		reason string
+	}{
+		{"20261016/missing.csv", ReasonMissing},
+		{"20261016/resized.csv", ReasonSizeDiffers},
+		{"20261015/newer.csv", ReasonSourceNewer},
+	}
+	if len(plan.Files) != len(want) {
+		t.Fatalf("planned %d files, want %d: %+v", len(plan.Files), len(want), plan.Files)
+	}
+	for i, w := range want {
+		f := plan.Files[i]
+		if f.RelativePath != w.path || f.Reason != w.reason {
+			t.Errorf("file %d = %s (%s), want %s (%s)", i, f.RelativePath, f.Reason, w.path, w.reason)
+		}
+		if f.DestinationPath != "/dst/"+w.path {
+			t.Errorf("%s: destination path = %s", w.path, f.DestinationPath)
+		}
+	}
+	if plan.TotalBytes != 60 {
+		t.Errorf("total bytes = %d, want 60", plan.TotalBytes)
+	}
+	if plan.UpToDate != 1 || plan.UnchangedBy[UnchangedSameSizeMtime] != 1 {
+		t.Errorf("up to date = %d %v, want 1 by size and mtime", plan.UpToDate, plan.UnchangedBy)
+	}
+}
+
+func TestPlanPrint(t *testing.T) {
+	plan := testPlan(t)
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	plan.Print()
+
+	for _, want := range []string{
+		"Compare mode: " + CompareSizeMtime,
+		ReasonMissing, "20261016/missing.csv",
+		ReasonSizeDiffers, "20261016/resized.csv",
+		ReasonSourceNewer, "20261015/newer.csv",
+		"Files to transfer: 3 (60 bytes)",
+		"Already up to date: 1 (1 " + UnchangedSameSizeMtime + ")",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("plan output has no %q:\n%s", want, output.String())
+		}
+	}
+	if strings.Contains(output.String(), "same.csv") {
+		t.Errorf("plan output lists the up-to-date file:\n%s", output.String())
+	}
+}
+
+func TestPlanWriteJSON(t *testing.T) {
+	plan := testPlan(t)
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.WriteJSON(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written SyncPlan
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("plan file is not valid JSON: %v", err)
+	}
+	if len(written.Files) != 3 || written.Files[0].Reason != ReasonMissing || written.Files[2].RelativePath != "20261015/newer.csv" {
+		t.Errorf("written files = %+v", written.Files)
+	}
+	if written.CompareMode != CompareSizeMtime || written.TotalBytes != 60 || written.UpToDate != 1 {
+		t.Errorf("written plan = %+v", written)
+	}
+
+	if err := plan.WriteJSON(filepath.Join(t.TempDir(), "missing", "plan.json")); err == nil {
+		t.Error("WriteJSON() into a missing directory succeeded")
+	}
+}
@@ -0,0 +1,257 @@
+package sftpsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpSession is one SFTP subsystem running over an SSH connection
+type sftpSession struct {
+	conn   *sftpConn
+	client *sftp.Client
+}
+
+// sessionPool hands out the SFTP sessions to one server. Transfer workers
+// check a session out for each attempt, so at most max_sessions transfers
+// touch the server at once. Sessions are opened on demand, packed
+// sessions_per_connection to an SSH connection, and replaced when their
+// connection drops. Without max_sessions every worker shares one session,
+// which is replaced the same way.
+type sessionPool struct {
+	sync   *SFTPSync
+	side   string // "source" or "destination"
+	config SFTPConfig
+
+	shared      bool          // max_sessions is not set
+	sharedMutex sync.Mutex    // replaces the shared session one worker at a time
+	slots       chan struct{} // one token per checked-out session
+	perConn     int
+	dialMutex   sync.Mutex // opens one session at a time
+	lost        atomic.Bool
+
+	mutex sync.Mutex
+	idle  []*sftpSession
+	conns []*sftpConn
+	last  *sftpSession // most recently opened, for work outside the transfers
+	err   error        // why reconnecting gave up
+}
+
+// newSessionPool opens the first session to a server, so that an unreachable
+// server or a refused login is reported straight away
+func newSessionPool(s *SFTPSync, side string, config SFTPConfig) (*sessionPool, error) {
+	p := &sessionPool{
+		sync:    s,
+		side:    side,
+		config:  config,
+		shared:  config.MaxSessions <= 0,
+		slots:   make(chan struct{}, max(config.MaxSessions, 1)),
+		perConn: max(config.SessionsPerConnection, 1),
+	}
+
+	session, err := p.open(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if !p.shared {
+		p.idle = append(p.idle, session)
+	}
+	return p, nil
+}
+
+// acquire checks a session out of the pool, waiting while max_sessions are in use
+func (p *sessionPool) acquire(ctx context.Context) (*sftpSession, error) {
+	if p.shared {
+		return p.acquireShared(ctx)
+	}
+	for {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		p.mutex.Lock()
+		for len(p.idle) > 0 {
+			session := p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+			if !session.conn.isDead() {
+				p.mutex.Unlock()
+				return session, nil
+			}
+			p.connectionLost(session.conn)
+			p.discardLocked(session)
+		}
+		err, open := p.err, len(p.conns) > 0
+		p.mutex.Unlock()
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+
+		session, err := p.open(ctx)
+		if err == nil {
+			return session, nil
+		}
+		if !open || p.lost.Load() || ctx.Err() != nil {
+			<-p.slots
+			return nil, err
+		}
+
+		// The server refused another session; keep this slot so the pool
+		// stops asking, and wait for one of the sessions already open
+		log.Printf("Warning: %s SFTP server refused another session, continuing with fewer: %v", p.side, err)
+	}
+}
+
+// acquireShared returns the session every worker shares when max_sessions is
+// not set, first replacing it if its connection has dropped
+func (p *sessionPool) acquireShared(ctx context.Context) (*sftpSession, error) {
+	p.sharedMutex.Lock()
+	defer p.sharedMutex.Unlock()
+
+	p.mutex.Lock()
+	session, err := p.last, p.err
+	if session != nil && session.conn.isDead() {
+		p.connectionLost(session.conn)
+		p.discardLocked(session)
+		p.last, session = nil, nil
+	}
+	p.mutex.Unlock()
+	if session != nil {
+		return session, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.open(ctx)
+}
+
+// release returns a session to the pool, closing it if its connection dropped.
+// The shared session stays in use; acquireShared replaces it once it is dead.
+func (p *sessionPool) release(session *sftpSession) {
+	if p.shared {
+		return
+	}
+	p.mutex.Lock()
+	if session.conn.isDead() {
+		p.discardLocked(session)
+	} else {
+		p.idle = append(p.idle, session)
+	}
+	p.mutex.Unlock()
+	<-p.slots
+}
+
+// check tells whether a failed attempt was down to a dropped connection. It
+// reports true for a session that is gone, which release then discards.
+func (p *sessionPool) check(session *sftpSession) (lost bool) {
+	if session.conn.alive(pingTimeout(p.config)) {
+		return false
+	}
+	p.connectionLost(session.conn)
+	return true
+}
+
+// open starts a new session on a connection with room for one, dialling a new
+// connection when there is none
+func (p *sessionPool) open(ctx context.Context) (*sftpSession, error) {
+	p.dialMutex.Lock()
+	defer p.dialMutex.Unlock()
+
+	p.mutex.Lock()
+	var conn *sftpConn
+	for _, c := range p.conns {
+		if !c.isDead() && c.sessions < p.perConn {
+			conn = c
+			break
+		}
+	}
+	p.mutex.Unlock()
+
+	if conn == nil {
+		var err error
+		if p.lost.Load() {
+			conn, err = p.reconnect(ctx)
+		} else {
+			conn, err = p.sync.connectSFTP(p.config)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.mutex.Lock()
+		p.conns = append(p.conns, conn)
+		p.mutex.Unlock()
+	}
+
+	client, err := sftp.NewClient(conn.ssh)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		if conn.sessions == 0 {
+			p.dropLocked(conn)
+		}
+		return nil, fmt.Errorf("failed to create SFTP client: %v", err)
+	}
+	conn.sessions++
+	session := &sftpSession{conn: conn, client: client}
+	p.last = session
+	return session, nil
+}
+
+// discardLocked closes a session, and its connection with the last one on it
+func (p *sessionPool) discardLocked(session *sftpSession) {
+	session.client.Close()
+	session.conn.sessions--
+	if session.conn.sessions <= 0 {
+		p.dropLocked(session.conn)
+	}
+}
+
+// dropLocked closes a connection and forgets it
+func (p *sessionPool) dropLocked(conn *sftpConn) {
+	conn.close()
+	p.conns = slices.DeleteFunc(p.conns, func(c *sftpConn) bool { return c == conn })
+}
+
+// client returns a live session's client for the work done outside the
+// transfer workers, such as scanning and mirror deletions, which shares one
+// session. It fails once every connection of the pool has dropped.
+func (p *sessionPool) client() (*sftp.Client, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i := len(p.idle) - 1; i >= 0; i-- {
+		if !p.idle[i].conn.isDead() {
+			return p.idle[i].client, nil
+		}
+	}
+	if p.last != nil && !p.last.conn.isDead() {
+		return p.last.client, nil
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return nil, &ConnectError{Server: p.side, Err: errors.New("connection lost")}
+}
+
+// close ends every session and connection of the pool
+func (p *sessionPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, session := range p.idle {
+		session.client.Close()
+	}
+	if p.shared && p.last != nil {
+		p.last.client.Close()
+	}
+	for _, conn := range p.conns {
+		conn.close()
+	}
+	p.idle, p.conns = nil, nil
+}
@@ -0,0 +1,443 @@
+package sftpsync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server on 127.0.0.1 that serves SFTP from
+// one in-memory file system to user "sync" with password "secret", and opens
+// TCP tunnels for jump host clients
+type testSSHServer struct {
+	addr     string
+	hostKey  ssh.PublicKey
+	listener net.Listener
+	files    sftp.Handlers
+
+	mutex    sync.Mutex
+	conns    []*ssh.ServerConn
+	sessions []int // SFTP sessions opened on each connection, in login order
+	tunnels  []*testTunnel
+}
+
+// testTunnel is a direct-tcpip channel the server opened to target
+type testTunnel struct {
+	target string
+	ended  bool
+	closed bool // the client closed the channel while its connection was still up
+}
+
+// lingerConn reports the end of a server connection only after a pause, so
+// that channels the client closed just before are seen to end while it is up
+type lingerConn struct {
+	net.Conn
+	lost atomic.Bool
+}
+
+func (c *lingerConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		time.Sleep(100 * time.Millisecond)
+		c.lost.Store(true)
+	}
+	return n, err
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if meta.User() == "sync" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testSSHServer{addr: listener.Addr().String(), hostKey: signer.PublicKey(), listener: listener, files: sftp.InMemHandler()}
+	t.Cleanup(func() {
+		srv.stop()
+		srv.dropConnections()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn, config)
+		}
+	}()
+	return srv
+}
+
+func (srv *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	linger := &lingerConn{Conn: conn}
+	serverConn, chans, reqs, err := ssh.NewServerConn(linger, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	srv.mutex.Lock()
+	index := len(srv.conns)
+	srv.conns = append(srv.conns, serverConn)
+	srv.sessions = append(srv.sessions, 0)
+	srv.mutex.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go srv.tunnel(newChannel, linger)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				if req.Type != "subsystem" || string(req.Payload[4:]) != "sftp" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				srv.mutex.Lock()
+				srv.sessions[index]++
+				srv.mutex.Unlock()
+				go func() {
+					server := sftp.NewRequestServer(channel, srv.files)
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// tunnel relays a direct-tcpip channel to its target until the client closes it
+func (srv *testSSHServer) tunnel(newChannel ssh.NewChannel, conn *lingerConn) {
+	var request struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &request); err != nil {
+		newChannel.Reject(ssh.Prohibited, "bad direct-tcpip request")
+		return
+	}
+	tunnel := &testTunnel{target: net.JoinHostPort(request.Host, strconv.Itoa(int(request.Port)))}
+	srv.mutex.Lock()
+	srv.tunnels = append(srv.tunnels, tunnel)
+	srv.mutex.Unlock()
+	end := func() {
+		srv.mutex.Lock()
+		tunnel.ended = true
+		tunnel.closed = !conn.lost.Load()
+		srv.mutex.Unlock()
+	}
+
+	target, err := net.Dial("tcp", tunnel.target)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		end()
+		return
+	}
+	defer target.Close()
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		end()
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	go io.Copy(channel, target)
+	io.Copy(target, channel)
+	end()
+}
+
+// openedTunnels waits until every tunnel opened so far has ended and returns them
+func (srv *testSSHServer) openedTunnels(t *testing.T) []testTunnel {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		srv.mutex.Lock()
+		var tunnels []testTunnel
+		ended := true
+		for _, tunnel := range srv.tunnels {
+			tunnels = append(tunnels, *tunnel)
+			ended = ended && tunnel.ended
+		}
+		srv.mutex.Unlock()
+		if ended {
+			return tunnels
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tunnels %+v are still open", tunnels)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// config returns the settings to log in to the server
+func (srv *testSSHServer) config() SFTPConfig {
+	host, port, _ := net.SplitHostPort(srv.addr)
+	p, _ := strconv.Atoi(port)
+	return SFTPConfig{
+		Host:                host,
+		Port:                p,
+		Username:            "sync",
+		Password:            "secret",
+		Timeout:             5 * time.Second,
+		HostKeyPolicy:       HostKeyPolicyFingerprint,
+		HostKeyFingerprints: []string{ssh.FingerprintSHA256(srv.hostKey)},
+	}
+}
+
+// openSessions returns the SFTP sessions opened on each connection so far
+func (srv *testSSHServer) openSessions() []int {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	return append([]int(nil), srv.sessions...)
+}
+
+// dropConnections ends every connection to the server, as a network failure would
+func (srv *testSSHServer) dropConnections() {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	for _, conn := range srv.conns {
+		conn.Close()
+	}
+}
+
+// stop refuses new connections
+func (srv *testSSHServer) stop() {
+	srv.listener.Close()
+}
+
+// waitDead waits until the client side has noticed that conn ended
+func waitDead(t *testing.T, conn *sftpConn) {
+	t.Helper()
+	select {
+	case <-conn.dead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not noticed to have dropped")
+	}
+}
+
+func testSessionPool(t *testing.T, config SFTPConfig) *sessionPool {
+	t.Helper()
+	clearProxyEnv(t)
+	s := NewSFTPSync(config, config, SyncConfig{ReconnectAttempts: 2, RetryDelay: 10 * time.Millisecond})
+	pool, err := newSessionPool(s, "source", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.close)
+	return pool
+}
+
+func TestSessionPoolSharedSession(t *testing.T) {
+	srv := newTestSSHServer(t)
+	pool := testSessionPool(t, srv.config())
+
+	// Without max_sessions, every worker gets the one session at once
+	var wg sync.WaitGroup
+	sessions := make(chan *sftpSession, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			session, err := pool.acquire(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sessions <- session
+		}()
+	}
+	wg.Wait()
+	close(sessions)
+
+	first := <-sessions
+	for session := range sessions {
+		if session != first {
+			t.Fatal("workers got different sessions")
+		}
+	}
+	if got := srv.openSessions(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("server saw sessions %v, want one connection with one session", got)
+	}
+}
+
+func TestSessionPoolSlots(t *testing.T) {
+	srv := newTestSSHServer(t)
+	config := srv.config()
+	config.MaxSessions = 2
+	pool := testSessionPool(t, config)
+
+	ctx := context.Background()
+	first, err := pool.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("two checkouts got the same session")
+	}
+
+	waiting, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.acquire(waiting); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("third checkout error = %v, want it to wait for a free slot", err)
+	}
+
+	pool.release(first)
+	third, err := pool.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third != first {
+		t.Error("released session was not reused")
+	}
+	if got := srv.openSessions(); len(got) != 2 {
+		t.Errorf("server saw connections %v, want 2 with one session each", got)
+	}
+}
+
+func TestSessionPoolSessionsPerConnection(t *testing.T) {
+	srv := newTestSSHServer(t)
+	config := srv.config()
+	config.MaxSessions = 5
+	config.SessionsPerConnection = 2
+	pool := testSessionPool(t, config)
+
+	for i := 0; i < 5; i++ {
+		if _, err := pool.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := srv.openSessions()
+	if len(got) != 3 || got[0] != 2 || got[1] != 2 || got[2] != 1 {
+		t.Errorf("sessions per connection = %v, want [2 2 1]", got)
+	}
+}
+
+func TestSessionPoolReconnects(t *testing.T) {
+	for _, maxSessions := range []int{0, 2} {
+		t.Run("max_sessions "+strconv.Itoa(maxSessions), func(t *testing.T) {
+			srv := newTestSSHServer(t)
+			config := srv.config()
+			config.MaxSessions = maxSessions
+			pool := testSessionPool(t, config)
+
+			session, err := pool.acquire(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.dropConnections()
+			waitDead(t, session.conn)
+			if !pool.check(session) {
+				t.Fatal("check() did not report the dropped connection")
+			}
+			pool.release(session)
+
+			replaced, err := pool.acquire(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replaced == session || replaced.conn.isDead() {
+				t.Fatal("dead session was handed out again")
+			}
+			if _, err := replaced.client.Getwd(); err != nil {
+				t.Errorf("replacement session does not work: %v", err)
+			}
+			if got := srv.openSessions(); len(got) != 2 {
+				t.Errorf("server saw connections %v, want the first and its replacement", got)
+			}
+		})
+	}
+}
+
+func TestSessionPoolGivesUp(t *testing.T) {
+	for _, maxSessions := range []int{0, 2} {
+		t.Run("max_sessions "+strconv.Itoa(maxSessions), func(t *testing.T) {
+			srv := newTestSSHServer(t)
+			config := srv.config()
+			config.MaxSessions = maxSessions
+			pool := testSessionPool(t, config)
+
+			session, err := pool.acquire(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.stop()
+			srv.dropConnections()
+			waitDead(t, session.conn)
+			pool.check(session)
+			pool.release(session)
+
+			// Every connection is gone but the pool has not tried to reconnect yet
+			if _, err := pool.client(); err == nil || !strings.Contains(err.Error(), "connection lost") {
+				t.Errorf("client() error = %v, want the connection to be lost", err)
+			}
+
+			_, err = pool.acquire(context.Background())
+			var connectErr *ConnectError
+			if !errors.As(err, &connectErr) || connectErr.Server != "source" {
+				t.Fatalf("acquire() error = %v, want a source ConnectError", err)
+			}
+			if strings.Count(err.Error(), "source") != 1 {
+				t.Errorf("error names the server more than once: %v", err)
+			}
+
+			// Later checkouts and the scan client fail the same way at once
+			if _, again := pool.acquire(context.Background()); again != err {
+				t.Errorf("second acquire() error = %v, want %v", again, err)
+			}
+			if _, clientErr := pool.client(); clientErr != err {
+				t.Errorf("client() error = %v, want %v", clientErr, err)
+			}
+			if got := srv.openSessions(); len(got) != 1 {
+				t.Errorf("server saw connections %v after it stopped listening", got)
+			}
+		})
+	}
+}
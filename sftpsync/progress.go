@@ -0,0 +1,195 @@
+package sftpsync
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Phases of a sync run, as reported in Progress.Phase and the phase events
+const (
+	PhaseConnecting   = "connecting"
+	PhaseScanning     = "scanning"
+	PhaseTransferring = "transferring"
+	PhaseDeleting     = "deleting"
+	PhaseDone         = "done"
+)
+
+// progressInterval limits how often progress within one phase is reported
+const progressInterval = 250 * time.Millisecond
+
+// Progress is a snapshot of how far a sync run has got. File counts apply to
+// the transferring and deleting phases, byte counts to transferring only; the
+// totals are those of the current plan (one date at a time in a backfill).
+// BytesDone includes files still in flight. When scanning, FilesTotal is the
+// number of date directories to read.
+type Progress struct {
+	Phase      string        `json:"phase"`
+	FilesDone  int           `json:"files_done"`
+	FilesTotal int           `json:"files_total"`
+	BytesDone  int64         `json:"bytes_done"`
+	BytesTotal int64         `json:"bytes_total"`
+	Rate       float64       `json:"rate"` // bytes per second since the phase started
+	ETA        time.Duration `json:"eta"`
+}
+
+// progressThrottle remembers the last report so that per-file updates are coalesced
+type progressThrottle struct {
+	mutex sync.Mutex
+	phase string
+	last  time.Time
+}
+
+// reportProgress emits p as an EventProgress. Phase changes and the final
+// update of a phase always go through; updates in between are limited to
+// progressInterval.
+func (s *SFTPSync) reportProgress(p Progress) {
+	s.progress.mutex.Lock()
+	now := time.Now()
+	if p.Phase == s.progress.phase && p.FilesDone < p.FilesTotal && now.Sub(s.progress.last) < progressInterval {
+		s.progress.mutex.Unlock()
+		return
+	}
+	s.progress.phase = p.Phase
+	s.progress.last = now
+	s.progress.mutex.Unlock()
+
+	s.emit(Event{Type: EventProgress, Phase: p.Phase, Progress: &p})
+}
+
+// transferTracker counts a transfer phase as workers make progress
+type transferTracker struct {
+	filesDone  int64
+	bytesDone  int64
+	filesTotal int
+	bytesTotal int64
+	started    time.Time
+
+	mutex    sync.Mutex
+	attempts map[string]int64 // bytes counted for the current attempt at each file
+}
+
+func newTransferTracker(files []PlannedFile) *transferTracker {
+	t := &transferTracker{filesTotal: len(files), started: time.Now(), attempts: make(map[string]int64)}
+	for _, file := range files {
+		t.bytesTotal += file.Size
+	}
+	return t
+}
+
+// startAttempt takes back the bytes counted by an earlier attempt at file, so
+// that a retry which starts over is not counted twice
+func (t *transferTracker) startAttempt(file string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	atomic.AddInt64(&t.bytesDone, -t.attempts[file])
+	t.attempts[file] = 0
+}
+
+// addBytes counts n more bytes of file's current attempt, including the part
+// of a temp file that a resumed attempt keeps
+func (t *transferTracker) addBytes(file string, n int64) {
+	t.mutex.Lock()
+	t.attempts[file] += n
+	t.mutex.Unlock()
+	atomic.AddInt64(&t.bytesDone, n)
+}
+
+func (t *transferTracker) fileFinished(file string) {
+	t.mutex.Lock()
+	delete(t.attempts, file)
+	t.mutex.Unlock()
+	atomic.AddInt64(&t.filesDone, 1)
+}
+
+// progress builds the Progress of the phase so far
+func (t *transferTracker) progress() Progress {
+	p := Progress{
+		Phase:      PhaseTransferring,
+		FilesDone:  int(atomic.LoadInt64(&t.filesDone)),
+		FilesTotal: t.filesTotal,
+		BytesDone:  atomic.LoadInt64(&t.bytesDone),
+		BytesTotal: t.bytesTotal,
+	}
+	elapsed := time.Since(t.started)
+	if elapsed > 0 {
+		p.Rate = float64(p.BytesDone) / elapsed.Seconds()
+	}
+	if p.FilesDone > 0 && p.FilesDone < p.FilesTotal {
+		p.ETA = time.Duration(float64(elapsed) * float64(p.FilesTotal-p.FilesDone) / float64(p.FilesDone)).Round(time.Second)
+	}
+	return p
+}
+
+// ProgressLogger turns engine events into the periodic progress lines of the
+// command line tool. Subscribe its Handle method to a sync:
+//
+//	syncer.Subscribe(sftpsync.NewProgressLogger(3 * time.Second).Handle)
+type ProgressLogger struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	last     time.Time
+	started  time.Time
+	dirs     int
+	dirTotal int
+	files    int
+}
+
+// NewProgressLogger logs at most one progress line per interval
+func NewProgressLogger(interval time.Duration) *ProgressLogger {
+	return &ProgressLogger{interval: interval}
+}
+
+// Handle is an EventHandler
+func (l *ProgressLogger) Handle(e Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	switch e.Type {
+	case EventPhaseStarted:
+		l.last = now
+		if e.Phase == PhaseScanning {
+			l.started = now
+			l.dirs, l.dirTotal, l.files = 0, e.Files, 0
+		}
+
+	case EventDirectoryScanned:
+		l.dirs++
+		l.files += e.Files
+		if now.Sub(l.last) < l.interval {
+			return
+		}
+		l.last = now
+
+		progress := 0.0
+		if l.dirTotal > 0 {
+			progress = float64(l.dirs) / float64(l.dirTotal) * 100
+		}
+		elapsed := now.Sub(l.started)
+		eta := time.Duration(float64(elapsed) * float64(l.dirTotal-l.dirs) / float64(l.dirs))
+		log.Printf("📊 Building Graph [%.1f%%] %d/%d dirs | Files: %d (%.1f/s) | %s",
+			progress, l.dirs, l.dirTotal, l.files, float64(l.files)/elapsed.Seconds(), formatETA(eta))
+
+	case EventProgress:
+		p := e.Progress
+		if p.Phase != PhaseTransferring || p.FilesTotal == 0 || now.Sub(l.last) < l.interval {
+			return
+		}
+		l.last = now
+
+		log.Printf("🚀 Syncing Files [%.1f%%] %d/%d files | %s transferred | %s | %s",
+			float64(p.FilesDone)/float64(p.FilesTotal)*100, p.FilesDone, p.FilesTotal,
+			formatBytes(p.BytesDone), formatRate(p.Rate), formatETA(p.ETA))
+	}
+}
+
+// formatETA renders the estimated time left for a progress line
+func formatETA(eta time.Duration) string {
+	if eta <= 0 {
+		return "ETA: calculating..."
+	}
+	return fmt.Sprintf("ETA: %s", eta.Round(time.Second))
+}
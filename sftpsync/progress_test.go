@@ -0,0 +1,83 @@
+package sftpsync
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransferTrackerAttempts(t *testing.T) {
+	type step struct {
+		start bool // a new attempt at the file starts
+		bytes int64
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  int64
+	}{
+		{"one attempt", []step{{true, 0}, {false, 60}, {false, 40}}, 100},
+		{"retry starting over", []step{{true, 0}, {false, 70}, {true, 0}, {false, 100}}, 100},
+		{"retry resuming", []step{{true, 0}, {false, 70}, {true, 0}, {false, 70}, {false, 30}}, 100},
+		{"resumed from an earlier run", []step{{true, 0}, {false, 40}, {false, 60}}, 100},
+		{"failed attempt before any bytes", []step{{true, 0}, {true, 0}, {false, 100}}, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newTransferTracker([]PlannedFile{{Path: "/src/a.csv", Size: 100}, {Path: "/src/b.csv", Size: 50}})
+			tracker.startAttempt("/src/b.csv")
+			tracker.addBytes("/src/b.csv", 50)
+
+			for _, s := range tt.steps {
+				if s.start {
+					tracker.startAttempt("/src/a.csv")
+				}
+				tracker.addBytes("/src/a.csv", s.bytes)
+			}
+			tracker.fileFinished("/src/a.csv")
+			tracker.fileFinished("/src/b.csv")
+
+			p := tracker.progress()
+			if p.BytesDone != tt.want+50 {
+				t.Errorf("BytesDone = %d, want %d", p.BytesDone, tt.want+50)
+			}
+			if p.FilesDone != 2 || p.BytesTotal != 150 {
+				t.Errorf("progress = %+v, want 2 files and 150 bytes in total", p)
+			}
+		})
+	}
+}
+
+func TestTransferAttemptCountsResumedBytes(t *testing.T) {
+	sourceClient, destClient := testSFTPClient(t), testSFTPClient(t)
+	content := strings.Repeat("0123456789", 10)
+	writeRemote(t, sourceClient, "/a.csv", content)
+
+	file := &FileInfo{Path: "/a.csv", RelativePath: "a.csv", Size: int64(len(content)), ModTime: time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)}
+	s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{ChunkSize: 16, VerifyTransfers: true})
+
+	// A previous run left the first 40 bytes behind
+	writeRemote(t, destClient, "/a.csv.tmp", content[:40])
+	s.writeResumeSidecar(destClient, file, "/a.csv.tmp")
+
+	tracker := newTransferTracker([]PlannedFile{{Path: file.Path, Size: file.Size}})
+	tracker.startAttempt(file.Path)
+	tracker.addBytes(file.Path, 25) // an attempt that failed part way
+	tracker.startAttempt(file.Path)
+	if _, err := s.transferAttempt(sourceClient, destClient, file, "/a.csv", tracker); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := tracker.progress(); p.BytesDone != file.Size {
+		t.Errorf("BytesDone = %d, want %d", p.BytesDone, file.Size)
+	}
+	copied, err := destClient.Open("/a.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	if data, _ := io.ReadAll(copied); string(data) != content {
+		t.Errorf("destination holds %q, want %q", data, content)
+	}
+}
@@ -0,0 +1,296 @@
+package sftpsync
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyDirect as an endpoint's proxy connects directly even when ALL_PROXY is set
+const ProxyDirect = "direct"
+
+// socksReplies describes the SOCKS5 reply codes (RFC 1928)
+var socksReplies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// ValidateProxy checks that an endpoint's proxy URL, or ALL_PROXY when it has
+// none, is one the tool can dial through
+func ValidateProxy(config SFTPConfig) error {
+	_, err := parseProxy(config)
+	return err
+}
+
+// parseProxy returns the proxy that applies to the endpoint: its own setting,
+// else ALL_PROXY. It is nil for a direct connection.
+func parseProxy(config SFTPConfig) (*url.URL, error) {
+	raw, source := config.Proxy, "proxy"
+	if raw == "" {
+		raw, source = os.Getenv("ALL_PROXY"), "ALL_PROXY"
+		if raw == "" {
+			raw, source = os.Getenv("all_proxy"), "all_proxy"
+		}
+	}
+	if raw == "" || strings.EqualFold(raw, ProxyDirect) {
+		return nil, nil
+	}
+
+	proxy, err := url.Parse(raw)
+	if err != nil || proxy.Host == "" {
+		return nil, fmt.Errorf("%s is not a valid URL (expected socks5://host:port or http://host:port)", source)
+	}
+	switch proxy.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("%s scheme %q is not supported (use socks5 or http)", source, proxy.Scheme)
+	}
+	if config.ProxyPassword != "" {
+		username := ""
+		if proxy.User != nil {
+			username = proxy.User.Username()
+		}
+		proxy.User = url.UserPassword(username, config.ProxyPassword)
+	}
+	return proxy, nil
+}
+
+// dialTCP opens the TCP connection to addr, through the endpoint's proxy
+// unless NO_PROXY exempts the host
+func dialTCP(config SFTPConfig, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	proxy, err := parseProxy(config)
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if proxy == nil || noProxy(host) {
+		return dialer.Dial("tcp", addr)
+	}
+
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		port := "1080"
+		if proxy.Scheme == "http" {
+			port = "8080"
+		}
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), port)
+	}
+	conn, err := dialer.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach proxy %s: %v", proxyAddr, err)
+	}
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+
+	if proxy.Scheme == "http" {
+		conn, err = httpConnect(conn, proxy, addr)
+	} else {
+		err = socks5Connect(conn, proxy, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s could not connect to %s: %v", proxyAddr, addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// noProxy reports whether NO_PROXY lists host: "*", the host or IP itself, a
+// domain it belongs to (with or without a leading dot), or a CIDR range it is in
+func noProxy(host string) bool {
+	list := os.Getenv("NO_PROXY")
+	if list == "" {
+		list = os.Getenv("no_proxy")
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.Trim(entry, "[]")
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// socks5Connect asks a SOCKS5 server to connect to addr, logging in with the
+// URL's credentials when it has some. The server resolves the host name.
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portText)
+	}
+
+	methods := []byte{0x00}
+	if proxy.User != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("not a SOCKS5 server")
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("proxy username or password is too long")
+		}
+		login := []byte{0x01, byte(len(username))}
+		login = append(login, username...)
+		login = append(login, byte(len(password)))
+		login = append(login, password...)
+		if _, err := conn.Write(login); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("proxy login failed")
+		}
+	default:
+		if proxy.User == nil {
+			return fmt.Errorf("proxy requires a login; add user:password to the proxy URL")
+		}
+		return fmt.Errorf("proxy accepts none of the offered login methods")
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip.To4() != nil {
+		request = append(append(request, 0x01), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, 0x04), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name is too long")
+		}
+		request = append(append(request, 0x03, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		if message, ok := socksReplies[header[1]]; ok {
+			return fmt.Errorf("%s", message)
+		}
+		return fmt.Errorf("SOCKS5 error %d", header[1])
+	}
+
+	// Skip the bound address that follows
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("unexpected SOCKS5 address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// httpConnect opens a tunnel to addr with an HTTP CONNECT request, sending
+// the URL's credentials as Basic proxy authorization
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		return conn, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return conn, err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusProxyAuthRequired && proxy.User == nil {
+		return conn, fmt.Errorf("proxy requires a login; add user:password to the proxy URL")
+	}
+	if response.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("proxy answered %s", response.Status)
+	}
+
+	// The SSH server speaks first, so its banner may already be buffered
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads what a bufio.Reader already holds before the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,415 @@
+package sftpsync
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testBanner = "SSH-2.0-test\r\n"
+
+// testTarget listens on 127.0.0.1 and greets every connection with
+// testBanner, as an SSH server speaks first
+func testTarget(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(testBanner))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// testProxy is an in-process proxy on 127.0.0.1 recording the addresses it
+// was asked to connect to
+type testProxy struct {
+	addr     string
+	mutex    sync.Mutex
+	requests []string
+}
+
+func (p *testProxy) requested() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.requests...)
+}
+
+func (p *testProxy) record(addr string) {
+	p.mutex.Lock()
+	p.requests = append(p.requests, addr)
+	p.mutex.Unlock()
+}
+
+func startTestProxy(t *testing.T, serve func(p *testProxy, conn net.Conn)) *testProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	p := &testProxy{addr: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(p, conn)
+			}()
+		}
+	}()
+	return p
+}
+
+// relay connects conn to addr and copies between them until either side closes
+func relay(conn net.Conn, addr string) {
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// testSOCKS5Proxy answers CONNECT requests with reply, requiring a
+// username/password login when username is set
+func testSOCKS5Proxy(t *testing.T, username, password string, reply byte) *testProxy {
+	return startTestProxy(t, func(p *testProxy, conn net.Conn) {
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return
+		}
+		methods := make([]byte, greeting[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return
+		}
+		want := byte(0x00)
+		if username != "" {
+			want = 0x02
+		}
+		if !strings.ContainsRune(string(methods), rune(want)) {
+			conn.Write([]byte{0x05, 0xff})
+			return
+		}
+		conn.Write([]byte{0x05, want})
+
+		if username != "" {
+			header := make([]byte, 2)
+			io.ReadFull(conn, header)
+			user := make([]byte, header[1])
+			io.ReadFull(conn, user)
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			pass := make([]byte, length[0])
+			io.ReadFull(conn, pass)
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{0x01, 0x01})
+				return
+			}
+			conn.Write([]byte{0x01, 0x00})
+		}
+
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		var host string
+		switch request[3] {
+		case 0x01, 0x04:
+			ip := make([]byte, net.IPv4len)
+			if request[3] == 0x04 {
+				ip = make([]byte, net.IPv6len)
+			}
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case 0x03:
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			name := make([]byte, length[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+		addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		p.record(addr)
+
+		conn.Write([]byte{0x05, reply, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+		if reply == 0x00 {
+			relay(conn, strings.Replace(addr, "localhost", "127.0.0.1", 1))
+		}
+	})
+}
+
+// testHTTPProxy answers CONNECT requests with status, requiring Basic
+// credentials when username is set
+func testHTTPProxy(t *testing.T, username, password string, status int) *testProxy {
+	return startTestProxy(t, func(p *testProxy, conn net.Conn) {
+		request, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || request.Method != http.MethodConnect {
+			return
+		}
+		p.record(request.Host)
+
+		if username != "" {
+			want := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+			if request.Header.Get("Proxy-Authorization") != want {
+				status = http.StatusProxyAuthRequired
+			}
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+		if status == http.StatusOK {
+			relay(conn, request.Host)
+		}
+	})
+}
+
+// readBanner reads the target's greeting through conn
+func readBanner(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || banner != testBanner {
+		t.Errorf("read %q (%v) through the proxy, want %q", banner, err, testBanner)
+	}
+}
+
+// clearProxyEnv keeps the environment's proxy settings out of a test
+func clearProxyEnv(t *testing.T) {
+	for _, name := range []string{"ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestDialTCPThroughSOCKS5(t *testing.T) {
+	clearProxyEnv(t)
+	target := testTarget(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	tests := []struct {
+		name          string
+		user          string // required by the proxy
+		pass          string
+		reply         byte
+		proxyUser     string // in the proxy URL
+		proxyPassword string // from proxy_password_env
+		addr          string
+		wantErr       string
+	}{
+		{name: "no login", addr: target},
+		{name: "login", user: "alice", pass: "s3cret", proxyUser: "alice:s3cret@", addr: target},
+		{name: "password from the environment", user: "alice", pass: "s3cret", proxyUser: "alice@", proxyPassword: "s3cret", addr: target},
+		{name: "proxy resolves the host name", addr: net.JoinHostPort("localhost", port)},
+		{name: "wrong password", user: "alice", pass: "s3cret", proxyUser: "alice:guess@", addr: target, wantErr: "proxy login failed"},
+		{name: "login required", user: "alice", pass: "s3cret", addr: target, wantErr: "proxy requires a login"},
+		{name: "connection refused", reply: 5, addr: target, wantErr: "connection refused"},
+		{name: "not allowed", reply: 2, addr: target, wantErr: "connection not allowed by ruleset"},
+		{name: "unknown error", reply: 0x42, addr: target, wantErr: "SOCKS5 error 66"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := testSOCKS5Proxy(t, tt.user, tt.pass, tt.reply)
+			config := SFTPConfig{
+				Proxy:         "socks5://" + tt.proxyUser + proxy.addr,
+				ProxyPassword: tt.proxyPassword,
+				Timeout:       5 * time.Second,
+			}
+
+			conn, err := dialTCP(config, tt.addr)
+			if tt.wantErr != "" {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("dialTCP() succeeded, want an error containing %q", tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("dialTCP() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			readBanner(t, conn)
+			if got := proxy.requested(); len(got) != 1 || got[0] != tt.addr {
+				t.Errorf("proxy was asked for %v, want [%s]", got, tt.addr)
+			}
+		})
+	}
+}
+
+func TestDialTCPThroughHTTPConnect(t *testing.T) {
+	clearProxyEnv(t)
+	target := testTarget(t)
+
+	tests := []struct {
+		name      string
+		user      string
+		pass      string
+		status    int
+		proxyUser string
+		wantErr   string
+	}{
+		{name: "no login", status: http.StatusOK},
+		{name: "login", user: "alice", pass: "p@ss:word", status: http.StatusOK, proxyUser: "alice:p%40ss%3Aword@"},
+		{name: "wrong password", user: "alice", pass: "s3cret", status: http.StatusOK, proxyUser: "alice:guess@", wantErr: "proxy answered 407 Proxy Authentication Required"},
+		{name: "login required", user: "alice", pass: "s3cret", status: http.StatusOK, wantErr: "proxy requires a login"},
+		{name: "forbidden", status: http.StatusForbidden, wantErr: "proxy answered 403 Forbidden"},
+		{name: "bad gateway", status: http.StatusBadGateway, wantErr: "proxy answered 502 Bad Gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := testHTTPProxy(t, tt.user, tt.pass, tt.status)
+			config := SFTPConfig{Proxy: "http://" + tt.proxyUser + proxy.addr, Timeout: 5 * time.Second}
+
+			conn, err := dialTCP(config, target)
+			if tt.wantErr != "" {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("dialTCP() succeeded, want an error containing %q", tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("dialTCP() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			readBanner(t, conn)
+			if got := proxy.requested(); len(got) != 1 || got[0] != target {
+				t.Errorf("proxy was asked for %v, want [%s]", got, target)
+			}
+		})
+	}
+}
+
+func TestDialTCPAllProxy(t *testing.T) {
+	target := testTarget(t)
+
+	tests := []struct {
+		name        string
+		endpoint    string // the endpoint's own proxy setting
+		noProxy     string
+		wantProxied bool
+	}{
+		{name: "ALL_PROXY applies", wantProxied: true},
+		{name: "NO_PROXY lists the address", noProxy: "example.com, 127.0.0.1", wantProxied: false},
+		{name: "NO_PROXY lists another host", noProxy: "example.com,10.0.0.0/8", wantProxied: true},
+		{name: "NO_PROXY range", noProxy: "127.0.0.0/8", wantProxied: false},
+		{name: "NO_PROXY star", noProxy: "*", wantProxied: false},
+		{name: "direct overrides ALL_PROXY", endpoint: ProxyDirect, wantProxied: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := testSOCKS5Proxy(t, "", "", 0)
+			clearProxyEnv(t)
+			t.Setenv("ALL_PROXY", "socks5h://"+proxy.addr)
+			t.Setenv("NO_PROXY", tt.noProxy)
+
+			conn, err := dialTCP(SFTPConfig{Proxy: tt.endpoint, Timeout: 5 * time.Second}, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readBanner(t, conn)
+			if proxied := len(proxy.requested()) > 0; proxied != tt.wantProxied {
+				t.Errorf("went through the proxy: %v, want %v", proxied, tt.wantProxied)
+			}
+		})
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	tests := []struct {
+		list string
+		host string
+		want bool
+	}{
+		{"", "sftp.example.com", false},
+		{"*", "sftp.example.com", true},
+		{"sftp.example.com", "sftp.example.com", true},
+		{"SFTP.Example.com", "sftp.example.com", true},
+		{"example.com", "sftp.example.com", true},
+		{".example.com", "sftp.example.com", true},
+		{".example.com", "example.com", true},
+		{"example.com", "badexample.com", false},
+		{"ample.com", "sftp.example.com", false},
+		{"sftp.example.com:22", "sftp.example.com", true}, // the port is ignored
+		{"example.org, sftp.example.com:2222", "sftp.example.com", true},
+		{"10.1.2.3", "10.1.2.3", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "192.168.1.1", false},
+		{"10.0.0.0/8", "ten.example.com", false},
+		{"2001:db8::/32", "[2001:db8::1]", true},
+		{"[2001:db8::1]:22", "2001:db8::1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list+" "+tt.host, func(t *testing.T) {
+			clearProxyEnv(t)
+			t.Setenv("no_proxy", tt.list)
+			if got := noProxy(tt.host); got != tt.want {
+				t.Errorf("noProxy(%q) with NO_PROXY=%q = %v, want %v", tt.host, tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   SFTPConfig
+		allProxy string
+		want     string
+		wantErr  bool
+	}{
+		{name: "none", want: ""},
+		{name: "endpoint proxy", config: SFTPConfig{Proxy: "socks5://proxy.example.com:1080"}, want: "socks5://proxy.example.com:1080"},
+		{name: "ALL_PROXY", allProxy: "http://proxy.example.com:3128", want: "http://proxy.example.com:3128"},
+		{name: "endpoint proxy wins", config: SFTPConfig{Proxy: "socks5h://mine:1080"}, allProxy: "http://proxy.example.com:3128", want: "socks5h://mine:1080"},
+		{name: "direct", config: SFTPConfig{Proxy: "DIRECT"}, allProxy: "http://proxy.example.com:3128", want: ""},
+		{name: "credentials in the URL", config: SFTPConfig{Proxy: "socks5://bob:pw@proxy.example.com"}, want: "socks5://bob:pw@proxy.example.com"},
+		{name: "password from the environment", config: SFTPConfig{Proxy: "http://bob@proxy.example.com", ProxyPassword: "pw"}, want: "http://bob:pw@proxy.example.com"},
+		{name: "unsupported scheme", config: SFTPConfig{Proxy: "https://proxy.example.com"}, wantErr: true},
+		{name: "no host", config: SFTPConfig{Proxy: "proxy.example.com:1080"}, wantErr: true},
+		{name: "bad ALL_PROXY", allProxy: "socks4://proxy.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			t.Setenv("all_proxy", tt.allProxy)
+			proxy, err := parseProxy(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProxy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := ""
+			if proxy != nil {
+				got = proxy.String()
+			}
+			if got != tt.want {
+				t.Errorf("parseProxy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package sftpsync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultReconnectAttempts is how often a lost connection is re-dialled when
+// reconnect_attempts is not set
+const DefaultReconnectAttempts = 5
+
+// maxReconnectDelay caps the backoff between reconnect attempts
+const maxReconnectDelay = time.Minute
+
+// sftpConn is one SSH connection to a server, carrying one or more SFTP sessions
+type sftpConn struct {
+	ssh   *ssh.Client
+	jumps []*ssh.Client // jump hosts the connection is tunnelled through
+	host  string
+
+	// dead is closed once the SSH connection has ended, whether the server
+	// went away, a keepalive went unanswered or we closed it ourselves
+	dead chan struct{}
+
+	sessions int // open SFTP sessions, guarded by the owning pool's mutex
+	lostOnce sync.Once
+}
+
+// newSFTPConn watches sshClient so that dead is closed when it ends, taking
+// its jump hosts down with it, and starts the keepalive when interval is set
+func newSFTPConn(sshClient *ssh.Client, jumps []*ssh.Client, host string, interval time.Duration) *sftpConn {
+	conn := &sftpConn{ssh: sshClient, jumps: jumps, host: host, dead: make(chan struct{})}
+	go func() {
+		sshClient.Wait()
+		closeJumpHosts(jumps)
+		close(conn.dead)
+	}()
+	if interval > 0 {
+		go conn.keepAlive(interval)
+	}
+	return conn
+}
+
+// keepAlive pings the server every interval until the connection ends. A
+// ping that fails or goes unanswered for a whole interval closes the
+// connection, so a silently dropped link is noticed instead of hanging transfers.
+func (c *sftpConn) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.dead:
+			return
+		case <-ticker.C:
+		}
+		if err := c.ping(interval); err != nil {
+			log.Printf("⚠️  Keepalive to %s failed, closing the connection: %v", c.host, err)
+			c.close()
+			return
+		}
+	}
+}
+
+// ping sends an SSH keepalive request and waits up to timeout for the answer
+func (c *sftpConn) ping(timeout time.Duration) error {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := c.ssh.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+
+	select {
+	case err := <-reply:
+		return err
+	case <-c.dead:
+		return fmt.Errorf("connection closed")
+	case <-time.After(timeout):
+		return fmt.Errorf("no answer within %v", timeout)
+	}
+}
+
+// isDead reports whether the connection is known to have ended
+func (c *sftpConn) isDead() bool {
+	select {
+	case <-c.dead:
+		return true
+	default:
+		return false
+	}
+}
+
+// alive reports whether the connection still works. An SFTP error alone does
+// not tell a missing file from a dropped link, so a connection that is not
+// known to be dead is pinged; if that fails it is closed for good.
+func (c *sftpConn) alive(timeout time.Duration) bool {
+	if c.isDead() {
+		return false
+	}
+	if err := c.ping(timeout); err != nil {
+		c.close()
+		return false
+	}
+	return true
+}
+
+// close ends the SSH connection and every SFTP session on it
+func (c *sftpConn) close() {
+	c.ssh.Close()
+}
+
+// connectionLost logs and reports a dropped connection, once per connection,
+// and makes the pool re-dial with backoff from now on
+func (p *sessionPool) connectionLost(conn *sftpConn) {
+	conn.lostOnce.Do(func() {
+		p.lost.Store(true)
+		log.Printf("⚠️  Lost connection to %s SFTP server %s", p.side, conn.host)
+		p.sync.emit(Event{Type: EventConnectionLost, Side: p.side})
+	})
+}
+
+// reconnect dials a replacement for a lost connection with exponential backoff
+// starting at retry_delay. Once it gives up, every later checkout from the pool
+// fails with the same error, since the rest of the queue would fail the same way.
+func (p *sessionPool) reconnect(ctx context.Context) (*sftpConn, error) {
+	attempts := p.sync.SyncConfig.ReconnectAttempts
+	if attempts == 0 {
+		attempts = DefaultReconnectAttempts
+	}
+	delay := p.sync.SyncConfig.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		log.Printf("Reconnecting to %s SFTP server (attempt %d/%d)...", p.side, attempt, attempts)
+		conn, err := p.sync.connectSFTP(p.config)
+		if err == nil {
+			p.lost.Store(false)
+			log.Printf("✅ Reconnected to %s SFTP server, continuing with the remaining files", p.side)
+			return conn, nil
+		}
+		lastErr = err
+		log.Printf("Reconnect to %s SFTP server failed: %v", p.side, err)
+
+		if attempt < attempts {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, maxReconnectDelay)
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("connection lost and reconnecting is disabled")
+	}
+	err := &ConnectError{Server: p.side, Err: lastErr}
+	p.mutex.Lock()
+	p.err = err
+	p.mutex.Unlock()
+	return nil, err
+}
+
+// pingTimeout is how long a liveness check waits for the server to answer
+func pingTimeout(config SFTPConfig) time.Duration {
+	if config.KeepAlive > 0 {
+		return config.KeepAlive
+	}
+	if config.Timeout > 0 {
+		return config.Timeout
+	}
+	return 30 * time.Second
+}
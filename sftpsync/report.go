@@ -0,0 +1,123 @@
+package sftpsync
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Exit codes of the command line front ends, so that schedulers and scripts
+// can tell a partial failure from a clean run
+const (
+	ExitSuccess    = 0 // every file is up to date
+	ExitFailed     = 1 // the run failed for any other reason
+	ExitConfig     = 2 // the configuration or command line is invalid
+	ExitPartial    = 3 // the run finished but some files failed
+	ExitConnection = 4 // a server could not be reached or refused the login
+	ExitCancelled  = 5 // the run was interrupted by Ctrl+C or SIGTERM
+)
+
+// ExitCode maps the outcome of a run to one of the Exit* codes. run may be nil
+// when the sync never started.
+func ExitCode(run *RunRecord, err error) int {
+	var connectErr *ConnectError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ExitCancelled
+	case errors.As(err, &connectErr):
+		return ExitConnection
+	case err != nil:
+		return ExitFailed
+	case run != nil && run.Result == ResultPartial:
+		return ExitPartial
+	default:
+		return ExitSuccess
+	}
+}
+
+// RunReport is the machine-readable summary of a run written by -report
+type RunReport struct {
+	RunID       string         `json:"run_id"`
+	Result      string         `json:"result"`
+	ExitCode    int            `json:"exit_code"`
+	Error       string         `json:"error,omitempty"`
+	Trigger     string         `json:"trigger"`
+	ConfigHash  string         `json:"config_hash"`
+	Source      string         `json:"source"`
+	Destination string         `json:"destination"`
+	Dates       string         `json:"dates,omitempty"`
+	Started     time.Time      `json:"started"`
+	Finished    time.Time      `json:"finished"`
+	Stats       *StatsSnapshot `json:"stats,omitempty"`
+	FailedFiles []FileRecord   `json:"failed_files"`
+}
+
+// NewRunReport summarises a finished run
+func NewRunReport(run *RunRecord, err error) *RunReport {
+	report := &RunReport{
+		RunID:       run.ID,
+		Result:      run.Result,
+		ExitCode:    ExitCode(run, err),
+		Error:       run.Error,
+		Trigger:     run.Trigger,
+		ConfigHash:  run.ConfigHash,
+		Source:      run.Source,
+		Destination: run.Dest,
+		Dates:       run.Dates,
+		Started:     run.Started,
+		Finished:    run.Finished,
+		Stats:       run.Summary,
+		FailedFiles: []FileRecord{},
+	}
+	if report.Error == "" && err != nil {
+		report.Error = err.Error()
+	}
+	for _, f := range run.Files {
+		if f.Outcome == FileFailed {
+			report.FailedFiles = append(report.FailedFiles, f)
+		}
+	}
+	return report
+}
+
+// WriteJSON writes the report to a JSON file
+func (r *RunReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	return nil
+}
+
+// WriteTransfersCSV writes one row per file the run transferred: path, size in
+// bytes, duration in milliseconds and MD5 (empty unless verify_transfers is on)
+func (r *RunRecord) WriteTransfersCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write transfers file: %w", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"path", "size", "duration_ms", "md5"})
+	for _, f := range r.Files {
+		if f.Outcome != FileTransferred {
+			continue
+		}
+		writer.Write([]string{f.Path, strconv.FormatInt(f.Size, 10), strconv.FormatInt(f.Duration.Milliseconds(), 10), f.Hash})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write transfers file: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,143 @@
+package sftpsync
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		run  *RunRecord
+		err  error
+		want int
+	}{
+		{"success", &RunRecord{Result: ResultSuccess}, nil, ExitSuccess},
+		{"never started", nil, nil, ExitSuccess},
+		{"partial", &RunRecord{Result: ResultPartial}, nil, ExitPartial},
+		{"cancelled", &RunRecord{Result: ResultCancelled}, context.Canceled, ExitCancelled},
+		{"cancelled while wrapped", nil, fmt.Errorf("sync failed: %w", context.Canceled), ExitCancelled},
+		{"connection", nil, &ConnectError{Server: "source", Err: errors.New("refused")}, ExitConnection},
+		{"connection while wrapped", &RunRecord{Result: ResultFailed}, fmt.Errorf("backfill: %w", &ConnectError{Server: "destination", Err: errors.New("refused")}), ExitConnection},
+		{"other error", &RunRecord{Result: ResultFailed}, errors.New("disk full"), ExitFailed},
+		{"error wins over partial", &RunRecord{Result: ResultPartial}, errors.New("disk full"), ExitFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.run, tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// testRun is a finished run with one file of each outcome
+func testRun() *RunRecord {
+	started := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	return &RunRecord{
+		ID:         "20261016-090000-abcd",
+		Trigger:    "cli",
+		ConfigHash: "c0ffee",
+		Source:     "sync@source.example.com:/out",
+		Dest:       "sync@dest.example.com:/in",
+		Started:    started,
+		Finished:   started.Add(time.Minute),
+		Result:     ResultPartial,
+		Summary:    &StatsSnapshot{},
+		Files: []FileRecord{
+			{Path: "20261016/a.csv", Outcome: FileTransferred, Size: 1024, Duration: 1500 * time.Millisecond, Hash: "900150983cd24fb0d6963f7d28e17f72"},
+			{Path: "20261016/b,with comma.csv", Outcome: FileTransferred, Size: 5, Duration: 20 * time.Millisecond},
+			{Path: "20261016/c.csv", Outcome: FileFailed, Size: 7, Error: "permission denied"},
+			{Path: "20261015/old.csv", Outcome: FileDeleted, Size: 3},
+		},
+	}
+}
+
+func TestNewRunReport(t *testing.T) {
+	run := testRun()
+	report := NewRunReport(run, nil)
+
+	if report.RunID != run.ID || report.Result != ResultPartial || report.ExitCode != ExitPartial ||
+		report.Destination != run.Dest || report.Stats != run.Summary || report.Error != "" {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.FailedFiles) != 1 || report.FailedFiles[0].Path != "20261016/c.csv" {
+		t.Errorf("failed files = %+v, want only c.csv", report.FailedFiles)
+	}
+
+	// The run's own error is kept; the returned error only fills in a missing one
+	failed := &RunRecord{Result: ResultFailed, Error: "source scan failed"}
+	if got := NewRunReport(failed, errors.New("other")).Error; got != "source scan failed" {
+		t.Errorf("error = %q, want the run's", got)
+	}
+	failed.Error = ""
+	report = NewRunReport(failed, &ConnectError{Server: "source", Err: errors.New("refused")})
+	if report.Error != "failed to connect to source SFTP: refused" || report.ExitCode != ExitConnection {
+		t.Errorf("report = %+v", report)
+	}
+	if report.FailedFiles == nil {
+		t.Error("failed files is nil, want an empty list so the JSON has []")
+	}
+}
+
+func TestRunReportWriteJSON(t *testing.T) {
+	report := NewRunReport(testRun(), nil)
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := report.WriteJSON(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written RunReport
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("report file is not valid JSON: %v", err)
+	}
+	if written.RunID != report.RunID || written.ExitCode != ExitPartial || !written.Started.Equal(report.Started) ||
+		!reflect.DeepEqual(written.FailedFiles, report.FailedFiles) {
+		t.Errorf("written report = %+v, want %+v", written, report)
+	}
+
+	if err := report.WriteJSON(filepath.Join(t.TempDir(), "missing", "report.json")); err == nil {
+		t.Error("WriteJSON() into a missing directory succeeded")
+	}
+}
+
+func TestWriteTransfersCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transfers.csv")
+	if err := testRun().WriteTransfersCSV(path); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"path", "size", "duration_ms", "md5"},
+		{"20261016/a.csv", "1024", "1500", "900150983cd24fb0d6963f7d28e17f72"},
+		{"20261016/b,with comma.csv", "5", "20", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	if err := testRun().WriteTransfersCSV(filepath.Join(t.TempDir(), "missing", "transfers.csv")); err == nil {
+		t.Error("WriteTransfersCSV() into a missing directory succeeded")
+	}
+}
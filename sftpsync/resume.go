@@ -0,0 +1,142 @@
+package sftpsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// resumeSuffix is appended to a temp file path to name its resume sidecar
+const resumeSuffix = ".resume"
+
+// partialTransfer is the sidecar written next to a .tmp file describing the
+// source it was copied from, so a later attempt can tell whether it is safe to append
+type partialTransfer struct {
+	SourcePath string    `json:"source_path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+}
+
+// openTempFile opens the temp file on dest for writing. If a partial temp
+// file from an identical source exists it is reopened at its current length and
+// the source is seeked to match; otherwise a fresh temp file is created. When a
+// hasher is given, it is primed with the source prefix so verification still
+// covers the whole file.
+func (s *SFTPSync) openTempFile(dest *sftp.Client, srcFile *sftp.File, file *FileInfo, tempPath string, srcHasher hash.Hash) (*sftp.File, int64, error) {
+	if offset := s.resumeOffset(dest, file, tempPath); offset > 0 {
+		destFile, err := s.resumeTempFile(dest, srcFile, tempPath, offset, srcHasher)
+		if err == nil {
+			log.Printf("Resuming transfer of %s from %s of %s", file.RelativePath, formatBytes(offset), formatBytes(file.Size))
+			return destFile, offset, nil
+		}
+		log.Printf("Warning: Cannot resume %s, starting over: %v", file.RelativePath, err)
+		if _, err := srcFile.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, fmt.Errorf("failed to rewind source file: %v", err)
+		}
+		if srcHasher != nil {
+			srcHasher.Reset()
+		}
+	}
+
+	destFile, err := dest.Create(tempPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create destination file: %v", err)
+	}
+	s.writeResumeSidecar(dest, file, tempPath)
+
+	return destFile, 0, nil
+}
+
+// resumeOffset returns how many bytes of an existing temp file can be kept, or 0
+func (s *SFTPSync) resumeOffset(dest *sftp.Client, file *FileInfo, tempPath string) int64 {
+	tempInfo, err := dest.Stat(tempPath)
+	if err != nil || tempInfo.Size() == 0 || tempInfo.Size() > file.Size {
+		return 0
+	}
+
+	sidecar, err := dest.Open(tempPath + resumeSuffix)
+	if err != nil {
+		return 0
+	}
+	defer sidecar.Close()
+
+	var partial partialTransfer
+	if err := json.NewDecoder(sidecar).Decode(&partial); err != nil {
+		return 0
+	}
+
+	if partial.SourcePath != file.Path || partial.Size != file.Size || !partial.ModTime.Equal(file.ModTime) {
+		log.Printf("Source %s changed since the partial transfer, starting over", file.RelativePath)
+		return 0
+	}
+
+	return tempInfo.Size()
+}
+
+// resumeTempFile reopens a partial temp file at offset and positions the source to match
+func (s *SFTPSync) resumeTempFile(dest *sftp.Client, srcFile *sftp.File, tempPath string, offset int64, srcHasher hash.Hash) (*sftp.File, error) {
+	if srcHasher != nil {
+		// Hashing the prefix also leaves the source positioned at offset
+		if _, err := io.CopyN(srcHasher, srcFile, offset); err != nil {
+			return nil, fmt.Errorf("failed to hash source prefix: %v", err)
+		}
+	} else if _, err := srcFile.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek source file: %v", err)
+	}
+
+	destFile, err := dest.OpenFile(tempPath, os.O_WRONLY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reopen destination file: %v", err)
+	}
+	if _, err := destFile.Seek(offset, io.SeekStart); err != nil {
+		destFile.Close()
+		return nil, fmt.Errorf("failed to seek destination file: %v", err)
+	}
+
+	return destFile, nil
+}
+
+// writeResumeSidecar records the source a fresh temp file is being copied from
+func (s *SFTPSync) writeResumeSidecar(dest *sftp.Client, file *FileInfo, tempPath string) {
+	data, err := json.Marshal(partialTransfer{
+		SourcePath: file.Path,
+		Size:       file.Size,
+		ModTime:    file.ModTime,
+	})
+	if err != nil {
+		return
+	}
+
+	sidecar, err := dest.Create(tempPath + resumeSuffix)
+	if err != nil {
+		log.Printf("Warning: Failed to write resume state for %s: %v", tempPath, err)
+		return
+	}
+	defer sidecar.Close()
+
+	if _, err := sidecar.Write(data); err != nil {
+		log.Printf("Warning: Failed to write resume state for %s: %v", tempPath, err)
+	}
+}
+
+// removeTempFile deletes a temp file and its resume sidecar
+func (s *SFTPSync) removeTempFile(dest *sftp.Client, tempPath string) {
+	dest.Remove(tempPath)
+	dest.Remove(tempPath + resumeSuffix)
+}
+
+// isPartialTransferFile reports whether a destination directory entry is one of
+// our own partial transfer artifacts rather than a synced file
+func isPartialTransferFile(name string, siblings map[string]bool) bool {
+	if strings.HasSuffix(name, resumeSuffix) {
+		return true
+	}
+	return siblings[name+resumeSuffix]
+}
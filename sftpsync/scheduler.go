@@ -0,0 +1,508 @@
+package sftpsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Overlap policies: what a job does when it comes due while a run is in progress
+const (
+	OverlapSkip  = "skip"  // drop the run
+	OverlapQueue = "queue" // run once the current run finishes
+)
+
+// Catch-up policies: what a job does about runs missed while the scheduler was down
+const (
+	CatchUpSkip = "skip" // wait for the next scheduled time
+	CatchUpOnce = "once" // run once straight away, however many were missed
+)
+
+// Results recorded in JobStatus.LastResult and RunRecord.Result
+const (
+	ResultSuccess   = "success"
+	ResultPartial   = "partial" // the run finished but some files failed
+	ResultFailed    = "failed"
+	ResultCancelled = "cancelled"
+	ResultSkipped   = "skipped"
+)
+
+// DefaultScheduleStateFile is where the scheduler remembers its last runs
+const DefaultScheduleStateFile = "schedule-state.json"
+
+// busyRetryInterval is how often a queued run retries while a sync outside the
+// scheduler holds the endpoints
+const busyRetryInterval = time.Minute
+
+// schedulerMaxSleep bounds each wait so that a suspended machine or a changed
+// wall clock is noticed within a minute
+const schedulerMaxSleep = time.Minute
+
+// ErrSyncRunning is returned by a JobRunner when another sync, started outside
+// the scheduler, is still in progress. The run is skipped or queued according
+// to the job's overlap policy.
+var ErrSyncRunning = errors.New("another sync is already running")
+
+// ScheduledJob is one entry of the schedule
+type ScheduledJob struct {
+	Name          string
+	Cron          string
+	Schedule      *CronSchedule
+	Overlap       string
+	CatchUp       string
+	CatchUpWindow time.Duration // missed runs older than this are not caught up; 0 for no limit
+	DaysToSync    int           // overrides the sync configuration when non-zero
+}
+
+// Apply sets the job's overrides on a sync configuration
+func (j ScheduledJob) Apply(config *SyncConfig) {
+	if j.DaysToSync > 0 {
+		config.DaysToSync = j.DaysToSync
+	}
+}
+
+// JobStatus is what the scheduler knows about a job. The Last* fields survive
+// restarts in the state file.
+type JobStatus struct {
+	Name          string    `json:"name"`
+	Cron          string    `json:"cron"`
+	NextRun       time.Time `json:"next_run,omitzero"`
+	LastScheduled time.Time `json:"last_scheduled,omitzero"` // the most recent time the job came due
+	LastStarted   time.Time `json:"last_started,omitzero"`
+	LastFinished  time.Time `json:"last_finished,omitzero"`
+	LastResult    string    `json:"last_result,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	Running       bool      `json:"running"`
+	Queued        bool      `json:"queued"`
+}
+
+// JobRunner performs one scheduled sync and returns its record, usually
+// SFTPSync.LastRun, whose Result becomes the job's LastResult. The record may
+// be nil when the sync never started. It should return when ctx is cancelled.
+type JobRunner func(ctx context.Context, job ScheduledJob) (*RunRecord, error)
+
+// Scheduler runs sync jobs on their cron schedules, one at a time
+type Scheduler struct {
+	// OnChange, if set, is called after any job's status changes. It is called
+	// from the scheduler goroutine and must not block.
+	OnChange func()
+
+	run       JobRunner
+	jobs      []ScheduledJob
+	statePath string
+
+	mutex    sync.Mutex
+	status   []JobStatus
+	retryAt  time.Time // earliest time a queued run may retry after ErrSyncRunning
+	stateErr bool      // a failure to save state has been logged
+}
+
+// jobDone reports a finished run to the scheduler loop
+type jobDone struct {
+	index  int
+	record *RunRecord
+	err    error
+}
+
+// NewScheduler prepares a scheduler for jobs, loading their last runs from
+// statePath (DefaultScheduleStateFile if empty)
+func NewScheduler(jobs []ScheduledJob, statePath string, run JobRunner) *Scheduler {
+	if statePath == "" {
+		statePath = DefaultScheduleStateFile
+	}
+	s := &Scheduler{
+		run:       run,
+		jobs:      jobs,
+		statePath: statePath,
+		status:    make([]JobStatus, len(jobs)),
+	}
+	for i, job := range jobs {
+		s.status[i] = JobStatus{Name: job.Name, Cron: job.Cron}
+	}
+	s.loadState()
+	return s
+}
+
+// Status returns a copy of every job's status, in configuration order
+func (s *Scheduler) Status() []JobStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]JobStatus(nil), s.status...)
+}
+
+// Run schedules jobs until ctx is cancelled. A run in progress is given ctx,
+// so it is cancelled too, and Run waits for it before returning ctx's error.
+func (s *Scheduler) Run(ctx context.Context) error {
+	now := time.Now()
+	s.mutex.Lock()
+	for i, job := range s.jobs {
+		s.status[i].NextRun = job.Schedule.Next(now)
+		s.catchUp(i, now)
+	}
+	s.mutex.Unlock()
+	s.changed()
+
+	for i := range s.jobs {
+		log.Printf("⏰ Job %s (%s): next run %s", s.jobs[i].Name, s.jobs[i].Cron, s.Status()[i].NextRun.Format(time.RFC1123))
+	}
+
+	done := make(chan jobDone, 1)
+	running := -1
+
+	for {
+		if running < 0 {
+			running = s.startQueued(ctx, done)
+		}
+
+		timer := time.NewTimer(s.sleepDuration(running >= 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if running >= 0 {
+				s.finish(<-done)
+			}
+			return ctx.Err()
+
+		case <-timer.C:
+			s.fireDue(time.Now(), running >= 0)
+
+		case result := <-done:
+			timer.Stop()
+			running = -1
+			s.finish(result)
+		}
+	}
+}
+
+// catchUp queues job i if it missed a run while the scheduler was down and its
+// policy asks for one. Callers hold s.mutex.
+func (s *Scheduler) catchUp(i int, now time.Time) {
+	job := s.jobs[i]
+	status := &s.status[i]
+	if status.LastScheduled.IsZero() {
+		return // never ran here before, so nothing was missed
+	}
+
+	since := status.LastScheduled
+	if job.CatchUpWindow > 0 && now.Add(-job.CatchUpWindow).After(since) {
+		since = now.Add(-job.CatchUpWindow)
+	}
+	missed := job.Schedule.Next(since)
+	if missed.IsZero() || missed.After(now) {
+		if next := job.Schedule.Next(status.LastScheduled); !next.IsZero() && !next.After(now) {
+			log.Printf("⏰ Job %s missed its run at %s, older than the catch-up window", job.Name, next.Format(time.RFC1123))
+		}
+		return
+	}
+
+	if job.CatchUp != CatchUpOnce {
+		log.Printf("⏰ Job %s missed its run at %s; waiting for the next one", job.Name, missed.Format(time.RFC1123))
+		return
+	}
+	log.Printf("⏰ Job %s missed its run at %s; catching up now", job.Name, missed.Format(time.RFC1123))
+	status.LastScheduled = now
+	status.Queued = true
+}
+
+// fireDue queues every job whose next run has come, applying its overlap policy
+func (s *Scheduler) fireDue(now time.Time, busy bool) {
+	s.mutex.Lock()
+	fired := false
+	for i, job := range s.jobs {
+		status := &s.status[i]
+		if status.NextRun.IsZero() || status.NextRun.After(now) {
+			continue
+		}
+		fired = true
+		status.LastScheduled = status.NextRun
+		status.NextRun = job.Schedule.Next(now)
+
+		switch {
+		case !busy:
+			status.Queued = true
+		case status.Queued:
+			log.Printf("⏰ Job %s is due but already queued", job.Name)
+		case job.Overlap == OverlapQueue:
+			log.Printf("⏰ Job %s is due while another run is in progress; queued", job.Name)
+			status.Queued = true
+		default:
+			log.Printf("⏰ Job %s is due while another run is in progress; skipped", job.Name)
+			status.LastResult = ResultSkipped
+			status.LastError = "previous run still in progress"
+		}
+	}
+	s.mutex.Unlock()
+
+	if fired {
+		s.saveState()
+		s.changed()
+	}
+}
+
+// startQueued starts the first queued job, if any may run now, and returns its
+// index or -1
+func (s *Scheduler) startQueued(ctx context.Context, done chan<- jobDone) int {
+	s.mutex.Lock()
+	if time.Now().Before(s.retryAt) {
+		s.mutex.Unlock()
+		return -1
+	}
+	index := -1
+	for i := range s.status {
+		if s.status[i].Queued {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		s.mutex.Unlock()
+		return -1
+	}
+	status := &s.status[index]
+	status.Queued = false
+	status.Running = true
+	status.LastStarted = time.Now()
+	job := s.jobs[index]
+	s.mutex.Unlock()
+
+	s.saveState()
+	s.changed()
+	log.Printf("⏰ Starting scheduled job %s", job.Name)
+
+	go func() {
+		var record *RunRecord
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				record, err = nil, fmt.Errorf("sync panic: %v", r)
+			}
+			done <- jobDone{index: index, record: record, err: err}
+		}()
+		record, err = s.run(ctx, job)
+	}()
+	return index
+}
+
+// finish records the outcome of a run
+func (s *Scheduler) finish(result jobDone) {
+	job := s.jobs[result.index]
+
+	s.mutex.Lock()
+	status := &s.status[result.index]
+	status.Running = false
+	switch {
+	case errors.Is(result.err, ErrSyncRunning):
+		if job.Overlap == OverlapQueue {
+			log.Printf("⏰ Job %s waits for a sync started elsewhere; retrying in %s", job.Name, busyRetryInterval)
+			status.Queued = true
+			s.retryAt = time.Now().Add(busyRetryInterval)
+		} else {
+			log.Printf("⏰ Job %s skipped: a sync started elsewhere is still running", job.Name)
+			status.LastResult = ResultSkipped
+			status.LastError = result.err.Error()
+		}
+	default:
+		status.LastFinished = time.Now()
+		status.LastResult, status.LastError = jobResult(result)
+		switch status.LastResult {
+		case ResultSuccess:
+			log.Printf("⏰ Job %s finished", job.Name)
+		case ResultPartial:
+			log.Printf("⏰ Job %s finished, but %s", job.Name, status.LastError)
+		case ResultCancelled:
+			log.Printf("⏰ Job %s cancelled", job.Name)
+		default:
+			log.Printf("⏰ Job %s failed: %s", job.Name, status.LastError)
+		}
+	}
+	s.mutex.Unlock()
+
+	s.saveState()
+	s.changed()
+}
+
+// jobResult takes a run's result from its record, falling back on the error
+// when the sync never got as far as recording one
+func jobResult(result jobDone) (string, string) {
+	outcome := runResult(result.err, nil)
+	if result.record != nil && result.record.Result != "" {
+		outcome = result.record.Result
+	}
+
+	switch {
+	case outcome == ResultPartial && result.record.Summary != nil:
+		summary := result.record.Summary
+		return outcome, fmt.Sprintf("%d of %d transfers failed", summary.FailedFiles, summary.FailedFiles+summary.TransferredFiles)
+	case outcome == ResultPartial:
+		return outcome, "some files failed"
+	case result.err != nil && outcome != ResultCancelled:
+		return outcome, result.err.Error()
+	}
+	return outcome, ""
+}
+
+// sleepDuration is how long to wait before the next job comes due
+func (s *Scheduler) sleepDuration(busy bool) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	wait := schedulerMaxSleep
+	for _, status := range s.status {
+		if !status.NextRun.IsZero() && status.NextRun.Sub(now) < wait {
+			wait = status.NextRun.Sub(now)
+		}
+		if status.Queued && !busy && s.retryAt.Sub(now) < wait {
+			wait = s.retryAt.Sub(now)
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+func (s *Scheduler) changed() {
+	if s.OnChange != nil {
+		s.OnChange()
+	}
+}
+
+// loadState restores the last runs of jobs that still exist
+func (s *Scheduler) loadState() {
+	data, err := os.ReadFile(s.statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Cannot read schedule state %s: %v", s.statePath, err)
+		}
+		return
+	}
+	var saved map[string]JobStatus
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Warning: Cannot parse schedule state %s: %v", s.statePath, err)
+		return
+	}
+
+	for i := range s.status {
+		last, ok := saved[s.status[i].Name]
+		if !ok || last.Cron != s.status[i].Cron {
+			continue // a changed schedule starts afresh
+		}
+		s.status[i].LastScheduled = last.LastScheduled
+		s.status[i].LastStarted = last.LastStarted
+		s.status[i].LastFinished = last.LastFinished
+		s.status[i].LastResult = last.LastResult
+		s.status[i].LastError = last.LastError
+	}
+}
+
+// saveState writes the jobs' last runs, replacing the file atomically
+func (s *Scheduler) saveState() {
+	s.mutex.Lock()
+	saved := make(map[string]JobStatus, len(s.status))
+	for _, status := range s.status {
+		status.NextRun = time.Time{}
+		status.Running = false
+		status.Queued = false
+		saved[status.Name] = status
+	}
+	s.mutex.Unlock()
+
+	err := writeFileAtomic(s.statePath, saved)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err != nil && !s.stateErr {
+		log.Printf("Warning: Cannot save schedule state %s: %v", s.statePath, err)
+	}
+	s.stateErr = err != nil
+}
+
+// writeFileAtomic writes v as JSON to a temp file and renames it over path
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+// ConvertToScheduledJobs parses and checks the schedule section of the configuration
+func ConvertToScheduledJobs(jsonConfig ScheduleConfigJSON) ([]ScheduledJob, error) {
+	location := time.Local
+	if jsonConfig.Timezone != "" {
+		loc, err := time.LoadLocation(jsonConfig.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule timezone %q: %w", jsonConfig.Timezone, err)
+		}
+		location = loc
+	}
+
+	jobs := make([]ScheduledJob, 0, len(jsonConfig.Jobs))
+	names := make(map[string]bool)
+	for i, jobJSON := range jsonConfig.Jobs {
+		name := jobJSON.Name
+		if name == "" {
+			name = fmt.Sprintf("job%d", i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("schedule job name %q is used twice", name)
+		}
+		names[name] = true
+
+		schedule, err := ParseCron(jobJSON.Cron, location)
+		if err != nil {
+			return nil, fmt.Errorf("schedule job %s: %w", name, err)
+		}
+
+		job := ScheduledJob{
+			Name:          name,
+			Cron:          jobJSON.Cron,
+			Schedule:      schedule,
+			Overlap:       jobJSON.Overlap,
+			CatchUp:       jobJSON.CatchUp,
+			CatchUpWindow: time.Duration(jobJSON.CatchUpWindow) * time.Second,
+			DaysToSync:    jobJSON.DaysToSync,
+		}
+		if job.Overlap == "" {
+			job.Overlap = OverlapSkip
+		}
+		if job.Overlap != OverlapSkip && job.Overlap != OverlapQueue {
+			return nil, fmt.Errorf("schedule job %s: invalid overlap %q (use %s or %s)", name, job.Overlap, OverlapSkip, OverlapQueue)
+		}
+		if job.CatchUp == "" {
+			job.CatchUp = CatchUpSkip
+		}
+		if job.CatchUp != CatchUpSkip && job.CatchUp != CatchUpOnce {
+			return nil, fmt.Errorf("schedule job %s: invalid catch_up %q (use %s or %s)", name, job.CatchUp, CatchUpSkip, CatchUpOnce)
+		}
+		if job.CatchUpWindow < 0 || job.DaysToSync < 0 {
+			return nil, fmt.Errorf("schedule job %s: catch_up_window and days_to_sync cannot be negative", name)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,170 @@
+package sftpsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testJobs(t *testing.T, jobs ...ScheduleJobJSON) []ScheduledJob {
+	t.Helper()
+	scheduled, err := ConvertToScheduledJobs(ScheduleConfigJSON{Timezone: "UTC", Jobs: jobs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scheduled
+}
+
+func TestSchedulerFinishResults(t *testing.T) {
+	tests := []struct {
+		name       string
+		record     *RunRecord
+		err        error
+		wantResult string
+		wantError  string
+	}{
+		{"success", &RunRecord{Result: ResultSuccess}, nil, ResultSuccess, ""},
+		{"partial", &RunRecord{Result: ResultPartial, Summary: &StatsSnapshot{FailedFiles: 3, TransferredFiles: 7}}, nil, ResultPartial, "3 of 10 transfers failed"},
+		{"partial without a summary", &RunRecord{Result: ResultPartial}, nil, ResultPartial, "some files failed"},
+		{"failed run", &RunRecord{Result: ResultFailed}, errors.New("mirror would delete too much"), ResultFailed, "mirror would delete too much"},
+		{"cancelled run", &RunRecord{Result: ResultCancelled}, context.Canceled, ResultCancelled, ""},
+		{"never started", nil, errors.New("bad config"), ResultFailed, "bad config"},
+		{"never started, no error", nil, nil, ResultSuccess, ""},
+		{"cancelled before starting", nil, fmt.Errorf("connecting: %w", context.Canceled), ResultCancelled, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(testJobs(t, ScheduleJobJSON{Name: "nightly", Cron: "0 2 * * *"}), filepath.Join(t.TempDir(), "state.json"), nil)
+			s.finish(jobDone{index: 0, record: tt.record, err: tt.err})
+
+			status := s.Status()[0]
+			if status.LastResult != tt.wantResult || status.LastError != tt.wantError {
+				t.Errorf("result = %q (%q), want %q (%q)", status.LastResult, status.LastError, tt.wantResult, tt.wantError)
+			}
+			if status.LastFinished.IsZero() {
+				t.Error("LastFinished not set")
+			}
+		})
+	}
+}
+
+func TestSchedulerBusyRun(t *testing.T) {
+	tests := []struct {
+		overlap    string
+		wantQueued bool
+		wantResult string
+	}{
+		{OverlapSkip, false, ResultSkipped},
+		{OverlapQueue, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.overlap, func(t *testing.T) {
+			jobs := testJobs(t, ScheduleJobJSON{Name: "nightly", Cron: "0 2 * * *", Overlap: tt.overlap})
+			s := NewScheduler(jobs, filepath.Join(t.TempDir(), "state.json"), nil)
+			s.finish(jobDone{index: 0, err: ErrSyncRunning})
+
+			status := s.Status()[0]
+			if status.Queued != tt.wantQueued || status.LastResult != tt.wantResult {
+				t.Errorf("queued = %v, result = %q; want %v, %q", status.Queued, status.LastResult, tt.wantQueued, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestSchedulerRecordsPartialRun(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	jobs := testJobs(t, ScheduleJobJSON{Name: "nightly", Cron: "0 2 * * *", CatchUp: CatchUpOnce})
+
+	// A run missed while the scheduler was down is caught up straight away
+	missed := map[string]JobStatus{"nightly": {Cron: "0 2 * * *", LastScheduled: time.Now().AddDate(0, 0, -2)}}
+	if err := writeFileAtomic(statePath, missed); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan ScheduledJob, 1)
+	s := NewScheduler(jobs, statePath, func(ctx context.Context, job ScheduledJob) (*RunRecord, error) {
+		ran <- job
+		return &RunRecord{Result: ResultPartial, Summary: &StatsSnapshot{FailedFiles: 1, TransferredFiles: 4}}, nil
+	})
+	changed := make(chan struct{}, 16)
+	s.OnChange = func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- s.Run(ctx) }()
+	stop := func() {
+		cancel()
+		<-stopped
+	}
+
+	select {
+	case job := <-ran:
+		if job.Name != "nightly" {
+			t.Fatalf("ran job %q", job.Name)
+		}
+	case <-time.After(5 * time.Second):
+		stop()
+		t.Fatal("missed run was not caught up")
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if status := s.Status()[0]; !status.Running && status.LastResult != "" {
+			if status.LastResult != ResultPartial || status.LastError != "1 of 5 transfers failed" {
+				stop()
+				t.Fatalf("result = %q (%q), want %q", status.LastResult, status.LastError, ResultPartial)
+			}
+			break
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			stop()
+			t.Fatal("run result was not recorded")
+		}
+	}
+	stop()
+
+	reloaded := NewScheduler(jobs, statePath, nil)
+	if status := reloaded.Status()[0]; status.LastResult != ResultPartial {
+		t.Errorf("saved result = %q, want %q", status.LastResult, ResultPartial)
+	}
+}
+
+func TestConvertToScheduledJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ScheduleConfigJSON
+		wantErr bool
+	}{
+		{"defaults", ScheduleConfigJSON{Jobs: []ScheduleJobJSON{{Cron: "@daily"}}}, false},
+		{"bad time zone", ScheduleConfigJSON{Timezone: "Mars/Olympus", Jobs: []ScheduleJobJSON{{Cron: "@daily"}}}, true},
+		{"bad cron", ScheduleConfigJSON{Jobs: []ScheduleJobJSON{{Cron: "daily"}}}, true},
+		{"duplicate names", ScheduleConfigJSON{Jobs: []ScheduleJobJSON{{Name: "a", Cron: "@daily"}, {Name: "a", Cron: "@hourly"}}}, true},
+		{"default names do not clash", ScheduleConfigJSON{Jobs: []ScheduleJobJSON{{Cron: "@daily"}, {Cron: "@hourly"}}}, false},
+		{"bad overlap", ScheduleConfigJSON{Jobs: []ScheduleJobJSON{{Cron: "@daily", Overlap: "parallel"}}}, true},
+		{"bad catch up", ScheduleConfigJSON{Jobs: []ScheduleJobJSON{{Cron: "@daily", CatchUp: "all"}}}, true},
+		{"negative window", ScheduleConfigJSON{Jobs: []ScheduleJobJSON{{Cron: "@daily", CatchUpWindow: -1}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := ConvertToScheduledJobs(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConvertToScheduledJobs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i, job := range jobs {
+				if job.Name == "" || job.Overlap != OverlapSkip || job.CatchUp != CatchUpSkip {
+					t.Errorf("job %d = %+v, want a name and the default policies", i, job)
+				}
+			}
+		})
+	}
+}
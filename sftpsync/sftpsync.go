@@ -0,0 +1,692 @@
+// Package sftpsync implements the date-directory SFTP synchronization engine
+// shared by the CLI, web GUI and native GUI front-ends.
+package sftpsync
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPConfig holds SFTP connection configuration
+type SFTPConfig struct {
+	Host      string
+	Port      int
+	Username  string
+	Password  string
+	KeyFile   string
+	Timeout   time.Duration
+	KeepAlive time.Duration
+
+	// KeyPassphrase or the contents of KeyPassphraseFile unlock an encrypted
+	// KeyFile; CertificateFile is an OpenSSH certificate for it (default
+	// <KeyFile>-cert.pub when present). UseAgent also offers the keys of the
+	// ssh-agent at SSH_AUTH_SOCK, and KeyboardInteractive answers challenges.
+	KeyPassphrase       string
+	KeyPassphraseFile   string
+	CertificateFile     string
+	UseAgent            bool
+	KeyboardInteractive []KeyboardInteractiveAnswer
+
+	// MaxSessions caps the SFTP sessions open to the server at once, and so
+	// the transfers touching it (0 for one session shared by every transfer);
+	// SessionsPerConnection packs that many sessions onto each SSH connection
+	// (0 for one connection per session)
+	MaxSessions           int
+	SessionsPerConnection int
+
+	// Host key verification
+	HostKeyPolicy       string
+	KnownHostsFile      string
+	HostKeyFingerprints []string
+
+	// Algorithms to allow, in order of preference; empty lists keep the
+	// SSH library's defaults
+	KeyExchanges      []string
+	Ciphers           []string
+	MACs              []string
+	HostKeyAlgorithms []string
+
+	// JumpHosts are SSH servers to tunnel through on the way to Host, in
+	// order, like OpenSSH's ProxyJump. Each logs in and checks host keys with
+	// its own settings; its session and jump host fields are not used.
+	JumpHosts []SFTPConfig
+
+	// Proxy is a socks5:// or http:// URL to reach the server (or its first
+	// jump host) through, ALL_PROXY when empty, or ProxyDirect for none.
+	// ProxyPassword, when set, replaces the password in the URL.
+	Proxy         string
+	ProxyPassword string
+}
+
+// SyncConfig holds synchronization configuration
+type SyncConfig struct {
+	SourcePath             string
+	DestinationPath        string
+	ExcludePatterns        []string
+	MaxConcurrentTransfers int
+	ChunkSize              int
+	RetryAttempts          int
+	RetryDelay             time.Duration
+	VerifyTransfers        bool
+	DaysToSync             int
+
+	// ReconnectAttempts bounds how often a connection lost during transfers is
+	// re-dialled before the run gives up (0 for DefaultReconnectAttempts,
+	// negative to never reconnect)
+	ReconnectAttempts int
+
+	// DateLayout names the per-day directories (Go time layout or {YYYY}/{MM}/{DD} tokens);
+	// DestDateLayout, when set, renames them on the destination
+	DateLayout     string
+	DestDateLayout string
+
+	// Dates, when set, replaces DaysToSync with an explicit backfill range
+	Dates DateRange
+
+	// CompareMode selects how up-to-date files are detected; see the Compare* constants
+	CompareMode    string
+	MtimeTolerance time.Duration
+
+	// ManifestFile caches destination hashes between runs; empty uses DefaultManifestFile
+	ManifestFile string
+
+	// HistoryFile records every run; empty uses DefaultHistoryFile. Runs older
+	// than HistoryRetention (0 for DefaultHistoryRetention, negative to keep
+	// them forever) or beyond the newest HistoryMaxRuns (0 for no limit) are pruned.
+	HistoryFile      string
+	HistoryRetention time.Duration
+	HistoryMaxRuns   int
+
+	// Mirror mode deletes destination files that no longer exist at source
+	Mirror             bool
+	MaxDeletions       int
+	MaxDeletionPercent float64
+}
+
+// SFTPSync manages SFTP synchronization
+type SFTPSync struct {
+	SourceConfig      SFTPConfig
+	DestinationConfig SFTPConfig
+	SyncConfig        SyncConfig
+	Stats             *SyncStats
+	source            *sessionPool
+	dest              *sessionPool
+	manifest          *Manifest
+
+	// HostKeyPrompt confirms unknown host keys under the tofu policy; nil rejects them
+	HostKeyPrompt HostKeyPrompt
+
+	// PassphrasePrompt asks for the passphrase of an encrypted key that has
+	// none configured; nil fails the connection instead
+	PassphrasePrompt PassphrasePrompt
+	keys             keyCache
+
+	// Trigger says what started the run, e.g. "cli" or "schedule:nightly", for the history
+	Trigger string
+
+	subscribers eventSubscribers
+	progress    progressThrottle
+	runID       string // history id of the current or last run
+	lastRun     *RunRecord
+}
+
+// NewSFTPSync creates a new SFTP synchronization instance
+func NewSFTPSync(sourceConfig, destConfig SFTPConfig, syncConfig SyncConfig) *SFTPSync {
+	return &SFTPSync{
+		SourceConfig:      sourceConfig,
+		DestinationConfig: destConfig,
+		SyncConfig:        syncConfig,
+		Stats: &SyncStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+// ConnectError reports that one of the SFTP servers could not be reached or
+// refused the login
+type ConnectError struct {
+	Server string // "source" or "destination"
+	Err    error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("failed to connect to %s SFTP: %v", e.Server, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// Connect establishes connections to both SFTP servers
+func (s *SFTPSync) Connect() error {
+	var err error
+
+	// Connect to source SFTP
+	s.source, err = newSessionPool(s, "source", s.SourceConfig)
+	if err != nil {
+		return &ConnectError{Server: "source", Err: err}
+	}
+	log.Println("Connected to source SFTP server")
+
+	// Connect to destination SFTP
+	s.dest, err = newSessionPool(s, "destination", s.DestinationConfig)
+	if err != nil {
+		s.source.close()
+		s.source = nil
+		return &ConnectError{Server: "destination", Err: err}
+	}
+	log.Println("Connected to destination SFTP server")
+
+	return nil
+}
+
+// connectSFTP establishes a single SSH connection, through the endpoint's jump
+// hosts if it has any; the session pool opens the SFTP sessions on it
+func (s *SFTPSync) connectSFTP(config SFTPConfig) (*sftpConn, error) {
+	sshClient, jumps, err := s.dialSSH(config)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	return newSFTPConn(sshClient, jumps, addr, config.KeepAlive), nil
+}
+
+// sshClientConfig builds the authentication and host key checks for one SSH
+// hop. The returned function releases the ssh-agent once the handshake is over.
+func (s *SFTPSync) sshClientConfig(config SFTPConfig) (*ssh.ClientConfig, func(), error) {
+	auth, releaseAuth, err := s.authMethods(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostKeyCallback, hostKeyAlgorithms, err := newHostKeyCallback(config, s.HostKeyPrompt)
+	if err != nil {
+		releaseAuth()
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		Config: ssh.Config{
+			KeyExchanges: config.KeyExchanges,
+			Ciphers:      config.Ciphers,
+			MACs:         config.MACs,
+		},
+		User:              config.Username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: offeredHostKeyAlgorithms(config.HostKeyAlgorithms, hostKeyAlgorithms),
+		Timeout:           config.Timeout,
+	}, releaseAuth, nil
+}
+
+// Close closes all SFTP connections
+func (s *SFTPSync) Close() {
+	if s.source != nil {
+		s.source.close()
+		s.source = nil
+	}
+	if s.dest != nil {
+		s.dest.close()
+		s.dest = nil
+	}
+	if s.manifest != nil {
+		s.manifest.Close()
+		s.manifest = nil
+	}
+}
+
+// generateDateDirectories generates the date directories for the last N days
+func (s *SFTPSync) generateDateDirectories(days int) []DateDir {
+	var dirs []DateDir
+	now := time.Now()
+
+	for i := 0; i < days; i++ {
+		dirs = append(dirs, s.newDateDir(now.AddDate(0, 0, -i)))
+	}
+
+	return dirs
+}
+
+// newDateDir names the directories for date on both sides
+func (s *SFTPSync) newDateDir(date time.Time) DateDir {
+	return DateDir{
+		Date:        date,
+		Source:      FormatDateLayout(s.sourceDateLayout(), date),
+		Destination: FormatDateLayout(s.destDateLayout(), date),
+	}
+}
+
+// transferFile transfers a single file to destPath with verification, counting
+// the bytes it writes in tracker. An attempt that fails because a connection
+// dropped does not count against retry_attempts once the connection is back.
+func (s *SFTPSync) transferFile(ctx context.Context, file *FileInfo, destPath string, tracker *transferTracker) (string, error) {
+	reconnects := 0
+
+	// Retry logic
+	var lastErr error
+	abort := func(err error) (string, error) {
+		if lastErr == nil {
+			return "", err
+		}
+		return "", fmt.Errorf("%v: %w", lastErr, err)
+	}
+	for attempt := 0; attempt < s.SyncConfig.RetryAttempts; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying transfer of %s (attempt %d/%d)", file.Path, attempt+1, s.SyncConfig.RetryAttempts)
+			select {
+			case <-ctx.Done():
+				return abort(ctx.Err())
+			case <-time.After(s.SyncConfig.RetryDelay):
+			}
+		}
+
+		// A lost connection is re-dialled when the next session is checked out
+		source, err := s.source.acquire(ctx)
+		if err != nil {
+			return abort(err)
+		}
+		dest, err := s.dest.acquire(ctx)
+		if err != nil {
+			s.source.release(source)
+			return abort(err)
+		}
+
+		tracker.startAttempt(file.Path)
+		hash, err := s.transferAttempt(source.client, dest.client, file, destPath, tracker)
+		lost := false
+		if err != nil {
+			lastErr = err
+			// Both sides are checked, so that neither dropped connection goes unnoticed
+			lost = s.source.check(source)
+			lost = s.dest.check(dest) || lost
+		}
+		s.source.release(source)
+		s.dest.release(dest)
+		if err == nil {
+			return hash, nil
+		}
+
+		if lost && reconnects < s.SyncConfig.RetryAttempts {
+			reconnects++
+			attempt--
+		}
+	}
+
+	return "", fmt.Errorf("transfer failed after %d attempts: %v", s.SyncConfig.RetryAttempts, lastErr)
+}
+
+// transferAttempt copies file from source to destPath once, through a temp file
+func (s *SFTPSync) transferAttempt(source, dest *sftp.Client, file *FileInfo, destPath string, tracker *transferTracker) (string, error) {
+	tempPath := destPath + ".tmp"
+
+	// Create destination directory if it doesn't exist
+	destDir := path.Dir(destPath)
+	if err := dest.MkdirAll(destDir); err != nil {
+		return "", fmt.Errorf("failed to create destination directory %s: %v", destDir, err)
+	}
+
+	// Open source file
+	srcFile, err := source.Open(file.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source file: %v", err)
+	}
+	defer srcFile.Close()
+
+	// Hash the bytes as read from source; the destination is hashed separately after the write
+	var srcHasher hash.Hash
+	if s.SyncConfig.VerifyTransfers {
+		srcHasher = md5.New()
+	}
+
+	// Pick up where a previous attempt or run left off if the source is unchanged
+	destFile, offset, err := s.openTempFile(dest, srcFile, file, tempPath, srcHasher)
+	if err != nil {
+		return "", err
+	}
+
+	written := offset
+	tracker.addBytes(file.Path, offset)
+	buffer := make([]byte, s.SyncConfig.ChunkSize)
+	var lastReport time.Time
+
+	var copyErr error
+	for {
+		n, readErr := srcFile.Read(buffer)
+		if n > 0 {
+			// Write to destination
+			if _, writeErr := destFile.Write(buffer[:n]); writeErr != nil {
+				copyErr = fmt.Errorf("failed to write to destination: %v", writeErr)
+				break
+			}
+
+			// Update source hash if verification is enabled
+			if s.SyncConfig.VerifyTransfers {
+				srcHasher.Write(buffer[:n])
+			}
+
+			written += int64(n)
+			tracker.addBytes(file.Path, int64(n))
+			s.reportProgress(tracker.progress())
+			if time.Since(lastReport) >= progressInterval {
+				lastReport = time.Now()
+				s.emit(Event{Type: EventFileProgress, Path: file.RelativePath, Size: file.Size, Bytes: written})
+			}
+		}
+
+		if readErr != nil {
+			if readErr != io.EOF {
+				copyErr = fmt.Errorf("failed to read from source: %v", readErr)
+			}
+			break
+		}
+	}
+
+	if err := destFile.Close(); err != nil && copyErr == nil {
+		copyErr = fmt.Errorf("failed to close destination file: %v", err)
+	}
+
+	// Leave the partial temp file in place so the next attempt can resume it
+	if copyErr != nil {
+		return "", fmt.Errorf("%v (%d of %d bytes in %s)", copyErr, written, file.Size, tempPath)
+	}
+
+	// Verify file integrity if enabled by re-reading what actually landed on the destination
+	var verifiedHash string
+	if s.SyncConfig.VerifyTransfers {
+		verifiedHash = fmt.Sprintf("%x", srcHasher.Sum(nil))
+		if err := s.verifyTempFile(dest, tempPath, written, verifiedHash); err != nil {
+			s.removeTempFile(dest, tempPath)
+			s.Stats.mutex.Lock()
+			s.Stats.VerificationFailures++
+			s.Stats.mutex.Unlock()
+			log.Printf("Verification failed for %s: %v", file.RelativePath, err)
+			return "", err
+		}
+	}
+
+	// Atomic rename to final destination
+	if err := dest.Rename(tempPath, destPath); err != nil {
+		s.removeTempFile(dest, tempPath)
+		return "", fmt.Errorf("failed to rename temporary file: %v", err)
+	}
+	dest.Remove(tempPath + resumeSuffix)
+
+	// Set file times to match source
+	if err := dest.Chtimes(destPath, file.ModTime, file.ModTime); err != nil {
+		log.Printf("Warning: Failed to set modification time for %s: %v", destPath, err)
+		verifiedHash = ""
+	}
+	s.recordTransfer(destPath, written, file.ModTime, verifiedHash)
+
+	if offset > 0 {
+		log.Printf("Successfully transferred: %s (%d bytes, %d resumed)", file.RelativePath, written, offset)
+	} else {
+		log.Printf("Successfully transferred: %s (%d bytes)", file.RelativePath, written)
+	}
+	return verifiedHash, nil
+}
+
+// Sync performs the complete synchronization process
+func (s *SFTPSync) Sync() error {
+	return s.SyncWithContext(context.Background())
+}
+
+// SyncWithContext performs the complete synchronization process, stopping early when ctx is cancelled
+func (s *SFTPSync) SyncWithContext(ctx context.Context) (err error) {
+	recorder := newRunRecorder(s)
+	s.runID = recorder.record.ID
+	unsubscribe := s.Subscribe(recorder.handle)
+	defer func() {
+		s.lastRun = recorder.finish(err)
+		s.saveHistory(s.lastRun)
+	}()
+	defer unsubscribe()
+
+	err = s.runWithContext(ctx)
+
+	summary := s.Stats.Snapshot()
+	event := Event{Type: EventRunSummary, Duration: time.Since(summary.StartTime), Summary: &summary,
+		Result: runResult(err, &summary)}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	s.reportProgress(Progress{Phase: PhaseDone})
+	s.emit(event)
+	return err
+}
+
+// LastRun returns the record of the most recent Sync, or nil before the first
+func (s *SFTPSync) LastRun() *RunRecord {
+	return s.lastRun
+}
+
+// runWithContext connects, syncs the date window and prints the statistics
+func (s *SFTPSync) runWithContext(ctx context.Context) error {
+	started := s.startPhase(PhaseConnecting, 0, 0)
+	connectErr := s.Connect()
+	s.finishPhase(PhaseConnecting, started, connectErr)
+	if connectErr != nil {
+		return connectErr
+	}
+	defer s.Close()
+
+	// Check for cancellation
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	// Explicit date ranges are synced one date at a time
+	dateDirs := s.dateWindow()
+	var err error
+	if s.SyncConfig.Dates.IsZero() {
+		if err := s.syncDatesWithContext(ctx, dateDirs); err != nil {
+			return err
+		}
+	} else if err = s.backfillWithContext(ctx, dateDirs); errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	// Calculate final statistics
+	s.Stats.mutex.Lock()
+	s.Stats.Duration = time.Since(s.Stats.StartTime)
+	s.Stats.mutex.Unlock()
+
+	s.PrintStats()
+	return err
+}
+
+// syncDatesWithContext plans, transfers and (in mirror mode) deletes for the given dates
+func (s *SFTPSync) syncDatesWithContext(ctx context.Context, dateDirs []DateDir) error {
+	plan, err := s.buildPlan(ctx, dateDirs)
+	if err != nil {
+		return err
+	}
+
+	s.Stats.mutex.Lock()
+	s.Stats.SkippedFiles += plan.UpToDate
+	s.Stats.mutex.Unlock()
+
+	if s.SyncConfig.Mirror {
+		if err := s.checkDeletionLimits(plan); err != nil {
+			return err
+		}
+	}
+
+	filesToSync := plan.Files
+	if len(filesToSync) == 0 {
+		log.Println("✅ No files need synchronization - everything is up to date!")
+	} else {
+		log.Printf("📋 Found %d files to synchronize", len(filesToSync))
+	}
+	plan.logDecisions()
+
+	// Sync files
+	if err := s.syncFilesWithContext(ctx, filesToSync); err != nil {
+		return fmt.Errorf("failed to sync files: %w", err)
+	}
+
+	// Remove destination files that are gone from source
+	if s.SyncConfig.Mirror {
+		if err := s.deleteWithContext(ctx, plan); err != nil {
+			return fmt.Errorf("failed to mirror deletions: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// syncFilesWithContext transfers files from source to destination using a pool of workers
+func (s *SFTPSync) syncFilesWithContext(ctx context.Context, filesToSync []PlannedFile) error {
+	// Check for cancellation
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	s.Stats.mutex.Lock()
+	s.Stats.TotalFiles += len(filesToSync)
+	s.Stats.mutex.Unlock()
+
+	if len(filesToSync) == 0 {
+		log.Println("No files to sync")
+		return nil
+	}
+
+	log.Printf("Starting to sync %d files...", len(filesToSync))
+
+	// Progress tracking for file sync
+	var syncCompleted int32
+	var syncBytes int64
+	tracker := newTransferTracker(filesToSync)
+	syncStartTime := s.startPhase(PhaseTransferring, tracker.filesTotal, tracker.bytesTotal)
+	for _, file := range filesToSync {
+		s.emit(Event{Type: EventFileQueued, Path: file.RelativePath, Size: file.Size, Reason: file.Reason})
+	}
+
+	// Create a buffered channel for file transfer tasks
+	tasks := make(chan *PlannedFile, len(filesToSync))
+	for i := range filesToSync {
+		tasks <- &filesToSync[i]
+	}
+	close(tasks)
+
+	// Create worker goroutines for concurrent transfers
+	var wg sync.WaitGroup
+	workers := s.SyncConfig.MaxConcurrentTransfers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// Use a separate context for workers that can be cancelled
+	workerCtx, workerCancel := context.WithCancel(ctx)
+	defer workerCancel()
+
+	// fatalErr stops every worker when a lost connection cannot be re-established
+	var fatalOnce sync.Once
+	var fatalErr error
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-workerCtx.Done():
+					return
+				case file, ok := <-tasks:
+					if !ok {
+						return // Channel closed, no more tasks
+					}
+
+					// Check for cancellation before each file
+					select {
+					case <-workerCtx.Done():
+						return
+					default:
+					}
+
+					fileStart := time.Now()
+					s.emit(Event{Type: EventFileStarted, Path: file.RelativePath, Size: file.Size})
+					hash, err := s.transferFile(workerCtx, file.source, file.DestinationPath, tracker)
+					if errors.Is(err, context.Canceled) {
+						return // Stopped while waiting to reconnect
+					}
+					if connectErr := (*ConnectError)(nil); errors.As(err, &connectErr) {
+						// A connection could not be brought back; the rest of the queue would fail the same way
+						fatalOnce.Do(func() {
+							fatalErr = connectErr
+							workerCancel()
+						})
+					}
+					if err != nil {
+						log.Printf("❌ Failed to transfer %s: %v", file.RelativePath, err)
+						s.Stats.mutex.Lock()
+						s.Stats.FailedFiles++
+						s.Stats.mutex.Unlock()
+						s.emit(Event{Type: EventFileFailed, Path: file.RelativePath, Size: file.Size,
+							Duration: time.Since(fileStart), Error: err.Error()})
+					} else {
+						s.Stats.mutex.Lock()
+						s.Stats.TransferredFiles++
+						s.Stats.TotalBytes += file.Size
+						s.Stats.mutex.Unlock()
+						atomic.AddInt64(&syncBytes, file.Size)
+						s.emit(Event{Type: EventFileDone, Path: file.RelativePath, Size: file.Size,
+							Bytes: file.Size, Duration: time.Since(fileStart), Hash: hash})
+					}
+					atomic.AddInt32(&syncCompleted, 1)
+					tracker.fileFinished(file.source.Path)
+					s.reportProgress(tracker.progress())
+				}
+			}
+		}()
+	}
+
+	// Wait for all workers to complete or context cancellation
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if fatalErr != nil {
+			log.Printf("❌ File sync stopped after %d files: %v", atomic.LoadInt32(&syncCompleted), fatalErr)
+			s.finishPhase(PhaseTransferring, syncStartTime, fatalErr)
+			return fatalErr
+		}
+		// All workers completed
+		log.Printf("✅ File sync completed in %s: %d files, %s transferred",
+			time.Since(syncStartTime).Round(time.Second), atomic.LoadInt32(&syncCompleted), formatBytes(atomic.LoadInt64(&syncBytes)))
+		s.finishPhase(PhaseTransferring, syncStartTime, nil)
+		return nil
+	case <-ctx.Done():
+		// Context cancelled, signal workers to stop
+		workerCancel()
+		// Wait for workers to finish with timeout
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			log.Println("⚠️  Workers did not finish within timeout")
+		}
+		s.finishPhase(PhaseTransferring, syncStartTime, ctx.Err())
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,386 @@
+package sftpsync
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sshConfigKeywords are the ~/.ssh/config settings an endpoint takes from its alias
+var sshConfigKeywords = map[string]bool{
+	"hostname":     true,
+	"port":         true,
+	"user":         true,
+	"identityfile": true,
+	"proxyjump":    true,
+
+	"stricthostkeychecking": true,
+	"userknownhostsfile":    true,
+}
+
+// sshHostSettings are the settings OpenSSH would use for one host alias. As
+// in OpenSSH, the first value found for a keyword wins.
+type sshHostSettings struct {
+	values  map[string]string
+	matched bool // some Host block applied to the alias
+}
+
+// resolveSSHConfig fills in the fields an endpoint leaves empty from the
+// OpenSSH config entry named by ssh_config_host: HostName, Port, User,
+// IdentityFile, StrictHostKeyChecking, UserKnownHostsFile and ProxyJump.
+// Jump hosts given in JSON are resolved too.
+func resolveSSHConfig(config *SFTPConfigJSON) error {
+	for i := range config.JumpHosts {
+		if err := resolveSSHConfig(&config.JumpHosts[i]); err != nil {
+			return fmt.Errorf("jump host %d: %w", i+1, err)
+		}
+	}
+	if config.SSHConfigHost == "" {
+		return nil
+	}
+
+	path, err := sshConfigPath(config.SSHConfigFile)
+	if err != nil {
+		return err
+	}
+	settings, err := readSSHConfig(path, config.SSHConfigHost)
+	if err != nil {
+		return err
+	}
+	if !settings.matched {
+		return fmt.Errorf("no Host entry in %s matches %q", path, config.SSHConfigHost)
+	}
+	if err := settings.apply(config, config.SSHConfigHost); err != nil {
+		return fmt.Errorf("%s, host %q: %w", path, config.SSHConfigHost, err)
+	}
+
+	if len(config.JumpHosts) == 0 {
+		hops, err := settings.proxyJump(path, config)
+		if err != nil {
+			return fmt.Errorf("%s, host %q: %w", path, config.SSHConfigHost, err)
+		}
+		config.JumpHosts = hops
+	}
+
+	log.Printf("Using %s host %q: %s@%s:%d", path, config.SSHConfigHost, config.Username, config.Host, config.Port)
+	return nil
+}
+
+// sshConfigPath returns the config file to read, ~/.ssh/config by default
+func sshConfigPath(path string) (string, error) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to find ~/.ssh/config: %v", err)
+		}
+		return filepath.Join(home, ".ssh", "config"), nil
+	}
+	return expandHome(path), nil
+}
+
+// apply copies the alias's settings into the fields config leaves empty
+func (h *sshHostSettings) apply(config *SFTPConfigJSON, alias string) error {
+	if config.Host == "" {
+		config.Host = alias
+		if hostname := h.values["hostname"]; hostname != "" {
+			config.Host = expandSSHTokens(hostname, alias, config)
+		}
+	}
+	if config.Port == 0 {
+		if port := h.values["port"]; port != "" {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return fmt.Errorf("invalid Port %q", port)
+			}
+			config.Port = p
+		}
+	}
+	if config.Username == "" {
+		config.Username = h.values["user"]
+	}
+	if config.KeyFile == "" && h.values["identityfile"] != "" {
+		config.KeyFile = expandSSHTokens(h.values["identityfile"], alias, config)
+	}
+
+	// StrictHostKeyChecking accept-new becomes the tofu policy. yes and ask
+	// check known_hosts as the default policy does, and no is ignored: host
+	// keys are always checked.
+	if config.KnownHostsFile == "" {
+		if files := strings.Fields(h.values["userknownhostsfile"]); len(files) > 0 && !strings.EqualFold(files[0], "none") {
+			config.KnownHostsFile = expandSSHTokens(files[0], alias, config)
+		}
+	}
+	if config.HostKeyPolicy == "" && len(config.HostKeyFingerprints) == 0 &&
+		strings.EqualFold(h.values["stricthostkeychecking"], "accept-new") {
+		config.HostKeyPolicy = HostKeyPolicyTOFU
+		if config.KnownHostsFile == "" {
+			config.KnownHostsFile = expandHome("~/.ssh/known_hosts")
+		}
+	}
+	return nil
+}
+
+// proxyJump turns the alias's ProxyJump list into jump hosts. Each hop is
+// looked up in the same file, host key settings included, and otherwise logs
+// in like the endpoint it leads to. Host keys are never taken from the
+// endpoint: a hop with no settings of its own checks known_hosts.
+func (h *sshHostSettings) proxyJump(path string, endpoint *SFTPConfigJSON) ([]SFTPConfigJSON, error) {
+	list := h.values["proxyjump"]
+	if list == "" || strings.EqualFold(list, "none") {
+		return nil, nil
+	}
+
+	var hops []SFTPConfigJSON
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+		hop := SFTPConfigJSON{
+			KeyPassphraseEnv:  endpoint.KeyPassphraseEnv,
+			KeyPassphraseFile: endpoint.KeyPassphraseFile,
+			UseAgent:          endpoint.UseAgent,
+			Timeout:           endpoint.Timeout,
+		}
+
+		if at := strings.LastIndex(spec, "@"); at >= 0 {
+			hop.Username, spec = spec[:at], spec[at+1:]
+		}
+		// host, host:port, [host] or [host]:port, the brackets being needed
+		// around an IPv6 address with a port
+		alias := strings.TrimSuffix(strings.TrimPrefix(spec, "["), "]")
+		if host, port, err := net.SplitHostPort(spec); err == nil {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ProxyJump port in %q", spec)
+			}
+			alias, hop.Port = host, p
+		}
+
+		settings, err := readSSHConfig(path, alias)
+		if err != nil {
+			return nil, err
+		}
+		if err := settings.apply(&hop, alias); err != nil {
+			return nil, fmt.Errorf("ProxyJump host %q: %w", alias, err)
+		}
+		if hop.KeyFile == "" {
+			hop.KeyFile = endpoint.KeyFile
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// readSSHConfig collects the settings that apply to alias from an OpenSSH
+// config file, following Include directives. Match blocks other than
+// "Match all" are not evaluated and are skipped.
+func readSSHConfig(path, alias string) (*sshHostSettings, error) {
+	settings := &sshHostSettings{values: make(map[string]string)}
+	if err := settings.read(path, alias, 0); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (h *sshHostSettings) read(path, alias string, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("%s: too many nested Include directives", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to read SSH config: %v", err)
+	}
+	defer file.Close()
+
+	active := true // settings before the first Host line apply to every host
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		keyword, args := splitSSHConfigLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			active = matchHostPatterns(alias, args)
+			if active {
+				h.matched = true
+			}
+		case "match":
+			active = len(args) == 1 && strings.EqualFold(args[0], "all")
+		case "include":
+			if !active {
+				continue
+			}
+			for _, pattern := range args {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(path), pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s:%d: invalid Include pattern %q", path, line, pattern)
+				}
+				for _, include := range matches {
+					if err := h.read(include, alias, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			if active && sshConfigKeywords[keyword] && len(args) > 0 {
+				if _, ok := h.values[keyword]; !ok {
+					h.values[keyword] = strings.Join(args, " ")
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read SSH config: %v", err)
+	}
+	return nil
+}
+
+// splitSSHConfigLine returns a config line's lowercased keyword and its
+// arguments, which may be separated from it by "=" and may be quoted
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				arg, rest = rest[1:], ""
+			} else {
+				arg, rest = rest[1:closing+1], rest[closing+2:]
+			}
+		} else if space := strings.IndexAny(rest, " \t"); space >= 0 {
+			arg, rest = rest[:space], rest[space:]
+		} else {
+			arg, rest = rest, ""
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args
+}
+
+// matchHostPatterns reports whether a Host line applies to alias: some
+// pattern matches it and no negated ("!") pattern does
+func matchHostPatterns(alias string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchWildcard(strings.ToLower(negated), strings.ToLower(alias)) {
+				return false
+			}
+		} else if matchWildcard(strings.ToLower(pattern), strings.ToLower(alias)) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchWildcard matches s against an OpenSSH pattern of "*" and "?" wildcards
+func matchWildcard(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// expandSSHTokens expands the ~ and the %-tokens OpenSSH allows in HostName
+// and IdentityFile: %h host, %n alias, %p port, %r remote user, %u local
+// user, %d home directory and %% a literal percent sign
+func expandSSHTokens(value, alias string, config *SFTPConfigJSON) string {
+	value = expandHome(value)
+	if !strings.Contains(value, "%") {
+		return value
+	}
+
+	home, _ := os.UserHomeDir()
+	local := ""
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+	host := config.Host
+	if host == "" {
+		host = alias
+	}
+	port := config.Port
+	if port == 0 {
+		port = 22
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' || i+1 == len(value) {
+			out.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'h':
+			out.WriteString(host)
+		case 'n':
+			out.WriteString(alias)
+		case 'p':
+			out.WriteString(strconv.Itoa(port))
+		case 'r':
+			out.WriteString(config.Username)
+		case 'u':
+			out.WriteString(local)
+		case 'd':
+			out.WriteString(home)
+		case '%':
+			out.WriteByte('%')
+		default:
+			out.WriteByte('%')
+			out.WriteByte(value[i])
+		}
+	}
+	return out.String()
+}
+
+// expandHome replaces a leading ~/ with the user's home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
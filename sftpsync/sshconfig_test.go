@@ -0,0 +1,210 @@
+package sftpsync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testSSHConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(strings.TrimLeft(content, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMatchHostPatterns(t *testing.T) {
+	tests := []struct {
+		alias    string
+		patterns []string
+		want     bool
+	}{
+		{"kra-prod", []string{"kra-prod"}, true},
+		{"KRA-Prod", []string{"kra-prod"}, true},
+		{"kra-prod", []string{"kra-*"}, true},
+		{"kra-prod", []string{"kra-pro?"}, true},
+		{"kra-prod", []string{"kra-pr?"}, false},
+		{"kra-prod", []string{"*"}, true},
+		{"kra-prod", []string{"other", "kra-*"}, true},
+		{"kra-prod", []string{"kra-*", "!kra-prod"}, false},
+		{"kra-test", []string{"kra-*", "!kra-prod"}, true},
+		{"kra-prod", []string{"!kra-test"}, false}, // a negation alone matches nothing
+	}
+	for _, tt := range tests {
+		if got := matchHostPatterns(tt.alias, tt.patterns); got != tt.want {
+			t.Errorf("matchHostPatterns(%q, %q) = %v, want %v", tt.alias, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestSplitSSHConfigLine(t *testing.T) {
+	tests := []struct {
+		line        string
+		wantKeyword string
+		wantArgs    []string
+	}{
+		{"  HostName 10.0.0.5", "hostname", []string{"10.0.0.5"}},
+		{"Port=2222", "port", []string{"2222"}},
+		{"User = deploy", "user", []string{"deploy"}},
+		{`IdentityFile "~/my keys/id_ed25519"`, "identityfile", []string{"~/my keys/id_ed25519"}},
+		{"Host kra-* !kra-test", "host", []string{"kra-*", "!kra-test"}},
+		{"# a comment", "", nil},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		keyword, args := splitSSHConfigLine(tt.line)
+		if keyword != tt.wantKeyword || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("splitSSHConfigLine(%q) = %q %q, want %q %q", tt.line, keyword, args, tt.wantKeyword, tt.wantArgs)
+		}
+	}
+}
+
+func TestReadSSHConfigFirstMatchWins(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "extra"), []byte("Host kra-prod\n  IdentityFile /keys/included\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := testSSHConfig(t, `
+Port 2200
+
+Host kra-prod
+  HostName 10.0.0.5
+  User deploy
+
+Host kra-*
+  User other
+  Port 2222
+  IdentityFile /keys/wildcard
+
+Match host other
+  User matched
+
+Include `+filepath.Join(dir, "extra")+`
+
+Host *
+  ProxyJump bastion
+`)
+
+	settings, err := readSSHConfig(path, "kra-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"hostname":     "10.0.0.5",
+		"user":         "deploy",         // the kra-prod block comes before kra-*
+		"port":         "2200",           // settings before the first Host apply to all
+		"identityfile": "/keys/wildcard", // the Include comes later
+		"proxyjump":    "bastion",
+	}
+	if !settings.matched || !reflect.DeepEqual(settings.values, want) {
+		t.Errorf("settings = %v (matched %v), want %v", settings.values, settings.matched, want)
+	}
+
+	settings, err = readSSHConfig(path, "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.matched {
+		t.Error("the catch-all Host * block should still count as a match")
+	}
+}
+
+func TestResolveSSHConfig(t *testing.T) {
+	path := testSSHConfig(t, `
+Host kra-prod
+  HostName 10.0.0.5
+  User deploy
+  Port 2022
+  IdentityFile /keys/%n
+  StrictHostKeyChecking accept-new
+  ProxyJump jump@bastion,relay:2200,[2001:db8::1]:2222,[2001:db8::2],2001:db8::3
+
+Host bastion
+  HostName bastion.example.com
+  IdentityFile /keys/bastion
+  UserKnownHostsFile /etc/ssh/bastion_known_hosts
+
+Host relay
+  User relay-user
+  Port 22
+  StrictHostKeyChecking accept-new
+
+Host nowhere
+  ProxyJump relay:ssh
+`)
+
+	tests := []struct {
+		name    string
+		config  SFTPConfigJSON
+		want    SFTPConfigJSON
+		wantErr bool
+	}{
+		{
+			name:   "alias fills in the empty fields",
+			config: SFTPConfigJSON{SSHConfigHost: "kra-prod", HostKeyPolicy: HostKeyPolicyFingerprint, KnownHostsFile: "/endpoint/known_hosts", UseAgent: true},
+			want: SFTPConfigJSON{
+				Host: "10.0.0.5", Port: 2022, Username: "deploy", KeyFile: "/keys/kra-prod", UseAgent: true,
+				HostKeyPolicy: HostKeyPolicyFingerprint, KnownHostsFile: "/endpoint/known_hosts",
+				JumpHosts: []SFTPConfigJSON{
+					// Hops log in with the endpoint's key unless they have their
+					// own, and check host keys by their own blocks only
+					{Host: "bastion.example.com", Username: "jump", KeyFile: "/keys/bastion", UseAgent: true, KnownHostsFile: "/etc/ssh/bastion_known_hosts"},
+					{Host: "relay", Port: 2200, Username: "relay-user", KeyFile: "/keys/kra-prod", UseAgent: true, HostKeyPolicy: HostKeyPolicyTOFU, KnownHostsFile: expandHome("~/.ssh/known_hosts")},
+					{Host: "2001:db8::1", Port: 2222, KeyFile: "/keys/kra-prod", UseAgent: true},
+					{Host: "2001:db8::2", KeyFile: "/keys/kra-prod", UseAgent: true},
+					{Host: "2001:db8::3", KeyFile: "/keys/kra-prod", UseAgent: true},
+				},
+			},
+		},
+		{
+			name:   "JSON and environment values win",
+			config: SFTPConfigJSON{SSHConfigHost: "kra-prod", Host: "override.example.com", Port: 22, Username: "other", KeyFile: "/keys/mine", KnownHostsFile: "/mine/known_hosts", JumpHosts: []SFTPConfigJSON{{Host: "hop.example.com", Username: "hop"}}},
+			want: SFTPConfigJSON{
+				Host: "override.example.com", Port: 22, Username: "other", KeyFile: "/keys/mine",
+				HostKeyPolicy: HostKeyPolicyTOFU, KnownHostsFile: "/mine/known_hosts",
+				JumpHosts: []SFTPConfigJSON{{Host: "hop.example.com", Username: "hop"}},
+			},
+		},
+		{
+			name:   "jump host with its own alias",
+			config: SFTPConfigJSON{Host: "10.0.0.9", Username: "sync", JumpHosts: []SFTPConfigJSON{{SSHConfigHost: "bastion", Username: "jump"}}},
+			want: SFTPConfigJSON{
+				Host: "10.0.0.9", Username: "sync",
+				JumpHosts: []SFTPConfigJSON{{Host: "bastion.example.com", Username: "jump", KeyFile: "/keys/bastion", KnownHostsFile: "/etc/ssh/bastion_known_hosts", SSHConfigHost: "bastion"}},
+			},
+		},
+		{name: "unknown alias", config: SFTPConfigJSON{SSHConfigHost: "missing"}, wantErr: true},
+		{name: "bad ProxyJump port", config: SFTPConfigJSON{SSHConfigHost: "nowhere"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.SSHConfigFile = path
+			for i := range config.JumpHosts {
+				if config.JumpHosts[i].SSHConfigHost != "" {
+					config.JumpHosts[i].SSHConfigFile = path
+				}
+			}
+			err := resolveSSHConfig(&config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSSHConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// Compare without the lookup fields carried through from the input
+			config.SSHConfigHost, config.SSHConfigFile = "", ""
+			for i := range config.JumpHosts {
+				config.JumpHosts[i].SSHConfigFile = ""
+			}
+			if !reflect.DeepEqual(config, tt.want) {
+				t.Errorf("resolved\n%+v\nwant\n%+v", config, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package sftpsync
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncStats holds synchronization statistics
+type SyncStats struct {
+	TotalFiles       int
+	TransferredFiles int
+	SkippedFiles     int
+	FailedFiles      int
+	DeletedFiles     int
+	// VerificationFailures counts transfer attempts whose destination copy did
+	// not match the source when read back; the attempt is retried
+	VerificationFailures int
+	TotalBytes           int64
+	StartTime            time.Time
+	Duration             time.Duration
+	Dates                []DateResult // per-date results of a backfill run
+	mutex                sync.RWMutex
+}
+
+// StatsSnapshot is a point-in-time copy of SyncStats that is safe to share
+type StatsSnapshot struct {
+	TotalFiles           int           `json:"total_files"`
+	TransferredFiles     int           `json:"transferred_files"`
+	SkippedFiles         int           `json:"skipped_files"`
+	FailedFiles          int           `json:"failed_files"`
+	DeletedFiles         int           `json:"deleted_files"`
+	VerificationFailures int           `json:"verification_failures"`
+	TotalBytes           int64         `json:"total_bytes"`
+	StartTime            time.Time     `json:"start_time"`
+	Duration             time.Duration `json:"duration"`
+	Dates                []DateResult  `json:"dates,omitempty"`
+}
+
+// Snapshot returns a consistent copy of the current statistics
+func (st *SyncStats) Snapshot() StatsSnapshot {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return StatsSnapshot{
+		TotalFiles:           st.TotalFiles,
+		TransferredFiles:     st.TransferredFiles,
+		SkippedFiles:         st.SkippedFiles,
+		FailedFiles:          st.FailedFiles,
+		DeletedFiles:         st.DeletedFiles,
+		VerificationFailures: st.VerificationFailures,
+		TotalBytes:           st.TotalBytes,
+		StartTime:            st.StartTime,
+		Duration:             st.Duration,
+		Dates:                append([]DateResult(nil), st.Dates...),
+	}
+}
+
+// PrintStats logs synchronization statistics
+func (s *SFTPSync) PrintStats() {
+	log.Println(strings.Repeat("=", 60))
+	log.Println("🎉 SYNCHRONIZATION COMPLETED!")
+	log.Println(strings.Repeat("=", 60))
+	for _, line := range s.statsLines() {
+		log.Println(line)
+	}
+	log.Println(strings.Repeat("=", 60))
+}
+
+// statsLines renders the statistics for PrintStats and the notifications
+func (s *SFTPSync) statsLines() []string {
+	s.Stats.mutex.RLock()
+	defer s.Stats.mutex.RUnlock()
+
+	var lines []string
+	add := func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	add("📊 STATISTICS:")
+	add("   📁 Total files processed: %d", s.Stats.TotalFiles)
+	add("   ✅ Successfully transferred: %d", s.Stats.TransferredFiles)
+	add("   ⏭️  Skipped (up-to-date): %d", s.Stats.SkippedFiles)
+	add("   ❌ Failed transfers: %d", s.Stats.FailedFiles)
+	if s.SyncConfig.VerifyTransfers {
+		add("   🔍 Verification failures: %d", s.Stats.VerificationFailures)
+	}
+	if s.SyncConfig.Mirror {
+		add("   🗑️  Deleted (mirror): %d", s.Stats.DeletedFiles)
+	}
+	add("   📦 Total data transferred: %s", formatBytes(s.Stats.TotalBytes))
+	add("   ⏱️  Total duration: %v", s.Stats.Duration.Round(time.Second))
+
+	if s.Stats.Duration > 0 && s.Stats.TotalBytes > 0 {
+		throughput := float64(s.Stats.TotalBytes) / s.Stats.Duration.Seconds()
+		add("   🚀 Average throughput: %s", formatRate(throughput))
+	}
+
+	// Success rate
+	if s.Stats.TotalFiles > 0 {
+		successRate := float64(s.Stats.TransferredFiles) / float64(s.Stats.TotalFiles) * 100
+		add("   📈 Success rate: %.1f%%", successRate)
+	}
+
+	if len(s.Stats.Dates) > 0 {
+		add("📅 PER DATE:")
+		for _, d := range s.Stats.Dates {
+			status := "✅"
+			if d.Error != "" || d.Failed > 0 {
+				status = "❌"
+			}
+			add("   %s %s  %d transferred, %d skipped, %d failed, %d deleted, %s in %v",
+				status, d.Date, d.Transferred, d.Skipped, d.Failed, d.Deleted, formatBytes(d.Bytes), d.Duration.Round(time.Second))
+			if d.Error != "" {
+				add("      %s", d.Error)
+			}
+		}
+	}
+	return lines
+}
+
+// formatBytes renders a byte count in the largest sensible unit
+func formatBytes(bytes int64) string {
+	switch {
+	case bytes > 1024*1024*1024:
+		return fmt.Sprintf("%.2f GB", float64(bytes)/(1024*1024*1024))
+	case bytes > 1024*1024:
+		return fmt.Sprintf("%.2f MB", float64(bytes)/(1024*1024))
+	case bytes > 1024:
+		return fmt.Sprintf("%.2f KB", float64(bytes)/1024)
+	default:
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+}
+
+// formatRate renders a bytes-per-second rate
+func formatRate(bytesPerSec float64) string {
+	switch {
+	case bytesPerSec > 1024*1024:
+		return fmt.Sprintf("%.2f MB/s", bytesPerSec/(1024*1024))
+	case bytesPerSec > 1024:
+		return fmt.Sprintf("%.2f KB/s", bytesPerSec/1024)
+	default:
+		return fmt.Sprintf("%.0f B/s", bytesPerSec)
+	}
+}
@@ -0,0 +1,30 @@
+package sftpsync
+
+import (
+	"fmt"
+
+	"github.com/pkg/sftp"
+)
+
+// verifyTempFile re-opens a finished temp file on dest and checks that
+// its size and MD5 match what was read from source. This catches truncated or
+// corrupted writes that an in-memory comparison of the copy buffer cannot.
+func (s *SFTPSync) verifyTempFile(dest *sftp.Client, tempPath string, expectedSize int64, expectedHash string) error {
+	info, err := dest.Stat(tempPath)
+	if err != nil {
+		return fmt.Errorf("verification failed: cannot stat %s: %v", tempPath, err)
+	}
+	if info.Size() != expectedSize {
+		return fmt.Errorf("verification failed: size mismatch: src=%d, dest=%d", expectedSize, info.Size())
+	}
+
+	destHash, err := s.calculateRemoteFileHash(dest, tempPath)
+	if err != nil {
+		return fmt.Errorf("verification failed: cannot read back %s: %v", tempPath, err)
+	}
+	if destHash != expectedHash {
+		return fmt.Errorf("hash verification failed: src=%s, dest=%s", expectedHash, destHash)
+	}
+
+	return nil
+}
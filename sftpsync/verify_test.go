@@ -0,0 +1,111 @@
+package sftpsync
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// testSFTPClient connects to an in-memory SFTP server
+func testSFTPClient(t *testing.T) *sftp.Client {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, sftp.InMemHandler())
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+// writeRemote creates a file with the given content on an SFTP server
+func writeRemote(t *testing.T, client *sftp.Client, filePath, content string) {
+	t.Helper()
+	file, err := client.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func md5Hex(content string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(content)))
+}
+
+func TestVerifyTempFile(t *testing.T) {
+	client := testSFTPClient(t)
+	writeRemote(t, client, "/report.csv.tmp", "id,amount\n1,100\n")
+	s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{})
+
+	tests := []struct {
+		name    string
+		path    string
+		size    int64
+		hash    string
+		wantErr string
+	}{
+		{"matching copy", "/report.csv.tmp", 16, md5Hex("id,amount\n1,100\n"), ""},
+		{"truncated copy", "/report.csv.tmp", 32, md5Hex("id,amount\n1,100\n"), "size mismatch"},
+		{"corrupted copy", "/report.csv.tmp", 16, md5Hex("id,amount\n1,999\n"), "hash verification failed"},
+		{"missing temp file", "/gone.tmp", 16, md5Hex(""), "cannot stat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.verifyTempFile(client, tt.path, tt.size, tt.hash)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyTempFile() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("verifyTempFile() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransferRetryDelayStopsOnCancel(t *testing.T) {
+	s := NewSFTPSync(SFTPConfig{}, SFTPConfig{}, SyncConfig{RetryAttempts: 3, RetryDelay: time.Hour})
+	s.source = testPool(testSFTPClient(t), "source")
+	s.dest = testPool(testSFTPClient(t), "destination")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	// The source file does not exist, so the first attempt fails and the
+	// transfer waits out the retry delay
+	file := &FileInfo{Path: "/missing.csv", RelativePath: "missing.csv", Size: 1}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.transferFile(ctx, file, "/missing.csv", newTransferTracker(nil))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("transferFile() error = %v, want it cancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("transferFile() kept sleeping after the context was cancelled")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func eventIDs(events []webEvent) []int64 {
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+func TestEventHubPending(t *testing.T) {
+	hub := newEventHub()
+	hub.publish(eventLog, "first")                             // 1
+	hub.publish(eventStatus, map[string]bool{"running": true}) // 2
+	hub.publish(eventLog, "second")                            // 3
+	hub.publish(eventProgress, map[string]int{"files": 1})     // 4
+	hub.publish(eventProgress, map[string]int{"files": 2})     // 5
+	hub.publish(eventLog, "third")                             // 6
+
+	tests := []struct {
+		name    string
+		lastLog int64
+		sent    map[string]int64
+		want    []int64
+	}{
+		{"new client gets the history and the latest state", 0, nil, []int64{1, 2, 3, 5, 6}},
+		{"reconnect resumes after the last log line", 3, nil, []int64{2, 5, 6}},
+		{"state already sent is not repeated", 3, map[string]int64{eventStatus: 2, eventProgress: 5}, []int64{6}},
+		{"superseded state is resent", 6, map[string]int64{eventStatus: 2, eventProgress: 4}, []int64{5}},
+		{"up to date", 6, map[string]int64{eventStatus: 2, eventProgress: 5}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := eventIDs(hub.pending(tt.lastLog, tt.sent))
+			if len(got) != len(tt.want) {
+				t.Fatalf("pending() ids = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("pending() ids = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventHubBuffer(t *testing.T) {
+	hub := newEventHub()
+	for i := 0; i < eventBufferSize+10; i++ {
+		hub.publish(eventLog, i)
+	}
+
+	events := hub.pending(0, nil)
+	if len(events) != eventBufferSize {
+		t.Fatalf("buffered %d log events, want %d", len(events), eventBufferSize)
+	}
+	if events[0].ID != 11 {
+		t.Errorf("oldest buffered id = %d, want 11", events[0].ID)
+	}
+}
+
+func TestEventHubWakesSubscribers(t *testing.T) {
+	hub := newEventHub()
+	notify := hub.subscribe()
+
+	hub.publish(eventLog, "one")
+	hub.publish(eventLog, "two") // must not block on the pending wake-up
+	select {
+	case <-notify:
+	default:
+		t.Fatal("subscriber was not woken")
+	}
+
+	hub.unsubscribe(notify)
+	hub.publish(eventLog, "three")
+	select {
+	case <-notify:
+		t.Fatal("unsubscribed channel was woken")
+	default:
+	}
+}
+
+func TestEventsHandlerResumes(t *testing.T) {
+	w := &WebGUI{events: newEventHub()}
+	w.events.publish(eventLog, "old")                               // 1
+	w.events.publish(eventLog, "missed")                            // 2
+	w.events.publish(eventStatus, map[string]bool{"running": true}) // 3
+	server := httptest.NewServer(http.HandlerFunc(w.eventsHandler))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	request.Header.Set("Last-Event-ID", "1")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", contentType)
+	}
+
+	lines := bufio.NewScanner(response.Body)
+	next := func() string {
+		t.Helper()
+		for lines.Scan() {
+			if line := lines.Text(); strings.HasPrefix(line, "id: ") {
+				lines.Scan()
+				event := strings.TrimPrefix(lines.Text(), "event: ")
+				lines.Scan()
+				return strings.TrimPrefix(line, "id: ") + " " + event + " " + strings.TrimPrefix(lines.Text(), "data: ")
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return ""
+	}
+
+	for _, want := range []string{`2 log "missed"`, `3 status {"running":true}`} {
+		if got := next(); got != want {
+			t.Fatalf("event = %s, want %s", got, want)
+		}
+	}
+
+	w.events.publish(eventLog, "live")
+	if got, want := next(), `4 log "live"`; got != want {
+		t.Fatalf("event = %s, want %s", got, want)
+	}
+}
+
+func TestEventsHandlerRejectsBadLastEventID(t *testing.T) {
+	w := &WebGUI{events: newEventHub()}
+	recorder := httptest.NewRecorder()
+	w.eventsHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/events?lastEventId=abc", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
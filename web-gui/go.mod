@@ -3,12 +3,15 @@ module oneclick-kra-sftp-sync
 go 1.24.5
 
 require (
-	github.com/pkg/sftp v1.13.9
+	github.com/skpjr001/oneclick-kra-sftp-sync/sftpsync v0.0.0
 	golang.org/x/crypto v0.39.0
 )
 
 require (
 	github.com/kr/fs v0.1.0 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
+	github.com/pkg/sftp v1.13.9 // indirect
+	go.etcd.io/bbolt v1.4.3 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 )
+
+replace github.com/skpjr001/oneclick-kra-sftp-sync/sftpsync => ../sftpsync
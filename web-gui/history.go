@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/skpjr001/oneclick-kra-sftp-sync/sftpsync"
+)
+
+// historyListLimit is how many runs /api/history returns unless ?limit= says otherwise
+const historyListLimit = 50
+
+// openHistory opens the run history named by the configuration on disk
+func openHistory() (*sftpsync.History, error) {
+	config, err := sftpsync.LoadConfig("config.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+	file, err := sftpsync.HistoryFileFor(sftpsync.ConvertToSyncConfig(config.Sync))
+	if err != nil {
+		return nil, err
+	}
+	return sftpsync.OpenHistory(file)
+}
+
+// historyAPIHandler returns the latest runs without their files, or with ?id=
+// one run with its files
+func (w *WebGUI) historyAPIHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	history, err := openHistory()
+	if err != nil {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer history.Close()
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		run, err := history.Run(id)
+		if errors.Is(err, sftpsync.ErrRunNotFound) {
+			rw.WriteHeader(http.StatusNotFound)
+		}
+		if err != nil {
+			json.NewEncoder(rw).Encode(map[string]interface{}{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": true,
+			"run":     run,
+		})
+		return
+	}
+
+	limit := historyListLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			http.Error(rw, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	runs, err := history.Runs(limit)
+	if err != nil {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"success": true,
+		"runs":    runs,
+	})
+}
+
+// historyHandler serves the run history page
+func (w *WebGUI) historyHandler(rw http.ResponseWriter, r *http.Request) {
+	historyHTML := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Run History</title>
+    <meta charset="utf-8">
+    <style>
+        body { font-family: Arial, sans-serif; margin: 20px; background-color: #f5f5f5; }
+        .container { max-width: 1000px; margin: 0 auto; background: white; padding: 20px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        h1 { color: #333; text-align: center; }
+        table { width: 100%; border-collapse: collapse; font-size: 14px; }
+        th, td { padding: 4px 8px; border-bottom: 1px solid #dee2e6; text-align: left; }
+        th { background-color: #f8f9fa; }
+        .runs tbody tr { cursor: pointer; }
+        .runs tbody tr:hover { background-color: #f1f3f5; }
+        .result-success { color: #155724; }
+        .result-partial, .result-cancelled { color: #856404; }
+        .result-failed { color: #721c24; }
+        .run { display: none; margin-top: 20px; }
+        .run-meta { font-size: 14px; color: #495057; line-height: 1.6; }
+        .files-container { max-height: 400px; overflow-y: auto; border: 1px solid #dee2e6; border-radius: 4px; }
+        .files td { font-family: monospace; font-size: 13px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Run History</h1>
+        <table class="runs">
+            <thead><tr><th>Started</th><th>Trigger</th><th>Result</th><th>Duration</th><th>Transferred</th><th>Failed</th><th>Deleted</th><th>Data</th></tr></thead>
+            <tbody id="runs-body"><tr><td colspan="8">Loading...</td></tr></tbody>
+        </table>
+
+        <div id="run" class="run">
+            <h3 id="run-title"></h3>
+            <div id="run-meta" class="run-meta"></div>
+            <h4>Phases</h4>
+            <table>
+                <thead><tr><th>Phase</th><th>Started</th><th>Duration</th><th>Error</th></tr></thead>
+                <tbody id="phases-body"></tbody>
+            </table>
+            <h4>Files</h4>
+            <div class="files-container">
+                <table class="files">
+                    <thead><tr><th>Outcome</th><th>Size</th><th>Duration</th><th>File</th><th>MD5 / Error</th></tr></thead>
+                    <tbody id="files-body"></tbody>
+                </table>
+            </div>
+        </div>
+    </div>
+
+    <script>
+        function formatBytes(bytes) {
+            if (bytes > 1024 * 1024 * 1024) return (bytes / (1024 * 1024 * 1024)).toFixed(2) + ' GB';
+            if (bytes > 1024 * 1024) return (bytes / (1024 * 1024)).toFixed(2) + ' MB';
+            if (bytes > 1024) return (bytes / 1024).toFixed(2) + ' KB';
+            return bytes + ' bytes';
+        }
+
+        // formatDuration renders nanoseconds, as Go encodes durations
+        function formatDuration(ns) {
+            const seconds = ns / 1e9;
+            if (seconds < 1) return Math.round(ns / 1e6) + 'ms';
+            if (seconds < 60) return seconds.toFixed(1) + 's';
+            return Math.floor(seconds / 60) + 'm ' + Math.round(seconds % 60) + 's';
+        }
+
+        function addRow(body, values, className) {
+            const row = document.createElement('tr');
+            values.forEach(value => {
+                const cell = document.createElement('td');
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+            if (className) row.className = className;
+            body.appendChild(row);
+            return row;
+        }
+
+        function loadRuns() {
+            fetch('/api/history')
+                .then(response => response.json())
+                .then(data => {
+                    const body = document.getElementById('runs-body');
+                    body.innerHTML = '';
+                    if (!data.success) {
+                        addRow(body, ['Failed to load history: ' + data.error]);
+                        return;
+                    }
+                    if (!data.runs || !data.runs.length) {
+                        addRow(body, ['No runs recorded yet']);
+                        return;
+                    }
+                    data.runs.forEach(run => {
+                        const s = run.summary || {};
+                        const row = addRow(body, [
+                            new Date(run.started).toLocaleString(), run.trigger, run.result,
+                            formatDuration((new Date(run.finished) - new Date(run.started)) * 1e6),
+                            s.transferred_files || 0, s.failed_files || 0, s.deleted_files || 0, formatBytes(s.total_bytes || 0)
+                        ]);
+                        row.cells[2].className = 'result-' + run.result;
+                        row.onclick = () => showRun(run.id);
+                    });
+                });
+        }
+
+        function showRun(id) {
+            fetch('/api/history?id=' + encodeURIComponent(id))
+                .then(response => response.json())
+                .then(data => {
+                    if (!data.success) {
+                        alert('Failed to load run: ' + data.error);
+                        return;
+                    }
+                    const run = data.run;
+                    document.getElementById('run-title').textContent = 'Run ' + run.id;
+
+                    const meta = document.getElementById('run-meta');
+                    meta.innerHTML = '';
+                    [
+                        'Result: ' + run.result + (run.error ? ' - ' + run.error : ''),
+                        'Trigger: ' + run.trigger + ', configuration ' + run.config_hash,
+                        run.source + ' -> ' + run.destination,
+                        run.dates ? 'Dates: ' + run.dates : '',
+                        'Started ' + new Date(run.started).toLocaleString() + ', finished ' + new Date(run.finished).toLocaleString(),
+                        run.summary ? run.summary.skipped_files + ' files already up to date' : ''
+                    ].filter(line => line).forEach(line => {
+                        const div = document.createElement('div');
+                        div.textContent = line;
+                        meta.appendChild(div);
+                    });
+
+                    const phases = document.getElementById('phases-body');
+                    phases.innerHTML = '';
+                    (run.phases || []).forEach(p => {
+                        addRow(phases, [p.phase, new Date(p.started).toLocaleTimeString(), formatDuration(p.duration), p.error || '']);
+                    });
+
+                    const files = document.getElementById('files-body');
+                    files.innerHTML = '';
+                    (run.files || []).forEach(f => {
+                        addRow(files, [f.outcome, formatBytes(f.size), f.duration ? formatDuration(f.duration) : '', f.path, f.error || f.hash || ''],
+                            f.outcome === 'failed' ? 'result-failed' : '');
+                    });
+                    if (!run.files || !run.files.length) {
+                        addRow(files, ['', '', '', 'No files were transferred or deleted', '']);
+                    }
+
+                    document.getElementById('run').style.display = 'block';
+                    document.getElementById('run').scrollIntoView();
+                });
+        }
+
+        loadRuns();
+    </script>
+</body>
+</html>
+`
+	rw.Header().Set("Content-Type", "text/html")
+	tmpl, _ := template.New("history").Parse(historyHTML)
+	tmpl.Execute(rw, nil)
+}